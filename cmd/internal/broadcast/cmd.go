@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/SB-IM/charoite/pkg/mqttclient"
+	"github.com/SB-IM/charoite/pkg/webrtcapi"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -12,6 +13,7 @@ import (
 	"github.com/urfave/cli/v2/altsrc"
 	"github.com/williamlsh/logging"
 
+	turncmd "github.com/SB-IM/charoite/cmd/internal/turn"
 	"github.com/SB-IM/charoite/internal/broadcast"
 	"github.com/SB-IM/charoite/internal/broadcast/cfg"
 )
@@ -20,9 +22,8 @@ const configFlagName = "config"
 
 // Command returns a broadcast command.
 func Command() *cli.Command {
-	ctx := context.Background()
-
 	var (
+		ctx    context.Context
 		logger zerolog.Logger
 
 		mc mqtt.Client
@@ -31,6 +32,13 @@ func Command() *cli.Command {
 		mqttClientConfigOptions cfg.MQTTClientConfigOptions
 		webRTCConfigOptions     cfg.WebRTCConfigOptions
 		serverConfigOptions     cfg.ServerConfigOptions
+		recorderConfigOptions   cfg.RecorderConfigOptions
+		hlsConfigOptions        cfg.HLSConfigOptions
+		authConfigOptions       cfg.AuthConfigOptions
+		signingConfigOptions    cfg.SigningConfigOptions
+		turnCredentialOptions   cfg.TURNCredentialConfigOptions
+		embeddedTURNOptions     cfg.EmbeddedTURNConfigOptions
+		whipConfigOptions       cfg.WHIPConfigOptions
 	)
 
 	flags := func() (flags []cli.Flag) {
@@ -40,6 +48,13 @@ func Command() *cli.Command {
 			mqttClientFlags(&mqttClientConfigOptions),
 			webRTCFlags(&webRTCConfigOptions),
 			serverFlags(&serverConfigOptions),
+			recorderFlags(&recorderConfigOptions),
+			hlsFlags(&hlsConfigOptions),
+			authFlags(&authConfigOptions),
+			signingFlags(&signingConfigOptions),
+			turnCredentialFlags(&turnCredentialOptions),
+			embeddedTURNFlags(&embeddedTURNOptions),
+			whipFlags(&whipConfigOptions),
 		} {
 			flags = append(flags, v...)
 		}
@@ -62,10 +77,14 @@ func Command() *cli.Command {
 			debug := c.Bool("debug")
 			logging.Debug(debug)
 			logger = log.With().Str("command", "broadcast").Logger()
-			ctx = logger.WithContext(ctx)
+			// Context is cancelled on SIGINT or SIGTERM.
+			ctx = logger.WithContext(c.Context)
 
 			// Initializes MQTT client.
-			mc = mqttclient.NewClient(ctx, mqttConfigOptions)
+			var err error
+			if mc, err = mqttclient.NewClient(ctx, mqttConfigOptions); err != nil {
+				return err
+			}
 			if err := mqttclient.CheckConnectivity(mc, 3*time.Second); err != nil {
 				return err
 			}
@@ -73,10 +92,20 @@ func Command() *cli.Command {
 			return nil
 		},
 		Action: func(c *cli.Context) error {
+			serverConfigOptions.ShutdownTimeout = c.Duration("shutdown_timeout")
+			authConfigOptions.AllowedOrigins = c.StringSlice("auth.allowed_origins")
+			turnCredentialOptions.URLs = c.StringSlice("turn_credentials.urls")
 			svc := broadcast.New(ctx, &cfg.ConfigOptions{
-				WebRTCConfigOptions:     webRTCConfigOptions,
-				MQTTClientConfigOptions: mqttClientConfigOptions,
-				ServerConfigOptions:     serverConfigOptions,
+				WebRTCConfigOptions:         webRTCConfigOptions,
+				MQTTClientConfigOptions:     mqttClientConfigOptions,
+				ServerConfigOptions:         serverConfigOptions,
+				RecorderConfigOptions:       recorderConfigOptions,
+				HLSConfigOptions:            hlsConfigOptions,
+				AuthConfigOptions:           authConfigOptions,
+				SigningConfigOptions:        signingConfigOptions,
+				TURNCredentialConfigOptions: turnCredentialOptions,
+				EmbeddedTURNConfigOptions:   embeddedTURNOptions,
+				WHIPConfigOptions:           whipConfigOptions,
 			})
 			err := svc.Broadcast()
 			if err != nil {
@@ -85,6 +114,10 @@ func Command() *cli.Command {
 			return err
 		},
 		After: func(c *cli.Context) error {
+			if mc != nil {
+				mc.Disconnect(250) // Wait 250 milliseconds for pending work to complete.
+				logger.Info().Msg("disconnected MQTT client")
+			}
 			logger.Info().Msg("exits")
 			return nil
 		},
@@ -134,6 +167,30 @@ func mqttFlags(options *mqttclient.ConfigOptions) []cli.Flag {
 			Value:       "",
 			Destination: &options.Password,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.ca_file",
+			Usage:       "PEM CA file verifying MQTT broker of ssl:// or wss:// server, empty uses system CAs",
+			Value:       "",
+			Destination: &options.CAFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.cert_file",
+			Usage:       "PEM client certificate file for mutual TLS with MQTT broker",
+			Value:       "",
+			Destination: &options.CertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.key_file",
+			Usage:       "PEM private key file of MQTT client certificate",
+			Value:       "",
+			Destination: &options.KeyFile,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "mqtt.insecure_skip_verify",
+			Usage:       "Don't verify MQTT broker certificate, for testing only",
+			DefaultText: "false",
+			Destination: &options.InsecureSkipVerify,
+		}),
 	}
 }
 
@@ -221,6 +278,20 @@ func webRTCFlags(options *cfg.WebRTCConfigOptions) []cli.Flag {
 			DefaultText: "false",
 			Destination: &options.EnableFrontend,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "webrtc.pli_interval",
+			Usage:       "Interval of periodic PLI to publishers, 0 requests key frames only when viewers need them",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.PLIInterval,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "webrtc.nack_buffer_size",
+			Usage:       "Packets kept for every stream sent to answer NACKs, a power of two up to 32768",
+			Value:       webrtcapi.DefaultNACKBufferSize,
+			DefaultText: "1024",
+			Destination: &options.NACKBufferSize,
+		}),
 	}
 }
 
@@ -240,5 +311,201 @@ func serverFlags(options *cfg.ServerConfigOptions) []cli.Flag {
 			DefaultText: "8080",
 			Destination: &options.Port,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "signal_server.tls_cert_file",
+			Usage:       "PEM certificate file of HTTPS and WSS, it's reloaded after file changes. Empty serves plain HTTP",
+			Value:       "",
+			Destination: &options.TLSCertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "signal_server.tls_key_file",
+			Usage:       "PEM private key file of TLS certificate",
+			Value:       "",
+			Destination: &options.TLSKeyFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "signal_server.tls_client_ca_file",
+			Usage:       "PEM CA file verifying client certificates for mutual TLS. Empty doesn't verify clients",
+			Value:       "",
+			Destination: &options.TLSClientCAFile,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "signal_server.redirect_port",
+			Usage:       "Port of plain HTTP listener redirecting to HTTPS, 0 disables it",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.RedirectPort,
+		}),
+	}
+}
+
+func recorderFlags(options *cfg.RecorderConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "recorder.dir",
+			Usage:       "Directory of session recordings",
+			Value:       "recordings",
+			DefaultText: "recordings",
+			Destination: &options.Dir,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "recorder.segment_duration",
+			Usage:       "Duration of recording segment files, 0 writes a single file",
+			Value:       10 * time.Minute,
+			DefaultText: "10m",
+			Destination: &options.SegmentDuration,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "recorder.retention",
+			Usage:       "Age after which recording files are deleted, 0 keeps them forever",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.Retention,
+		}),
+	}
+}
+
+func hlsFlags(options *cfg.HLSConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "hls.segment_duration",
+			Usage:       "Target duration of HLS segments, segments are cut at key frames",
+			Value:       2 * time.Second,
+			DefaultText: "2s",
+			Destination: &options.SegmentDuration,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "hls.part_duration",
+			Usage:       "Target duration of LL-HLS partial segments",
+			Value:       500 * time.Millisecond,
+			DefaultText: "500ms",
+			Destination: &options.PartDuration,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "hls.segments",
+			Usage:       "Number of segments in HLS playlist",
+			Value:       7,
+			DefaultText: "7",
+			Destination: &options.Segments,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "hls.idle_timeout",
+			Usage:       "Duration without requests after which HLS packaging of a session stops",
+			Value:       30 * time.Second,
+			DefaultText: "30s",
+			Destination: &options.IdleTimeout,
+		}),
+	}
+}
+
+// authFlags sets auth options, AllowedOrigins is set from context as a string slice flag has no []string destination.
+func authFlags(options *cfg.AuthConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "auth.enable",
+			Usage:       "Require viewers to carry a bearer JWT in Authorization header or access_token query parameter",
+			DefaultText: "false",
+			Destination: &options.Enable,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth.algorithm",
+			Usage:       "JWT signing algorithm, available algorithms are: HS256, RS256",
+			Value:       "HS256",
+			DefaultText: "HS256",
+			Destination: &options.Algorithm,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth.key_file",
+			Usage:       "File of HS256 secret or RS256 PEM public key",
+			Value:       "",
+			Destination: &options.KeyFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth.issuer",
+			Usage:       "Required issuer of JWT, empty accepts any issuer",
+			Value:       "",
+			Destination: &options.Issuer,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "auth.audience",
+			Usage:       "Required audience of JWT, empty accepts any audience",
+			Value:       "",
+			Destination: &options.Audience,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:  "auth.allowed_origins",
+			Usage: "Origin patterns of viewer webSocket connections besides same origin, \"*\" allows every origin, empty allows same origin only",
+		}),
+	}
+}
+
+func signingFlags(options *cfg.SigningConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "signing.verify",
+			Usage:       "Reject offers and candidates of edge devices not signed by their Ed25519 keys, and all WHIP offers as they're not signed",
+			DefaultText: "false",
+			Destination: &options.Verify,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "signing.key_dir",
+			Usage:       "Directory of PEM Ed25519 public keys of edge devices, named <machine id>.pem",
+			Value:       "config/signing",
+			DefaultText: "config/signing",
+			Destination: &options.KeyDir,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "signing.max_age",
+			Usage:       "Offers and candidates signed longer ago are rejected as replayed",
+			Value:       30 * time.Second,
+			DefaultText: "30s",
+			Destination: &options.MaxAge,
+		}),
+	}
+}
+
+func whipFlags(options *cfg.WHIPConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "whip.token",
+			Usage:       "Bearer token required by WHIP ingress and deletion of WHIP resources, empty rejects all WHIP requests",
+			Value:       "",
+			Destination: &options.Token,
+		}),
+	}
+}
+
+func turnCredentialFlags(options *cfg.TURNCredentialConfigOptions) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:  "turn_credentials.urls",
+			Usage: "TURN URLs issued to viewers and WHIP publishers with time-limited credentials in signaling answers, empty issues none",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn_credentials.shared_secret",
+			Usage:       "Shared secret of TURN REST API credentials, the same as turn.shared_secret of TURN server",
+			Value:       "",
+			Destination: &options.SharedSecret,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "turn_credentials.ttl",
+			Usage:       "Lifetime of TURN credentials issued to viewers and WHIP publishers",
+			Value:       time.Hour,
+			DefaultText: "1h",
+			Destination: &options.TTL,
+		}),
 	}
 }
+
+// embeddedTURNFlags are flags of turn command, and whether to embed TURN server, so that [turn] section of config is shared.
+func embeddedTURNFlags(options *cfg.EmbeddedTURNConfigOptions) []cli.Flag {
+	return append([]cli.Flag{
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "turn.embed",
+			Usage:       "Start TURN server in broadcast process and advertise it as ICE server to publishers and viewers instead of webrtc.ice_server, turn.public_ip must not be loopback",
+			Value:       false,
+			DefaultText: "false",
+			Destination: &options.Embed,
+		}),
+	}, turncmd.ConfigFlags(&options.TURN)...)
+}
@@ -2,6 +2,7 @@ package livestream
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/SB-IM/charoite/pkg/mqttclient"
@@ -13,25 +14,28 @@ import (
 	"github.com/williamlsh/logging"
 
 	"github.com/SB-IM/charoite/internal/livestream"
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
 )
 
 const configFlagName = "config"
 
 // Command returns a livestream command.
 func Command() *cli.Command {
-	ctx := context.Background()
-
 	var (
+		ctx    context.Context
 		logger zerolog.Logger
 
 		mc mqtt.Client
 
 		uuid                      string
+		signingKeyFile            string
+		signer                    *pb.Signer
 		mqttConfigOptions         mqttclient.ConfigOptions
 		mqttClientConfigOptions   livestream.MQTTClientConfigOptions
 		webRTCConfigOptions       livestream.WebRTCConfigOptions
 		droneStreamConfigOptions  livestream.StreamSource
 		deportStreamConfigOptions livestream.StreamSource
+		streams                   []livestream.StreamSource // Stream sources of [[streams]] list, it overrides drone and deport stream.
 	)
 
 	flags := func() (flags []cli.Flag) {
@@ -43,6 +47,7 @@ func Command() *cli.Command {
 			webRTCFlags(&webRTCConfigOptions),
 			droneStreamFlags(&droneStreamConfigOptions),
 			deportStreamFlags(&deportStreamConfigOptions),
+			signingFlags(&signingKeyFile),
 		} {
 			flags = append(flags, v...)
 		}
@@ -56,19 +61,32 @@ func Command() *cli.Command {
 		Before: func(c *cli.Context) error {
 			if err := altsrc.InitInputSourceWithContext(
 				flags,
-				altsrc.NewTomlSourceFromFlagFunc(configFlagName),
+				tomlSourceFromFlagFunc(configFlagName, &streams),
 			)(c); err != nil {
 				return err
 			}
+			if err := livestream.ValidateWebRTC(&webRTCConfigOptions); err != nil {
+				return err
+			}
+			if signingKeyFile != "" {
+				var err error
+				if signer, err = pb.NewSigner(signingKeyFile); err != nil {
+					return err
+				}
+			}
 
 			// Set up logger.
 			debug := c.Bool("debug")
 			logging.Debug(debug)
 			logger = log.With().Str("command", "livestream").Logger()
-			ctx = logger.WithContext(ctx)
+			// Context is cancelled on SIGINT or SIGTERM.
+			ctx = logger.WithContext(c.Context)
 
 			// Initializes MQTT client.
-			mc = mqttclient.NewClient(ctx, mqttConfigOptions)
+			var err error
+			if mc, err = mqttclient.NewClient(ctx, mqttConfigOptions); err != nil {
+				return err
+			}
 			if err := mqttclient.CheckConnectivity(mc, 3*time.Second); err != nil {
 				return err
 			}
@@ -78,36 +96,85 @@ func Command() *cli.Command {
 		},
 		Action: func(c *cli.Context) error {
 			// Publish live stream.
-			dronePublisher := livestream.NewDronePublisher(ctx, &livestream.PublisherConfigOptions{
-				UUID:                    uuid,
-				MQTTClientConfigOptions: mqttClientConfigOptions,
-				WebRTCConfigOptions:     webRTCConfigOptions,
-				StreamSource:            droneStreamConfigOptions,
-			})
-			deportPublisher := livestream.NewDeportPublisher(ctx, &livestream.PublisherConfigOptions{
-				UUID:                    uuid,
-				MQTTClientConfigOptions: mqttClientConfigOptions,
-				WebRTCConfigOptions:     webRTCConfigOptions,
-				StreamSource:            deportStreamConfigOptions,
-			})
+			var publishers []livestream.Livestream
+			if len(streams) == 0 {
+				publishers = []livestream.Livestream{
+					livestream.NewDronePublisher(ctx, &livestream.PublisherConfigOptions{
+						UUID:                    uuid,
+						MQTTClientConfigOptions: mqttClientConfigOptions,
+						WebRTCConfigOptions:     webRTCConfigOptions,
+						StreamSource:            droneStreamConfigOptions,
+						Signer:                  signer,
+					}),
+					livestream.NewDeportPublisher(ctx, &livestream.PublisherConfigOptions{
+						UUID:                    uuid,
+						MQTTClientConfigOptions: mqttClientConfigOptions,
+						WebRTCConfigOptions:     webRTCConfigOptions,
+						StreamSource:            deportStreamConfigOptions,
+						Signer:                  signer,
+					}),
+				}
+			} else {
+				logger.Info().Int("streams", len(streams)).Msg("publishing streams list, drone_stream and deport_stream are ignored")
+				for _, source := range streams {
+					p, err := livestream.NewPublisher(ctx, &livestream.PublisherConfigOptions{
+						UUID:                    uuid,
+						MQTTClientConfigOptions: mqttClientConfigOptions,
+						WebRTCConfigOptions:     webRTCConfigOptions,
+						StreamSource:            source,
+						Signer:                  signer,
+					})
+					if err != nil {
+						return err
+					}
+					publishers = append(publishers, p)
+				}
+			}
 
-			errChan := make(chan error, 2)
-			for _, s := range []livestream.Livestream{dronePublisher, deportPublisher} {
+			var wg sync.WaitGroup
+			errChan := make(chan error, len(publishers))
+			for _, s := range publishers {
 				s := s
+				wg.Add(1)
 				go func() {
+					defer wg.Done()
 					if err := s.Publish(); err != nil {
 						logger.Err(err).
 							Str("id", s.Meta().Id).
 							Int32("track_source", int32(s.Meta().TrackSource)).
+							Str("stream", s.Meta().Stream).
 							Msg(
 								"live stream publishing failed")
 						errChan <- err
 					}
 				}()
 			}
-			return <-errChan
+
+			select {
+			case err := <-errChan:
+				return err
+			case <-ctx.Done():
+				logger.Info().Msg("shutting down")
+			}
+
+			// Wait for publishers to close their peer connections.
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(c.Duration("shutdown_timeout")):
+				logger.Warn().Msg("timed out waiting for publishers to shut down")
+			}
+			return nil
 		},
 		After: func(c *cli.Context) error {
+			if mc != nil {
+				mc.Disconnect(250) // Wait 250 milliseconds for pending work to complete.
+				logger.Info().Msg("disconnected MQTT client")
+			}
 			logger.Info().Msg("exits")
 			return nil
 		},
@@ -169,6 +236,30 @@ func mqttFlags(options *mqttclient.ConfigOptions) []cli.Flag {
 			Value:       "",
 			Destination: &options.Password,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.ca_file",
+			Usage:       "PEM CA file verifying MQTT broker of ssl:// or wss:// server, empty uses system CAs",
+			Value:       "",
+			Destination: &options.CAFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.cert_file",
+			Usage:       "PEM client certificate file for mutual TLS with MQTT broker",
+			Value:       "",
+			Destination: &options.CertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "mqtt.key_file",
+			Usage:       "PEM private key file of MQTT client certificate",
+			Value:       "",
+			Destination: &options.KeyFile,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "mqtt.insecure_skip_verify",
+			Usage:       "Don't verify MQTT broker certificate, for testing only",
+			DefaultText: "false",
+			Destination: &options.InsecureSkipVerify,
+		}),
 	}
 }
 
@@ -248,6 +339,19 @@ func webRTCFlags(options *livestream.WebRTCConfigOptions) []cli.Flag {
 			DefaultText: "",
 			Destination: &options.Credential,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "webrtc.congestion_control",
+			Usage:       "How publisher reacts to uplink bandwidth estimate, available values are: none, drop_frames, command",
+			Value:       "none",
+			DefaultText: "none",
+			Destination: &options.CongestionControl,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "webrtc.bitrate_command",
+			Usage:       "Command run with estimated bitrate in bits per second as its last argument, it's used by command congestion control",
+			Value:       "",
+			Destination: &options.BitrateCommand,
+		}),
 	}
 }
 
@@ -286,6 +390,26 @@ func droneStreamFlags(options *livestream.StreamSource) []cli.Flag {
 			DefaultText: "false",
 			Destination: &options.ConsumeStreamOnDemand,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "drone_stream.video_codec",
+			Usage:       "Video codec of drone stream source, available codecs are: h264, h265, vp8, vp9. vp8 and vp9 are available for rtp only",
+			Value:       "h264",
+			DefaultText: "h264",
+			Destination: &options.VideoCodec,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "drone_stream.audio_codec",
+			Usage:       "Audio codec of drone stream source, available codecs are: opus, pcmu, pcma. Empty disables audio",
+			Value:       "",
+			Destination: &options.AudioCodec,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "drone_stream.audio_port",
+			Usage:       "Port of RTP audio stream, 0 disables audio for RTP stream source",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.AudioPort,
+		}),
 	}
 }
 
@@ -324,5 +448,36 @@ func deportStreamFlags(options *livestream.StreamSource) []cli.Flag {
 			DefaultText: "false",
 			Destination: &options.ConsumeStreamOnDemand,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "deport_stream.video_codec",
+			Usage:       "Video codec of deport stream source, available codecs are: h264, h265, vp8, vp9. vp8 and vp9 are available for rtp only",
+			Value:       "h264",
+			DefaultText: "h264",
+			Destination: &options.VideoCodec,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "deport_stream.audio_codec",
+			Usage:       "Audio codec of deport stream source, available codecs are: opus, pcmu, pcma. Empty disables audio",
+			Value:       "",
+			Destination: &options.AudioCodec,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "deport_stream.audio_port",
+			Usage:       "Port of RTP audio stream, 0 disables audio for RTP stream source",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.AudioPort,
+		}),
+	}
+}
+
+func signingFlags(keyFile *string) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "signing.private_key_file",
+			Usage:       "PEM PKCS #8 Ed25519 private key file signing offers and candidates, empty sends them unsigned",
+			Value:       "",
+			Destination: keyFile,
+		}),
 	}
 }
@@ -0,0 +1,108 @@
+package livestream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+
+	"github.com/SB-IM/charoite/internal/livestream"
+)
+
+// streamConfig is an entry of [[streams]] list in config file.
+// The list can't be expressed by command line flags, so it's decoded from config file directly.
+type streamConfig struct {
+	Name                  string `toml:"name"`
+	TrackSource           string `toml:"track_source"` // drone or monitor
+	Protocol              string `toml:"protocol"`
+	Addr                  string `toml:"addr"`
+	Host                  string `toml:"host"`
+	Port                  int    `toml:"port"`
+	ConsumeStreamOnDemand bool   `toml:"consume_stream_on_demand"`
+	VideoCodec            string `toml:"video_codec"`
+	AudioCodec            string `toml:"audio_codec"`
+	AudioPort             int    `toml:"audio_port"`
+}
+
+// tomlSourceFromFlagFunc is like altsrc.NewTomlSourceFromFlagFunc, but it also loads [[streams]] list into streams.
+// altsrc can't load a TOML file with array of tables, so the list is removed before creating input source.
+func tomlSourceFromFlagFunc(
+	flagFileName string,
+	streams *[]livestream.StreamSource,
+) func(c *cli.Context) (altsrc.InputSourceContext, error) {
+	return func(c *cli.Context) (altsrc.InputSourceContext, error) {
+		if !c.IsSet(flagFileName) {
+			return altsrc.NewMapInputSource("", map[interface{}]interface{}{}), nil
+		}
+		path := c.String(flagFileName)
+
+		var config struct {
+			Streams []streamConfig `toml:"streams"`
+		}
+		if _, err := toml.DecodeFile(path, &config); err != nil {
+			return nil, fmt.Errorf("could not decode TOML file %s: %w", path, err)
+		}
+		var err error
+		if *streams, err = streamSources(config.Streams); err != nil {
+			return nil, err
+		}
+
+		var values map[string]interface{}
+		if _, err := toml.DecodeFile(path, &values); err != nil {
+			return nil, fmt.Errorf("could not decode TOML file %s: %w", path, err)
+		}
+		delete(values, "streams")
+		return altsrc.NewMapInputSource(path, inputSourceMap(values)), nil
+	}
+}
+
+// streamSources converts and validates [[streams]] list.
+func streamSources(configs []streamConfig) ([]livestream.StreamSource, error) {
+	streams := make([]livestream.StreamSource, 0, len(configs))
+	for _, s := range configs {
+		source := livestream.StreamSource{
+			Name:                    s.Name,
+			TrackSource:             pb.TrackSource(pb.TrackSource_value[strings.ToUpper(s.TrackSource)]),
+			Protocol:                s.Protocol,
+			RTSPSourceConfigOptions: livestream.RTSPSourceConfigOptions{Addr: s.Addr},
+			RTPOrRTMPSourceConfigOptions: livestream.RTPOrRTMPSourceConfigOptions{
+				Host:      s.Host,
+				Port:      s.Port,
+				AudioPort: s.AudioPort,
+			},
+			ConsumeStreamOnDemand: s.ConsumeStreamOnDemand,
+			VideoCodec:            s.VideoCodec,
+			AudioCodec:            s.AudioCodec,
+		}
+		if source.Host == "" {
+			source.Host = "0.0.0.0"
+		}
+		if source.VideoCodec == "" {
+			source.VideoCodec = "h264"
+		}
+		streams = append(streams, source)
+	}
+	if err := livestream.ValidateStreams(streams); err != nil {
+		return nil, err
+	}
+	return streams, nil
+}
+
+// inputSourceMap converts decoded TOML values to the form altsrc.MapInputSource reads.
+func inputSourceMap(values map[string]interface{}) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		switch v := v.(type) {
+		case int64:
+			m[k] = int(v)
+		case map[string]interface{}:
+			m[k] = inputSourceMap(v)
+		default:
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -1,10 +1,6 @@
 package turn
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
-
 	"github.com/SB-IM/charoite/internal/turn"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -24,7 +20,7 @@ func Command() *cli.Command {
 	flags := func() (flags []cli.Flag) {
 		for _, v := range [][]cli.Flag{
 			loadConfigFlag(),
-			turnConfigFlags(&turnConfigOptions),
+			ConfigFlags(&turnConfigOptions),
 		} {
 			flags = append(flags, v...)
 		}
@@ -50,16 +46,9 @@ func Command() *cli.Command {
 			return nil
 		},
 		Action: func(c *cli.Context) error {
-			s, err := turn.Serve(&logger, &turnConfigOptions)
-			if err != nil {
-				return err
-			}
-
-			sigs := make(chan os.Signal, 1)
-			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-			<-sigs
-
-			return s.Close()
+			// Context is cancelled on SIGINT or SIGTERM.
+			turnConfigOptions.ShutdownTimeout = c.Duration("shutdown_timeout")
+			return turn.Serve(c.Context, &logger, &turnConfigOptions)
 		},
 		After: func(c *cli.Context) error {
 			logger.Info().Msg("exits")
@@ -83,7 +72,8 @@ func loadConfigFlag() []cli.Flag {
 	}
 }
 
-func turnConfigFlags(options *turn.ConfigOptions) []cli.Flag {
+// ConfigFlags returns flags of TURN server options, they're shared by broadcast command to embed TURN server.
+func ConfigFlags(options *turn.ConfigOptions) []cli.Flag {
 	return []cli.Flag{
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "turn.public_ip",
@@ -101,7 +91,7 @@ func turnConfigFlags(options *turn.ConfigOptions) []cli.Flag {
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "turn.username",
-			Usage:       "Username",
+			Usage:       "Username of static user, empty disables it",
 			Value:       "user",
 			DefaultText: "user",
 			Destination: &options.Username,
@@ -113,6 +103,88 @@ func turnConfigFlags(options *turn.ConfigOptions) []cli.Flag {
 			DefaultText: "password",
 			Destination: &options.Password,
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "turn.ipv6",
+			Usage:       "Listen on udp6 as well, TCP and TLS listeners are dual stack",
+			DefaultText: "false",
+			Destination: &options.IPv6,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "turn.tcp",
+			Usage:       "Listen on TCP of listening port as well, for clients behind UDP blocking firewalls",
+			DefaultText: "false",
+			Destination: &options.TCP,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "turn.tls_port",
+			Usage:       "Listening port of TURN over TLS, e.g. 5349 or 443, 0 disables it",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.TLSPort,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.tls_cert_file",
+			Usage:       "PEM certificate file of TURN over TLS, it's reloaded after file changes",
+			Value:       "",
+			Destination: &options.TLSCertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.tls_key_file",
+			Usage:       "PEM private key file of TLS certificate",
+			Value:       "",
+			Destination: &options.TLSKeyFile,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "turn.max_allocations_per_user",
+			Usage:       "Maximum active allocations of a user, 0 is unlimited",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.MaxAllocationsPerUser,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "turn.allocation_bandwidth",
+			Usage:       "Bits per second relayed of each direction of an allocation, packets over it are dropped, 0 is unlimited",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.AllocationBandwidth,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:        "turn.idle_timeout",
+			Usage:       "Allocations without relayed packets for this duration are deleted, 0 keeps them until they expire",
+			Value:       0,
+			DefaultText: "0",
+			Destination: &options.IdleTimeout,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.usage_log_file",
+			Usage:       "File appended with a JSON line of relayed bytes of every allocation deleted, empty disables it",
+			Value:       "",
+			Destination: &options.UsageLogFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.admin_addr",
+			Usage:       "Listening address of admin HTTP API of allocations and health, e.g. 127.0.0.1:8081, empty disables it",
+			Value:       "",
+			Destination: &options.AdminAddr,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.admin_token",
+			Usage:       "Bearer token required by admin allocation endpoints, empty allows all requests and requires a loopback admin address",
+			Value:       "",
+			Destination: &options.AdminToken,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.users_file",
+			Usage:       "File of users, a \"<username>:<hex key>\" line for each user, it's reloaded after it changes",
+			Value:       "",
+			Destination: &options.UsersFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "turn.shared_secret",
+			Usage:       "Shared secret of time-limited TURN REST API credentials, empty disables them",
+			Value:       "",
+			Destination: &options.SharedSecret,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "turn.realm",
 			Usage:       "Realm",
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	stdlog "log"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof" // pprof
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/SB-IM/charoite/cmd/internal/info"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 )
@@ -46,8 +50,29 @@ func run(args []string) error {
 				DefaultText: "false",
 				EnvVars:     []string{"PROFILE"},
 			},
+			&cli.DurationFlag{
+				Name:        "shutdown_timeout",
+				Value:       10 * time.Second,
+				Usage:       "deadline of graceful shutdown after receiving SIGINT or SIGTERM",
+				DefaultText: "10s",
+				EnvVars:     []string{"SHUTDOWN_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics",
+				Value:   "",
+				Usage:   "serve Prometheus metrics at /metrics on this address, e.g. :9090. Empty disables metrics",
+				EnvVars: []string{"METRICS"},
+			},
 		},
 		Before: func(c *cli.Context) error {
+			if addr := c.String("metrics"); addr != "" {
+				go func() {
+					mux := http.NewServeMux()
+					mux.Handle("/metrics", promhttp.Handler())
+					stdlog.Printf("Starting metrics server at %s", addr)
+					stdlog.Fatal(http.ListenAndServe(addr, mux))
+				}()
+			}
 			if !c.Bool("profile") {
 				return nil
 			}
@@ -60,5 +85,9 @@ func run(args []string) error {
 		Commands: commands,
 	}
 
-	return app.Run(args)
+	// Commands are notified to shut down gracefully by cancelling context.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return app.RunContext(ctx, args)
 }
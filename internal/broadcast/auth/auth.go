@@ -0,0 +1,160 @@
+// Package auth authorizes viewers subscribing to streams.
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+)
+
+const (
+	algorithmHS256 = "HS256"
+	algorithmRS256 = "RS256"
+)
+
+// AllMachines is the machine id of a stream claim permitting every machine.
+const AllMachines = "*"
+
+// ErrNoToken is returned if a request carries no bearer token.
+var ErrNoToken = errors.New("no bearer token")
+
+// Authorizer authorizes viewers by credentials of their requests.
+// It's pluggable, so that viewers can be authorized by other means than JWT.
+type Authorizer interface {
+	// Authorize returns what a request is granted, or an error if it has no valid credentials.
+	Authorize(r *http.Request) (Grant, error)
+}
+
+// Grant tells which streams a viewer is permitted to subscribe to.
+type Grant interface {
+	Allows(meta *pb.Meta) bool
+}
+
+// New returns a JWT authorizer if authorization is enabled, otherwise every viewer is allowed.
+func New(config *cfg.AuthConfigOptions) (Authorizer, error) {
+	if !config.Enable {
+		return AllowAll, nil
+	}
+	return NewJWT(config)
+}
+
+// AllowAll permits every viewer to subscribe to every stream.
+var AllowAll Authorizer = allowAll{}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(_ *http.Request) (Grant, error) {
+	return allowAll{}, nil
+}
+
+func (allowAll) Allows(_ *pb.Meta) bool {
+	return true
+}
+
+// Claims are claims of a viewer token.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	// Streams are what viewer is permitted to subscribe to.
+	Streams []StreamClaim `json:"streams"`
+}
+
+// StreamClaim permits subscribing to streams of a machine.
+type StreamClaim struct {
+	ID           string           `json:"id"`                      // Machine id, "*" permits every machine.
+	TrackSources []pb.TrackSource `json:"track_sources,omitempty"` // Track sources in numeric form, empty permits every track source.
+}
+
+// Allows reports whether claims permit subscribing to stream of meta, claims expire in the middle of a webSocket connection.
+func (c *Claims) Allows(meta *pb.Meta) bool {
+	if c.ExpiresAt != nil && time.Now().After(c.ExpiresAt.Time) {
+		return false
+	}
+	for _, s := range c.Streams {
+		if s.ID != AllMachines && s.ID != meta.Id {
+			continue
+		}
+		if len(s.TrackSources) == 0 {
+			return true
+		}
+		for _, trackSource := range s.TrackSources {
+			if trackSource == meta.TrackSource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWT authorizes viewers by bearer JSON web tokens signed with a local key.
+type JWT struct {
+	parser *jwt.Parser
+	key    interface{}
+}
+
+// NewJWT returns a JWT authorizer with key file of config.
+// Key file is a secret for HS256, trailing whitespace is trimmed, or a PEM encoded public key for RS256.
+func NewJWT(config *cfg.AuthConfigOptions) (*JWT, error) {
+	b, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	var key interface{}
+	switch config.Algorithm {
+	case algorithmHS256:
+		secret := bytes.TrimSpace(b)
+		if len(secret) == 0 {
+			return nil, errors.New("empty HS256 secret")
+		}
+		key = secret
+	case algorithmRS256:
+		if key, err = jwt.ParseRSAPublicKeyFromPEM(b); err != nil {
+			return nil, fmt.Errorf("could not parse RS256 public key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %q", config.Algorithm)
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{config.Algorithm})}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	return &JWT{parser: jwt.NewParser(options...), key: key}, nil
+}
+
+// Authorize verifies bearer token of request, it's in Authorization header,
+// or in access_token query parameter as browsers can't set headers of webSocket requests.
+func (j *JWT) Authorize(r *http.Request) (Grant, error) {
+	token := r.URL.Query().Get("access_token")
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credentials, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, ErrNoToken
+		}
+		token = strings.TrimSpace(credentials)
+	}
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	var claims Claims
+	if _, err := j.parser.ParseWithClaims(token, &claims, func(_ *jwt.Token) (interface{}, error) {
+		return j.key, nil
+	}); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return &claims, nil
+}
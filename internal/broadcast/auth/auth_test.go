@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+)
+
+func writeKeyFile(t *testing.T, b []byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(name, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestJWTAuthorize(t *testing.T) {
+	secret := []byte("secret")
+	authorizer, err := NewJWT(&cfg.AuthConfigOptions{
+		Algorithm: algorithmHS256,
+		KeyFile:   writeKeyFile(t, append(secret, '\n')),
+		Issuer:    "charoite",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, key interface{}, claims *Claims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	claims := func(issuer string, expiresAt time.Time) *Claims {
+		return &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Issuer: issuer, ExpiresAt: jwt.NewNumericDate(expiresAt)},
+			Streams:          []StreamClaim{{ID: "abc", TrackSources: []pb.TrackSource{pb.TrackSource_DRONE}}},
+		}
+	}
+	valid := sign(jwt.SigningMethodHS256, secret, claims("charoite", time.Now().Add(time.Hour)))
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		query  string
+		ok     bool
+	}{
+		{"bearer header", "Bearer " + valid, "", true},
+		{"query parameter", "", "?access_token=" + valid, true},
+		{"no token", "", "", false},
+		{"other scheme", "Basic " + valid, "", false},
+		{"wrong secret", "Bearer " + sign(jwt.SigningMethodHS256, []byte("other"), claims("charoite", time.Now().Add(time.Hour))), "", false},
+		{"wrong algorithm", "Bearer " + sign(jwt.SigningMethodHS384, secret, claims("charoite", time.Now().Add(time.Hour))), "", false},
+		{"wrong issuer", "Bearer " + sign(jwt.SigningMethodHS256, secret, claims("other", time.Now().Add(time.Hour))), "", false},
+		{"expired", "Bearer " + sign(jwt.SigningMethodHS256, secret, claims("charoite", time.Now().Add(-time.Minute))), "", false},
+	} {
+		r := httptest.NewRequest("GET", "/v1/broadcast/signal"+tc.query, nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		grant, err := authorizer.Authorize(r)
+		if (err == nil) != tc.ok {
+			t.Fatalf("%s: got error %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if tc.ok && !grant.Allows(&pb.Meta{Id: "abc", TrackSource: pb.TrackSource_DRONE}) {
+			t.Fatalf("%s: stream in claims is not allowed", tc.name)
+		}
+	}
+}
+
+func TestJWTAuthorizeRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer, err := NewJWT(&cfg.AuthConfigOptions{
+		Algorithm: algorithmRS256,
+		KeyFile:   writeKeyFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, &Claims{Streams: []StreamClaim{{ID: AllMachines}}}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/v1/broadcast/signal", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	grant, err := authorizer.Authorize(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !grant.Allows(&pb.Meta{Id: "any", TrackSource: pb.TrackSource_MONITOR}) {
+		t.Fatal("stream of any machine is not allowed")
+	}
+}
+
+func TestClaimsAllows(t *testing.T) {
+	claims := &Claims{Streams: []StreamClaim{
+		{ID: "abc", TrackSources: []pb.TrackSource{pb.TrackSource_DRONE}},
+		{ID: "def"},
+	}}
+	for _, tc := range []struct {
+		name string
+		meta *pb.Meta
+		want bool
+	}{
+		{"permitted track source", &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_DRONE}, true},
+		{"other track source", &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR}, false},
+		{"every track source", &pb.Meta{Id: "def", TrackSource: pb.TrackSource_MONITOR}, true},
+		{"other machine", &pb.Meta{Id: "ghi", TrackSource: pb.TrackSource_DRONE}, false},
+	} {
+		if got := claims.Allows(tc.meta); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second))
+	if claims.Allows(&pb.Meta{Id: "def", TrackSource: pb.TrackSource_DRONE}) {
+		t.Error("expired claims allow stream")
+	}
+}
@@ -2,53 +2,181 @@ package broadcast
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
 	"github.com/SB-IM/charoite/pkg/mqttclient"
+	"github.com/SB-IM/charoite/pkg/tlsx"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
 	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/hls"
 	"github.com/SB-IM/charoite/internal/broadcast/publisher"
+	"github.com/SB-IM/charoite/internal/broadcast/recorder"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
 	"github.com/SB-IM/charoite/internal/broadcast/subscriber"
 )
 
+// certReloadInterval is how often TLS certificate files are checked for changes.
+const certReloadInterval = 10 * time.Second
+
 // Service consists of many sessions.
 type Service struct {
+	ctx      context.Context
 	client   mqtt.Client
 	logger   zerolog.Logger
 	config   cfg.ConfigOptions
-	sessions sync.Map
+	sessions *session.Registry
 }
 
 func New(ctx context.Context, config *cfg.ConfigOptions) *Service {
 	return &Service{
-		client: mqttclient.FromContext(ctx),
-		logger: *log.Ctx(ctx),
-		config: *config,
+		ctx:      ctx,
+		client:   mqttclient.FromContext(ctx),
+		logger:   *log.Ctx(ctx),
+		config:   *config,
+		sessions: session.NewRegistry(),
 	}
 }
 
 func (s *Service) Broadcast() error {
-	pub := publisher.New(s.client, &s.sessions, &s.logger, &cfg.PublisherConfigOptions{
-		MQTTClientConfigOptions: s.config.MQTTClientConfigOptions,
-		WebRTCConfigOptions:     s.config.WebRTCConfigOptions,
-	})
+	turnErr := make(chan error, 1)
+	if s.config.EmbeddedTURNConfigOptions.Embed {
+		if err := s.embedTURN(); err != nil {
+			return err
+		}
+		go s.serveTURN(turnErr)
+		s.logger.Info().Str("ice_server", s.config.ICEServer).Strs("urls", s.config.TURNCredentialConfigOptions.URLs).Msg("embedded TURN server")
+	}
+
+	var verifier *pb.Verifier
+	if s.config.SigningConfigOptions.Verify {
+		var err error
+		if verifier, err = pb.NewVerifier(s.config.SigningConfigOptions.KeyDir, s.config.SigningConfigOptions.MaxAge); err != nil {
+			return fmt.Errorf("could not create signature verifier: %w", err)
+		}
+	}
+	s.logger.Info().Bool("verify", verifier != nil).Str("key_dir", s.config.SigningConfigOptions.KeyDir).Msg("configured signaling signature verification")
+	pub := publisher.New(s.client, s.sessions, &s.logger, &cfg.PublisherConfigOptions{
+		MQTTClientConfigOptions:     s.config.MQTTClientConfigOptions,
+		WebRTCConfigOptions:         s.config.WebRTCConfigOptions,
+		TURNCredentialConfigOptions: s.config.TURNCredentialConfigOptions,
+		WHIPConfigOptions:           s.config.WHIPConfigOptions,
+	}, verifier)
 	pub.Signal()
 
-	sub := subscriber.New(s.client, &s.sessions, &s.logger, &cfg.SubscriberConfigOptions{
-		MQTTClientConfigOptions: s.config.MQTTClientConfigOptions,
-		WebRTCConfigOptions:     s.config.WebRTCConfigOptions,
-	})
-	handler := sub.Signal()
+	authorizer, err := auth.New(&s.config.AuthConfigOptions)
+	if err != nil {
+		return fmt.Errorf("could not create authorizer: %w", err)
+	}
+	s.logger.Info().Bool("enable", s.config.AuthConfigOptions.Enable).Str("algorithm", s.config.AuthConfigOptions.Algorithm).Msg("created viewer authorizer")
+	if len(s.config.TURNCredentialConfigOptions.URLs) != 0 && s.config.TURNCredentialConfigOptions.SharedSecret == "" {
+		return errors.New("empty shared secret of TURN credentials issued to viewers")
+	}
+	sub := subscriber.New(s.client, s.sessions, &s.logger, &cfg.SubscriberConfigOptions{
+		MQTTClientConfigOptions:     s.config.MQTTClientConfigOptions,
+		WebRTCConfigOptions:         s.config.WebRTCConfigOptions,
+		AuthConfigOptions:           s.config.AuthConfigOptions,
+		TURNCredentialConfigOptions: s.config.TURNCredentialConfigOptions,
+	}, authorizer)
+	router := sub.Signal()
+
+	// WHIP ingress for edge devices without MQTT.
+	router.HandleFunc("/v1/broadcast/whip/{id}/{track_source}", pub.HandleWHIP()).Methods(http.MethodPost)
+	router.HandleFunc("/v1/broadcast/whip/{id}/{track_source}/{resource_id}", pub.HandleWHIPDelete()).Methods(http.MethodDelete)
+	s.logger.Info().Bool("enable", s.config.WHIPConfigOptions.Token != "").Msg("registered WHIP HTTP handler")
+
+	// Recording of sessions to local files, controlled per session.
+	rec := recorder.New(s.sessions, &s.logger, &s.config.RecorderConfigOptions, authorizer)
+	router.HandleFunc("/v1/broadcast/recordings", rec.HandleList()).Methods(http.MethodGet)
+	router.HandleFunc("/v1/broadcast/sessions/{id}/{track_source}/recording", rec.HandleStart()).Methods(http.MethodPost)
+	router.HandleFunc("/v1/broadcast/sessions/{id}/{track_source}/recording", rec.HandleStop()).Methods(http.MethodDelete)
+	go rec.Clean(s.ctx)
+	s.logger.Info().Str("dir", s.config.RecorderConfigOptions.Dir).Msg("registered recording HTTP handler")
 
-	server := s.newServer(handler)
-	s.logger.Info().Str("host", s.config.Host).Int("port", s.config.Port).Msg("starting HTTP server")
-	return server.ListenAndServe()
+	// LL-HLS egress for viewers without WebRTC, a session is packaged on first request.
+	h := hls.New(s.sessions, &s.logger, &s.config.HLSConfigOptions, sub.UpdateCounter, authorizer)
+	router.HandleFunc("/v1/broadcast/hls/{id}/{track_source}/{file}", h.Handle()).Methods(http.MethodGet)
+	s.logger.Info().Msg("registered HLS HTTP handler")
+
+	server := s.newServer(router)
+	if err := s.configureTLS(server); err != nil {
+		return err
+	}
+	servers := []*http.Server{server}
+	errChan := make(chan error, 2)
+	go func() {
+		if server.TLSConfig == nil {
+			s.logger.Info().Str("host", s.config.Host).Int("port", s.config.Port).Msg("starting HTTP server")
+			errChan <- server.ListenAndServe()
+			return
+		}
+		s.logger.Info().Str("host", s.config.Host).Int("port", s.config.Port).Bool("mutual_tls", s.config.TLSClientCAFile != "").Msg("starting HTTPS server")
+		errChan <- server.ListenAndServeTLS("", "")
+	}()
+	if server.TLSConfig != nil && s.config.RedirectPort != 0 {
+		redirect := s.newRedirectServer()
+		servers = append(servers, redirect)
+		go func() {
+			s.logger.Info().Str("host", s.config.Host).Int("port", s.config.RedirectPort).Msg("starting HTTP to HTTPS redirect server")
+			errChan <- redirect.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errChan:
+		return err
+	case err := <-turnErr:
+		if s.ctx.Err() == nil {
+			return fmt.Errorf("embedded TURN server exited: %w", err)
+		}
+		turnErr <- err // Closed on shutdown, it's checked after peers are closed.
+	case <-s.ctx.Done():
+	}
+	s.logger.Info().Msg("shutting down")
+
+	// Shut down in order: stop accepting signaling requests, finish recordings and HLS, then close all peers.
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.logger.Err(err).Msg("could not shut down HTTP server gracefully")
+		}
+	}
+	for range servers {
+		if err := <-errChan; !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Err(err).Msg("HTTP server exited")
+		}
+	}
+	if err := rec.Close(); err != nil {
+		s.logger.Err(err).Msg("could not close recordings")
+	}
+	if err := h.Close(); err != nil {
+		s.logger.Err(err).Msg("could not close HLS")
+	}
+	if err := pub.Close(); err != nil {
+		s.logger.Err(err).Msg("could not close publishers")
+	}
+	if err := sub.Close(); err != nil {
+		s.logger.Err(err).Msg("could not close subscribers")
+	}
+	if s.config.EmbeddedTURNConfigOptions.Embed {
+		if err := <-turnErr; err != nil {
+			s.logger.Err(err).Msg("could not close embedded TURN server")
+		}
+	}
+	s.logger.Info().Msg("shut down")
+	return nil
 }
 
 func (s *Service) newServer(handler http.Handler) *http.Server {
@@ -58,5 +186,58 @@ func (s *Service) newServer(handler http.Handler) *http.Server {
 		// Good practice: enforce timeouts for servers you create!
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
+		// WebSocket handlers derive their contexts from it and exit on shutdown.
+		BaseContext: func(net.Listener) context.Context { return s.ctx },
+	}
+}
+
+// configureTLS serves HTTPS and WSS by server if certificate is configured,
+// certificate is reloaded after its files change until service is shut down.
+func (s *Service) configureTLS(server *http.Server) error {
+	if s.config.TLSCertFile == "" && s.config.TLSKeyFile == "" {
+		return nil
+	}
+	reloader, err := tlsx.NewReloader(s.config.TLSCertFile, s.config.TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	if server.TLSConfig, err = tlsx.ServerConfig(reloader, s.config.TLSClientCAFile); err != nil {
+		return fmt.Errorf("could not create TLS config: %w", err)
+	}
+	go reloader.Watch(s.ctx, certReloadInterval, func(err error) {
+		if err != nil {
+			s.logger.Err(err).Msg("could not reload TLS certificate")
+			return
+		}
+		s.logger.Info().Str("cert_file", s.config.TLSCertFile).Msg("reloaded TLS certificate")
+	})
+	return nil
+}
+
+// newRedirectServer returns a plain HTTP server redirecting requests to HTTPS server.
+func (s *Service) newRedirectServer() *http.Server {
+	return &http.Server{
+		Handler:      redirectHandler(s.config.Port),
+		Addr:         s.config.Host + ":" + strconv.Itoa(s.config.RedirectPort),
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+}
+
+// redirectHandler redirects requests to the same host and path on HTTPS port.
+// Method and body are kept by permanent redirect, so that WHIP and WHEP clients can follow it.
+func redirectHandler(port int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]") // Host has no port.
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
 	}
 }
@@ -0,0 +1,78 @@
+package broadcast
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/turn"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		port   int
+		target string
+		want   string
+	}{
+		{8443, "http://example.com/v1/broadcast/signal?access_token=x", "https://example.com:8443/v1/broadcast/signal?access_token=x"},
+		{443, "http://example.com:80/v1/broadcast/whip/abc/1", "https://example.com/v1/broadcast/whip/abc/1"},
+		{443, "http://[::1]:80/", "https://[::1]/"},
+		{8443, "http://[::1]/", "https://[::1]:8443/"},
+	} {
+		w := httptest.NewRecorder()
+		redirectHandler(tc.port)(w, httptest.NewRequest(http.MethodPost, tc.target, nil))
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: got status %d, want %d", tc.target, w.Code, http.StatusPermanentRedirect)
+		}
+		if got := w.Header().Get("Location"); got != tc.want {
+			t.Errorf("%s: got location %s, want %s", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestEmbedTURN(t *testing.T) {
+	s := &Service{config: cfg.ConfigOptions{
+		WebRTCConfigOptions: cfg.WebRTCConfigOptions{ICEServer: "stun:stun.l.google.com:19302"},
+		EmbeddedTURNConfigOptions: cfg.EmbeddedTURNConfigOptions{
+			Embed: true,
+			TURN:  turn.ConfigOptions{PublicIP: "192.0.2.1", Port: 3478, TCP: true, TLSPort: 5349},
+		},
+	}}
+	if err := s.embedTURN(); err != nil {
+		t.Fatal(err)
+	}
+	options := s.config.EmbeddedTURNConfigOptions.TURN
+	if options.Username != embeddedTURNUser || options.Password == "" || options.SharedSecret == "" {
+		t.Fatalf("got TURN user %q, password %q, shared secret %q", options.Username, options.Password, options.SharedSecret)
+	}
+	if webRTC := s.config.WebRTCConfigOptions; webRTC.ICEServer != "turn:192.0.2.1:3478" ||
+		webRTC.Username != options.Username || webRTC.Credential != options.Password {
+		t.Fatalf("got ICE server %+v", webRTC)
+	}
+	credentials := s.config.TURNCredentialConfigOptions
+	if want := []string{"turn:192.0.2.1:3478?transport=udp", "turn:192.0.2.1:3478?transport=tcp", "turns:192.0.2.1:5349?transport=tcp"}; !reflect.DeepEqual(credentials.URLs, want) {
+		t.Fatalf("got TURN URLs %v, want %v", credentials.URLs, want)
+	}
+	if credentials.SharedSecret != options.SharedSecret {
+		t.Fatal("shared secret of TURN credentials differs from embedded TURN server")
+	}
+
+	// TURN credentials configured to other servers are kept.
+	s.config.TURNCredentialConfigOptions = cfg.TURNCredentialConfigOptions{URLs: []string{"turn:turn.example.com"}, SharedSecret: "secret"}
+	if err := s.embedTURN(); err != nil {
+		t.Fatal(err)
+	}
+	if credentials := s.config.TURNCredentialConfigOptions; credentials.URLs[0] != "turn:turn.example.com" || credentials.SharedSecret != "secret" {
+		t.Fatalf("got TURN credentials %+v", credentials)
+	}
+
+	// Peers can't reach an empty or loopback public IP.
+	for _, ip := range []string{"", "127.0.0.1", "::1"} {
+		s.config.EmbeddedTURNConfigOptions.TURN.PublicIP = ip
+		if err := s.embedTURN(); err == nil {
+			t.Errorf("public IP %q is accepted", ip)
+		}
+	}
+}
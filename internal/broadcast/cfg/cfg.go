@@ -1,26 +1,45 @@
 package cfg
 
+import (
+	"time"
+
+	"github.com/SB-IM/charoite/internal/turn"
+)
+
 type ConfigOptions struct {
 	WebRTCConfigOptions
 	MQTTClientConfigOptions
 	ServerConfigOptions
+	RecorderConfigOptions
+	HLSConfigOptions
+	AuthConfigOptions
+	SigningConfigOptions
+	TURNCredentialConfigOptions
+	EmbeddedTURNConfigOptions
+	WHIPConfigOptions
 }
 
 type PublisherConfigOptions struct {
 	MQTTClientConfigOptions
 	WebRTCConfigOptions
+	TURNCredentialConfigOptions
+	WHIPConfigOptions
 }
 
 type SubscriberConfigOptions struct {
 	MQTTClientConfigOptions
 	WebRTCConfigOptions
+	AuthConfigOptions
+	TURNCredentialConfigOptions
 }
 
 type WebRTCConfigOptions struct {
 	ICEServer      string
 	Username       string
 	Credential     string
-	EnableFrontend bool // Enable static file server handler serving webRTC frontend, useful for debug
+	EnableFrontend bool          // Enable static file server handler serving webRTC frontend, useful for debug
+	PLIInterval    time.Duration // Interval of periodic PLI to publishers, 0 sends PLI only on viewers' requests
+	NACKBufferSize int           // Packets kept for every stream sent to answer NACKs, a power of two up to 32768
 }
 
 type MQTTClientConfigOptions struct {
@@ -34,6 +53,54 @@ type MQTTClientConfigOptions struct {
 }
 
 type ServerConfigOptions struct {
-	Host string
-	Port int
+	Host            string
+	Port            int
+	ShutdownTimeout time.Duration // Deadline of graceful shutdown
+	TLSCertFile     string        // PEM certificate of HTTPS, it's reloaded after file changes, empty serves plain HTTP
+	TLSKeyFile      string        // PEM private key of TLS certificate
+	TLSClientCAFile string        // PEM CAs verifying client certificates for mutual TLS, empty doesn't verify clients
+	RedirectPort    int           // Port of plain HTTP listener redirecting to HTTPS, 0 disables it
+}
+
+type RecorderConfigOptions struct {
+	Dir             string        // Root directory of recordings
+	SegmentDuration time.Duration // Duration after which a new file is started
+	Retention       time.Duration // Age after which recordings are deleted, 0 keeps them forever
+}
+
+type HLSConfigOptions struct {
+	SegmentDuration time.Duration // Target duration of segments, segments are cut at key frames
+	PartDuration    time.Duration // Target duration of LL-HLS partial segments
+	Segments        int           // Number of segments in playlist
+	IdleTimeout     time.Duration // Packaging stops if no request is received for this duration
+}
+
+type AuthConfigOptions struct {
+	Enable         bool     // Require viewers to carry a bearer JWT
+	Algorithm      string   // JWT signing algorithm, HS256 or RS256
+	KeyFile        string   // HS256 secret or RS256 PEM public key
+	Issuer         string   // Required issuer of tokens, empty accepts any issuer
+	Audience       string   // Required audience of tokens, empty accepts any audience
+	AllowedOrigins []string // Origin patterns of viewer webSocket connections, same origin is always allowed
+}
+
+type WHIPConfigOptions struct {
+	Token string // Bearer token of WHIP publishers, empty rejects all WHIP requests
+}
+
+type SigningConfigOptions struct {
+	Verify bool          // Reject offers and candidates of edge devices not signed by their keys, and WHIP offers
+	KeyDir string        // Directory of PEM Ed25519 public keys of edge devices, named <machine id>.pem
+	MaxAge time.Duration // Offers and candidates signed longer ago are rejected as replayed
+}
+
+type TURNCredentialConfigOptions struct {
+	URLs         []string      // TURN URLs issued to viewers and WHIP publishers with time-limited credentials, empty issues none
+	SharedSecret string        // Shared secret of TURN REST API credentials, the same as shared_secret of TURN server
+	TTL          time.Duration // Lifetime of credentials issued to viewers
+}
+
+type EmbeddedTURNConfigOptions struct {
+	Embed bool               // Start TURN server in broadcast process and advertise it as ICE server to publishers and viewers
+	TURN  turn.ConfigOptions // Options of embedded TURN server, the same as turn command
 }
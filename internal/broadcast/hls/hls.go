@@ -0,0 +1,291 @@
+// Package hls packages broadcast sessions to LL-HLS for viewers that cannot do WebRTC.
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+// readyTimeout is how long the first playlist request waits for a complete segment.
+// It must be less than write timeout of HTTP server.
+const readyTimeout = 10 * time.Second
+
+const (
+	contentTypePlaylist = "application/vnd.apple.mpegurl"
+	contentTypeMP4      = "video/mp4"
+)
+
+var (
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+)
+
+// Server serves sessions in LL-HLS. A session is packaged only when it's requested,
+// and packaging stops after no request is received for idle timeout.
+// Only H.264 video is packaged, audio is left out.
+type Server struct {
+	logger zerolog.Logger
+	config *cfg.HLSConfigOptions
+
+	// sessions is shared with publishers and subscribers, it's only read by HLS server.
+	sessions *session.Registry
+
+	// authorizer authorizes HLS viewers the same as WebRTC viewers.
+	authorizer auth.Authorizer
+
+	// updateCounter counts HLS viewers in session viewers so that edge streams on demand.
+	updateCounter func(*session.Session) webrtcx.UpdateCounterFunc
+
+	mu     sync.Mutex
+	muxers map[session.Key]*muxer
+	wg     sync.WaitGroup
+}
+
+// New returns a new Server.
+func New(
+	sessions *session.Registry,
+	logger *zerolog.Logger,
+	config *cfg.HLSConfigOptions,
+	updateCounter func(*session.Session) webrtcx.UpdateCounterFunc,
+	authorizer auth.Authorizer,
+) *Server {
+	l := logger.With().Str("component", "HLS").Logger()
+	return &Server{
+		logger:        l,
+		config:        config,
+		sessions:      sessions,
+		authorizer:    authorizer,
+		updateCounter: updateCounter,
+		muxers:        make(map[session.Key]*muxer),
+	}
+}
+
+// Close stops packaging all sessions, it's called on shutdown.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	muxers := make([]*muxer, 0, len(s.muxers))
+	for _, m := range s.muxers {
+		muxers = append(muxers, m)
+	}
+	s.mu.Unlock()
+
+	for _, m := range muxers {
+		m.close()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// muxer returns muxer of a session, it starts packaging the session if not yet.
+func (s *Server) muxer(meta *pb.Meta) (*muxer, error) {
+	key := session.KeyFromMeta(meta)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, ok := s.muxers[key]; ok {
+		return m, nil
+	}
+	sess, ok := s.sessions.Load(key)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if !strings.EqualFold(sess.Tracks.Video.Codec().MimeType, webrtc.MimeTypeH264) {
+		return nil, ErrUnsupportedCodec
+	}
+
+	logger := s.logger.With().Str("id", key.ID).Int32("track_source", int32(key.TrackSource)).Str("stream", key.Stream).Logger()
+	m := newMuxer(s.config, &logger)
+	s.muxers[key] = m
+	sess.Tracks.AddSink(m)
+	// Packaging starts at a key frame.
+	sess.Tracks.RequestKeyFrame()
+	counter := s.updateCounter(sess)
+	counter(1)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		m.run()
+
+		s.mu.Lock()
+		delete(s.muxers, key)
+		s.mu.Unlock()
+		// Session may have been replaced, sinks are moved to the new one.
+		if sess, ok := s.sessions.Load(key); ok {
+			sess.Tracks.RemoveSink(m)
+		}
+		counter(-1)
+		logger.Info().Msg("stopped HLS")
+	}()
+
+	logger.Info().Msg("started HLS")
+	return m, nil
+}
+
+// Handle serves playlist, init segment, segments and parts of a session by id, track source
+// and optional stream query parameter. Viewer is authorized as WebRTC viewers,
+// stream and access_token query parameters are kept in URIs of playlist as players don't carry them over.
+func (s *Server) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		meta, err := httpx.MetaFromRequest(r)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrIncorrectMetadata)
+			return
+		}
+		grant, err := s.authorizer.Authorize(r)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusUnauthorized, httpx.ErrUnauthorized)
+			return
+		}
+		if !grant.Allows(meta) {
+			_ = httpx.WriteJSONError(w, http.StatusForbidden, httpx.ErrForbidden)
+			return
+		}
+		m, err := s.muxer(meta)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrSessionNotFound):
+			_ = httpx.WriteJSONError(w, http.StatusNotFound, httpx.ErrMetadataNotMatched)
+			return
+		default:
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrUnsupportedCodec)
+			return
+		}
+		m.touch()
+
+		values := url.Values{}
+		if meta.Stream != "" {
+			values.Set("stream", meta.Stream)
+		}
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			values.Set("access_token", token)
+		}
+		var query string
+		if len(values) != 0 {
+			query = "?" + values.Encode()
+		}
+
+		file := mux.Vars(r)["file"]
+		switch {
+		case file == "index.m3u8":
+			s.servePlaylist(w, r, m, query)
+		case file == "init.mp4":
+			if !m.wait(r.Context(), readyTimeout, func() bool { return m.init != nil }) {
+				_ = httpx.WriteJSONError(w, http.StatusServiceUnavailable, httpx.ErrStreamNotReady)
+				return
+			}
+			m.mu.Lock()
+			b := m.init
+			m.mu.Unlock()
+			write(w, contentTypeMP4, b)
+		default:
+			s.serveMedia(w, r, m, file)
+		}
+	}
+}
+
+// servePlaylist serves media playlist, it supports blocking playlist reload by _HLS_msn and _HLS_part.
+func (s *Server) servePlaylist(w http.ResponseWriter, r *http.Request, m *muxer, query string) {
+	if !m.wait(r.Context(), readyTimeout, m.ready) {
+		_ = httpx.WriteJSONError(w, http.StatusServiceUnavailable, httpx.ErrStreamNotReady)
+		return
+	}
+
+	if v := r.URL.Query().Get("_HLS_msn"); v != "" {
+		msn, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "bad _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		index := -1
+		if v := r.URL.Query().Get("_HLS_part"); v != "" {
+			if index, err = strconv.Atoi(v); err != nil || index < 0 {
+				http.Error(w, "bad _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+		if !m.wait(r.Context(), 3*s.config.SegmentDuration, func() bool {
+			last := m.lastSeq()
+			return msn < last || (msn == last && index >= 0 && index < len(m.segments[len(m.segments)-1].parts))
+		}) {
+			http.Error(w, "playlist is not updated in time", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	m.mu.Lock()
+	b := m.playlist(query)
+	m.mu.Unlock()
+	write(w, contentTypePlaylist, b)
+}
+
+// serveMedia serves segment "seg{msn}.m4s" or part "part{msn}.{part}.m4s".
+// Request of a hinted part which isn't ready yet is blocked until it's ready.
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, m *muxer, file string) {
+	var (
+		msn   uint64
+		index = -1
+		err   error
+	)
+	switch {
+	case strings.HasPrefix(file, "seg"):
+		_, err = fmt.Sscanf(file, "seg%d.m4s", &msn)
+	case strings.HasPrefix(file, "part"):
+		if _, err = fmt.Sscanf(file, "part%d.%d.m4s", &msn, &index); err == nil && index < 0 {
+			err = errors.New("negative part index")
+		}
+	default:
+		err = errors.New("unknown file")
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !m.wait(r.Context(), 3*s.config.PartDuration, func() bool { return m.hasPart(msn, index) }) {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	var b []byte
+	if seg, ok := m.segment(msn); ok {
+		if index < 0 {
+			b = seg.bytes()
+		} else if index < len(seg.parts) {
+			b = seg.parts[index].data
+		}
+	}
+	m.mu.Unlock()
+	if b == nil {
+		http.NotFound(w, r)
+		return
+	}
+	write(w, contentTypeMP4, b)
+}
+
+func write(w http.ResponseWriter, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,93 @@
+package hls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+)
+
+func TestMuxer(t *testing.T) {
+	logger := zerolog.Nop()
+	m := newMuxer(&cfg.HLSConfigOptions{
+		SegmentDuration: time.Second,
+		PartDuration:    300 * time.Millisecond,
+		Segments:        2,
+	}, &logger)
+
+	// STAP-A of SPS and PPS.
+	stapA := []byte{
+		0x78,
+		0x00, 0x0f, 0x67, 0x42, 0xc0, 0x1f, 0x1a, 0x32, 0x35, 0x01, 0x40, 0x7a, 0x40, 0x3c, 0x22, 0x11, 0xa8,
+		0x00, 0x05, 0x68, 0x1a, 0x34, 0xe3, 0xc8,
+	}
+	var seq uint16
+	push := func(payload []byte, timestamp uint32, marker bool) {
+		m.builder.Push(&rtp.Packet{
+			Header:  rtp.Header{Marker: marker, SequenceNumber: seq, Timestamp: timestamp, SSRC: 1},
+			Payload: payload,
+		})
+		seq++
+		for sample := m.builder.Pop(); sample != nil; sample = m.builder.Pop() {
+			m.writeSample(sample)
+		}
+	}
+
+	// 10 frames per second with a key frame every second, until 3.4s.
+	for i := 0; i <= 34; i++ {
+		timestamp := uint32(i * 9000)
+		if i%10 == 0 {
+			push(stapA, timestamp, false)
+			push([]byte{0x65, 0x88, 0x84, byte(i)}, timestamp, true)
+		} else {
+			push([]byte{0x41, 0x9a, byte(i)}, timestamp, true)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !bytes.Contains(m.init, []byte("avcC")) {
+		t.Error("no avcC box in init segment")
+	}
+	// Segments 0, 1 and 2 are complete, segment 0 is out of window, segment 3 is current.
+	if len(m.segments) != 3 || m.segments[0].seq != 1 || m.lastSeq() != 3 {
+		t.Fatalf("got %d segments from %d", len(m.segments), m.segments[0].seq)
+	}
+	seg := m.segments[0]
+	if !seg.complete || seg.duration != time.Second {
+		t.Errorf("got segment duration %v, want 1s", seg.duration)
+	}
+	// Parts are cut at 0.3s, 0.6s, 0.9s and the key frame.
+	if len(seg.parts) != 4 || !seg.parts[0].independent || seg.parts[1].independent {
+		t.Errorf("got %d parts, want 4 with the first one independent", len(seg.parts))
+	}
+	if !bytes.Contains(seg.bytes(), []byte("styp")) || !bytes.Contains(seg.bytes(), []byte{0x00, 0x00, 0x00, 0x04, 0x65, 0x88, 0x84, 10}) {
+		t.Error("segment doesn't start with styp box and key frame")
+	}
+	if !m.hasPart(3, 0) || m.hasPart(3, 1) || m.hasPart(3, -1) || m.hasPart(0, 0) {
+		t.Error("wrong parts availability")
+	}
+
+	playlist := string(m.playlist("?stream=front"))
+	for _, line := range []string{
+		"#EXT-X-TARGETDURATION:1\n",
+		"#EXT-X-MEDIA-SEQUENCE:1\n",
+		"#EXT-X-MAP:URI=\"init.mp4?stream=front\"\n",
+		"#EXTINF:1.000,\nseg1.m4s?stream=front\n",
+		"#EXT-X-PART:DURATION=0.300,URI=\"part2.0.m4s?stream=front\",INDEPENDENT=YES\n",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part3.1.m4s?stream=front\"\n",
+	} {
+		if !strings.Contains(playlist, line) {
+			t.Errorf("no %q in playlist:\n%s", line, playlist)
+		}
+	}
+	if strings.Contains(playlist, "part1.0.m4s") {
+		t.Errorf("parts of old segment in playlist:\n%s", playlist)
+	}
+}
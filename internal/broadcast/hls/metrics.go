@@ -0,0 +1,13 @@
+package hls
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var packetsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "charoite",
+	Subsystem: "broadcast",
+	Name:      "hls_packets_dropped_total",
+	Help:      "Number of RTP packets dropped by HLS packager as its queue is full.",
+})
@@ -0,0 +1,399 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/format/fmp4"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+)
+
+const (
+	// packetQueueSize is number of packets buffered between forwarding loop and packaging.
+	packetQueueSize = 1024
+
+	// maxLate is how many packets samplebuilder holds to reorder packets and assemble frames.
+	maxLate = 512
+
+	videoClockRate = 90000
+)
+
+// part is a partial segment of LL-HLS, it's a single fMP4 fragment.
+type part struct {
+	data        []byte
+	duration    time.Duration
+	independent bool
+}
+
+// segment consists of parts and starts with a key frame.
+type segment struct {
+	seq      uint64
+	parts    []*part
+	duration time.Duration
+	complete bool
+}
+
+func (s *segment) bytes() []byte {
+	var b []byte
+	for _, p := range s.parts {
+		b = append(b, p.data...)
+	}
+	return b
+}
+
+// muxer packages H.264 RTP packets of a session to fMP4 segments and parts in memory.
+// It implements webrtc.RTPSink.
+type muxer struct {
+	config *cfg.HLSConfigOptions
+	logger zerolog.Logger
+
+	packets chan *rtp.Packet
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	// lastAccess is unix nano time of the last request.
+	lastAccess atomic.Int64
+
+	// Following fields are only accessed in run loop.
+	ssrc           uint32
+	builder        *samplebuilder.SampleBuilder
+	fragmenter     *fmp4.TrackFragmenter
+	sps, pps       []byte
+	started        bool
+	lastTimestamp  uint32
+	elapsed        int64 // Ticks of video clock since the first frame.
+	segmentStartAt time.Duration
+	partStartAt    time.Duration
+
+	// Following fields are guarded by mu and read by HTTP handlers.
+	mu             sync.Mutex
+	init           []byte
+	segments       []*segment // The last one is incomplete.
+	targetDuration time.Duration
+	changed        chan struct{} // Closed and renewed on every new part.
+}
+
+func newMuxer(config *cfg.HLSConfigOptions, logger *zerolog.Logger) *muxer {
+	m := &muxer{
+		config:  config,
+		logger:  *logger,
+		packets: make(chan *rtp.Packet, packetQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		builder: samplebuilder.New(maxLate, &codecs.H264Packet{}, videoClockRate),
+		changed: make(chan struct{}),
+	}
+	m.touch()
+	return m
+}
+
+// WriteRTP queues a video packet without blocking.
+func (m *muxer) WriteRTP(kind webrtc.RTPCodecType, codec webrtc.RTPCodecParameters, p *rtp.Packet) {
+	if kind != webrtc.RTPCodecTypeVideo || !strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264) {
+		return
+	}
+	select {
+	case m.packets <- p:
+	default:
+		packetsDropped.Inc()
+	}
+}
+
+// touch records a request, muxer stops after idle timeout since the last request.
+func (m *muxer) touch() {
+	m.lastAccess.Store(time.Now().UnixNano())
+}
+
+// run packages packets until muxer is closed, idle, or publisher changed.
+func (m *muxer) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case p := <-m.packets:
+			if m.ssrc == 0 {
+				m.ssrc = p.SSRC
+			}
+			if p.SSRC != m.ssrc {
+				// Edge reconnected, timeline and codec configuration can't be continued.
+				m.logger.Info().Msg("publisher changed, stopping HLS")
+				return
+			}
+			m.builder.Push(p)
+			for sample := m.builder.Pop(); sample != nil; sample = m.builder.Pop() {
+				m.writeSample(sample)
+			}
+		case <-ticker.C:
+			if time.Since(time.Unix(0, m.lastAccess.Load())) > m.config.IdleTimeout {
+				m.logger.Info().Msg("no request received, stopping HLS")
+				return
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// close stops run loop and waits for it.
+func (m *muxer) close() {
+	m.once.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+func (m *muxer) writeSample(sample *media.Sample) {
+	nalus, _ := h264parser.SplitNALUs(sample.Data)
+	var (
+		frame    []byte
+		keyFrame bool
+	)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case h264parser.NALU_SPS:
+			m.sps = append([]byte(nil), nalu...)
+			continue
+		case h264parser.NALU_PPS:
+			m.pps = append([]byte(nil), nalu...)
+			continue
+		case 9: // Access unit delimiter.
+			continue
+		case 5: // IDR.
+			keyFrame = true
+		}
+		frame = append(frame, byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu)))
+		frame = append(frame, nalu...)
+	}
+	if len(frame) == 0 {
+		return
+	}
+
+	if !m.started {
+		m.started = true
+		m.lastTimestamp = sample.PacketTimestamp
+	}
+	m.elapsed += int64(sample.PacketTimestamp - m.lastTimestamp)
+	m.lastTimestamp = sample.PacketTimestamp
+	pts := time.Duration(m.elapsed) * time.Second / videoClockRate
+
+	if m.fragmenter == nil {
+		if !keyFrame {
+			return
+		}
+		if err := m.initialize(); err != nil {
+			m.logger.Debug().Err(err).Msg("could not initialize fMP4, waiting for next key frame")
+			return
+		}
+	}
+	if err := m.fragmenter.WritePacket(av.Packet{IsKeyFrame: keyFrame, Time: pts, Data: frame}); err != nil {
+		m.logger.Err(err).Msg("could not write frame")
+		return
+	}
+
+	// Fragments are cut before the frame just written.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case keyFrame && (len(m.segments) == 0 || pts-m.segmentStartAt >= m.config.SegmentDuration):
+		if len(m.segments) > 0 {
+			m.flushPart(pts)
+			m.completeSegment(pts)
+		}
+		m.fragmenter.NewSegment()
+		m.segments = append(m.segments, &segment{seq: m.nextSeq()})
+		m.segmentStartAt = pts
+		m.partStartAt = pts
+	case pts-m.partStartAt >= m.config.PartDuration:
+		m.flushPart(pts)
+	}
+}
+
+// initialize creates fMP4 fragmenter and init segment from parameter sets.
+func (m *muxer) initialize() error {
+	if m.sps == nil || m.pps == nil {
+		return fmt.Errorf("no parameter sets")
+	}
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(m.sps, m.pps)
+	if err != nil {
+		return err
+	}
+	fragmenter, err := fmp4.NewTrack(codec)
+	if err != nil {
+		return err
+	}
+	_, _, init := fragmenter.MovieHeader()
+
+	m.fragmenter = fragmenter
+	m.mu.Lock()
+	m.init = init
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *muxer) nextSeq() uint64 {
+	if len(m.segments) == 0 {
+		return 0
+	}
+	return m.segments[len(m.segments)-1].seq + 1
+}
+
+// flushPart appends pending frames before pts as a part to the current segment.
+func (m *muxer) flushPart(pts time.Duration) {
+	frag, err := m.fragmenter.Fragment()
+	if err != nil {
+		m.logger.Err(err).Msg("could not make fragment")
+		return
+	}
+	if frag.Length == 0 {
+		return
+	}
+	current := m.segments[len(m.segments)-1]
+	current.parts = append(current.parts, &part{
+		data:        frag.Bytes,
+		duration:    pts - m.partStartAt,
+		independent: frag.Independent,
+	})
+	m.partStartAt = pts
+	m.notify()
+}
+
+// completeSegment completes the current segment and removes segments out of playlist window.
+func (m *muxer) completeSegment(pts time.Duration) {
+	current := m.segments[len(m.segments)-1]
+	current.duration = pts - m.segmentStartAt
+	current.complete = true
+	if current.duration > m.targetDuration {
+		m.targetDuration = current.duration
+	}
+	if n := len(m.segments) - m.config.Segments; n > 0 {
+		m.segments = m.segments[n:]
+	}
+	m.notify()
+}
+
+func (m *muxer) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// wait blocks until cond is true or timeout. Cond is called with mu held.
+func (m *muxer) wait(ctx context.Context, timeout time.Duration, cond func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		ok := cond()
+		changed := m.changed
+		m.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-m.done:
+			return false
+		}
+	}
+}
+
+// ready reports whether there is a complete segment to play. It must be called with mu held.
+func (m *muxer) ready() bool {
+	return len(m.segments) > 1
+}
+
+// segment returns segment of seq, it must be called with mu held.
+func (m *muxer) segment(seq uint64) (*segment, bool) {
+	if len(m.segments) == 0 || seq < m.segments[0].seq {
+		return nil, false
+	}
+	i := seq - m.segments[0].seq
+	if i >= uint64(len(m.segments)) {
+		return nil, false
+	}
+	return m.segments[i], true
+}
+
+// hasPart reports whether part of segment seq is available, a negative part index means the whole segment.
+// It must be called with mu held.
+func (m *muxer) hasPart(seq uint64, index int) bool {
+	s, ok := m.segment(seq)
+	if !ok {
+		return false
+	}
+	if index < 0 {
+		return s.complete
+	}
+	return index < len(s.parts) || s.complete
+}
+
+// lastSeq returns sequence number of the current segment, it must be called with mu held.
+func (m *muxer) lastSeq() uint64 {
+	return m.segments[len(m.segments)-1].seq
+}
+
+// playlist renders LL-HLS media playlist, query is appended to URIs. It must be called with mu held.
+func (m *muxer) playlist(query string) []byte {
+	uri := func(format string, a ...interface{}) string {
+		return fmt.Sprintf(format, a...) + query
+	}
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", d.Seconds())
+	}
+
+	target := m.targetDuration
+	if target < m.config.SegmentDuration {
+		target = m.config.SegmentDuration
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%s\n", seconds(3*m.config.PartDuration))
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", seconds(m.config.PartDuration))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", m.segments[0].seq)
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", uri("init.mp4"))
+
+	// Parts are listed for the last complete segment and the current one only.
+	partsFrom := m.lastSeq() - 1
+	for _, s := range m.segments {
+		if s.seq >= partsFrom {
+			for i, p := range s.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=\"%s\"", seconds(p.duration), uri("part%d.%d.m4s", s.seq, i))
+				if p.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if s.complete {
+			fmt.Fprintf(&b, "#EXTINF:%s,\n%s\n", seconds(s.duration), uri("seg%d.m4s", s.seq))
+		}
+	}
+	current := m.segments[len(m.segments)-1]
+	fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", uri("part%d.%d.m4s", current.seq, len(current.parts)))
+	return b.Bytes()
+}
@@ -12,6 +12,32 @@ const (
 
 	// Code for Common errors.
 	ErrUnmarshalJSON
+
+	// Code for WHIP and WHEP signaling.
+	ErrUnsupportedMediaType
+	ErrFailedToCreatePublisher
+	ErrResourceNotFound
+
+	// Code for authorization.
+	ErrUnauthorized
+
+	// Code for session recording.
+	ErrAlreadyRecording
+	ErrNotRecording
+	ErrFailedToRecord
+
+	// Code for HLS egress.
+	ErrUnsupportedCodec
+	ErrStreamNotReady
+
+	// Code for layer selection.
+	ErrUnknownLayer
+
+	// Code for viewer authorization.
+	ErrForbidden
+
+	// Code for signing of edge signaling.
+	ErrUnsigned
 )
 
 // Errors maps error code to error message.
@@ -21,4 +47,16 @@ var Errors = map[Code]string{
 	ErrMetadataNotMatched:       "Metadata not matched with any existing session",
 	ErrFailedToCreateSubscriber: "Failed to create subscriber for user",
 	ErrUnmarshalJSON:            "Could not unmarshal JSON data",
+	ErrUnsupportedMediaType:     "Content type must be application/sdp",
+	ErrFailedToCreatePublisher:  "Failed to create publisher for edge",
+	ErrResourceNotFound:         "Resource not found",
+	ErrUnauthorized:             "Missing or invalid token",
+	ErrAlreadyRecording:         "Session is already being recorded",
+	ErrNotRecording:             "Session is not being recorded",
+	ErrFailedToRecord:           "Failed to record session",
+	ErrUnsupportedCodec:         "Codec of session is not supported",
+	ErrStreamNotReady:           "Stream is not ready yet",
+	ErrUnknownLayer:             "Layer is not sent by publisher",
+	ErrForbidden:                "Not permitted to subscribe to stream",
+	ErrUnsigned:                 "Offers must be signed, signal over MQTT instead",
 }
@@ -0,0 +1,27 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is an uniform error reply of REST API.
+type ErrorResponse struct {
+	Code Code   `json:"code"`
+	Msg  string `json:"message"`
+}
+
+// WriteJSON replies v in JSON with status.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// WriteJSONError replies an ErrorResponse of code with status.
+func WriteJSONError(w http.ResponseWriter, status int, code Code) error {
+	return WriteJSON(w, status, ErrorResponse{
+		Code: code,
+		Msg:  Errors[code],
+	})
+}
@@ -0,0 +1,33 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/gorilla/mux"
+)
+
+// MetaFromRequest parses edge device metadata from route variables "id" and "track_source",
+// and optional "stream" query parameter.
+// Track source is in its numeric form, the same as in MQTT topics.
+func MetaFromRequest(r *http.Request) (*pb.Meta, error) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if id == "" {
+		return nil, errors.New("empty id")
+	}
+	trackSource, err := strconv.Atoi(vars["track_source"])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pb.TrackSource_name[int32(trackSource)]; !ok {
+		return nil, errors.New("unknown track source")
+	}
+	return &pb.Meta{
+		Id:          id,
+		TrackSource: pb.TrackSource(trackSource),
+		Stream:      r.URL.Query().Get("stream"),
+	}, nil
+}
@@ -0,0 +1,64 @@
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ContentTypeSDP is the content type of WHIP and WHEP offers and answers.
+const ContentTypeSDP = "application/sdp"
+
+// maxSDPSize limits size of SDP request body.
+const maxSDPSize = 64 * 1024
+
+// ErrContentType means request body is not an SDP.
+var ErrContentType = errors.New("content type is not " + ContentTypeSDP)
+
+// ReadOffer reads an SDP offer from WHIP or WHEP request body.
+func ReadOffer(r *http.Request) (*webrtc.SessionDescription, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != ContentTypeSDP {
+		return nil, ErrContentType
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	if err != nil {
+		return nil, err
+	}
+	return &webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(b),
+	}, nil
+}
+
+// WriteAnswer replies an SDP answer of a created WHIP or WHEP resource.
+func WriteAnswer(w http.ResponseWriter, location string, answer *webrtc.SessionDescription) error {
+	w.Header().Set("Content-Type", ContentTypeSDP)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+	_, err := io.WriteString(w, answer.SDP)
+	return err
+}
+
+// SetICEServerLinks sets Link headers of ICE servers in a WHEP answer, so that viewer relays through them.
+// See: https://datatracker.ietf.org/doc/html/draft-ietf-wish-whep-01#section-4.4
+func SetICEServerLinks(h http.Header, servers []webrtc.ICEServer) {
+	for _, server := range servers {
+		for _, u := range server.URLs {
+			link := "<" + u + `>; rel="ice-server"`
+			if server.Username != "" {
+				link += fmt.Sprintf(`; username=%q; credential=%q; credential-type="password"`, server.Username, server.Credential)
+			}
+			h.Add("Link", link)
+		}
+	}
+}
+
+// Error replies a plain text error message of code.
+func Error(w http.ResponseWriter, code Code, status int) {
+	http.Error(w, Errors[code], status)
+}
@@ -0,0 +1,21 @@
+package publisher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var signalingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "charoite",
+	Subsystem: "broadcast",
+	Name:      "mqtt_signaling_duration_seconds",
+	Help:      "Time from receiving an edge offer to sending its answer over MQTT.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"track_source"})
+
+var rejectedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "charoite",
+	Subsystem: "broadcast",
+	Name:      "mqtt_signaling_rejected_total",
+	Help:      "Edge offers and candidates rejected by signature verification.",
+}, []string{"kind"})
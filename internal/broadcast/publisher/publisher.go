@@ -3,8 +3,8 @@ package publisher
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"sync"
+	"time"
 
 	pb "github.com/SB-IM/charoite/internal/pb/signal"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -13,6 +13,7 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
 	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
 )
 
@@ -22,36 +23,47 @@ type Publisher struct {
 	logger zerolog.Logger
 	config *cfg.PublisherConfigOptions
 
+	// verifier verifies signatures of offers and candidates from edge devices, it's nil if they are not verified.
+	verifier *pb.Verifier
+
 	// sessions must be created before used by publisher and is shared between publishers and subscribers.
 	// It's mainly written and maintained by publishers
-	sessions *sync.Map
+	sessions *session.Registry
+
+	// resources are WHIP resources of publishers.
+	resources sync.Map
+
+	// peers are all alive publisher peers, they're closed on shutdown.
+	peers webrtcx.Peers
 }
 
 // New returns a new Publisher.
 func New(
 	client mqtt.Client,
-	sessions *sync.Map,
+	sessions *session.Registry,
 	logger *zerolog.Logger,
 	config *cfg.PublisherConfigOptions,
+	verifier *pb.Verifier,
 ) *Publisher {
 	l := logger.With().Str("component", "Publisher").Logger()
 	return &Publisher{
 		client:   client,
 		logger:   l,
 		config:   config,
+		verifier: verifier,
 		sessions: sessions,
 	}
 }
 
 // Signal performs webRTC signaling for all publisher peers.
 func (p *Publisher) Signal() {
-	// The receiving topic is different for each edge device only in "id/track_source" pattern suffix,
+	// The receiving topic is different for each edge device only in "id/track_source" or "id/track_source/stream" pattern suffix,
 	// therefore each edge client can retain its own message with its unique topic when broadcast service disconnected
 	// unexpectedly, but message payload is different.
 	// NOTE: currently, retained messsage is disabled for both cloud and edge clients due to its wired behavior.
-	// The id and trackSource in payload determine the following publishing topic.
+	// The id, trackSource and stream in payload determine the following publishing topic.
 	// Receive remote SDP with MQTT.
-	topic := p.config.OfferTopicPrefix + "/" + "+" + "/" + "+"
+	topic := p.config.OfferTopicPrefix + "/" + "#"
 	t := p.client.Subscribe(topic, byte(p.config.Qos), p.handleMessage())
 	// the connection handler is called in a goroutine so blocking here would hot cause an issue. However as blocking
 	// in other handlers does cause problems its best to just assume we should not block
@@ -65,6 +77,16 @@ func (p *Publisher) Signal() {
 	}()
 }
 
+// Close stops receiving offers from edge and closes all publisher peers.
+func (p *Publisher) Close() error {
+	topic := p.config.OfferTopicPrefix + "/" + "#"
+	t := p.client.Unsubscribe(topic)
+	if t.WaitTimeout(time.Second) && t.Error() != nil {
+		p.logger.Err(t.Error()).Msgf("could not unsubscribe from %s", topic)
+	}
+	return p.peers.Close()
+}
+
 // sendCandidate sends candidate to remote webRTC peer via MQTT.
 // The publish topic is unique to this edge device.
 func (p *Publisher) sendCandidate(meta *pb.Meta) webrtcx.SendCandidateFunc {
@@ -73,7 +95,7 @@ func (p *Publisher) sendCandidate(meta *pb.Meta) webrtcx.SendCandidateFunc {
 		if err != nil {
 			return fmt.Errorf("could not encode candidate: %w", err)
 		}
-		topic := p.config.CandidateSendTopicPrefix + "/" + meta.Id + "/" + strconv.Itoa(int(meta.TrackSource))
+		topic := p.config.CandidateSendTopicPrefix + pb.TopicSuffix(meta)
 		t := p.client.Publish(topic, byte(p.config.Qos), p.config.Retained, payload)
 		// Handle the token in a go routine so this loop keeps sending messages regardless of delivery status
 		go func() {
@@ -94,12 +116,13 @@ func (p *Publisher) recvCandidate(meta *pb.Meta) webrtcx.RecvCandidateFunc {
 	return func() <-chan string {
 		// TODO: Figure how to properly close channel.
 		ch := make(chan string, 2) // Make buffer 2 because we have at least 2 sendings.
-		topic := p.config.CandidateRecvTopicPrefix + "/" + meta.Id + "/" + strconv.Itoa(int(meta.TrackSource))
+		topic := p.config.CandidateRecvTopicPrefix + pb.TopicSuffix(meta)
 		// Receive remote ICE candidate with MQTT.
 		t := p.client.Subscribe(topic, byte(p.config.Qos), func(c mqtt.Client, m mqtt.Message) {
-			candidate, err := pb.DecodeCandidate(m.Payload())
+			candidate, err := p.verifier.DecodeCandidate(m.Payload(), meta)
 			if err != nil {
-				p.logger.Err(err).Msg("could not decode candidate")
+				rejectedMessages.WithLabelValues("candidate").Inc()
+				p.logger.Err(err).Str("topic", m.Topic()).Msg("could not decode candidate")
 				return
 			}
 			ch <- candidate
@@ -126,13 +149,22 @@ func (p *Publisher) handleMessage() mqtt.MessageHandler {
 			p.logger.Err(err).Msg("could not unmarshal sdp")
 			return
 		}
+		if p.verifier != nil {
+			if err := p.verifyOffer(m.Topic(), &offer); err != nil {
+				rejectedMessages.WithLabelValues("offer").Inc()
+				p.logger.Err(err).Str("topic", m.Topic()).Msg("rejected offer")
+				return
+			}
+		}
 
 		logger := p.logger.With().
 			Str("offer_topic_prefix", p.config.OfferTopicPrefix).
 			Str("id", offer.Meta.Id).
 			Int32("track_source", int32(offer.Meta.TrackSource)).
+			Str("stream", offer.Meta.Stream).
 			Logger()
 		logger.Info().Msg("received offer from edge")
+		receivedAt := time.Now()
 
 		answer, err := p.signalPeerConnection(&offer, &logger)
 		if err != nil {
@@ -148,17 +180,30 @@ func (p *Publisher) handleMessage() mqtt.MessageHandler {
 		}
 
 		// The publishing topic is unique to each edge device and is determined by above receiving message payload.
-		answerTopic := p.config.AnswerTopicPrefix + "/" + offer.Meta.Id + "/" + strconv.Itoa(int(offer.Meta.TrackSource))
+		answerTopic := p.config.AnswerTopicPrefix + pb.TopicSuffix(offer.Meta)
 		t := c.Publish(answerTopic, byte(p.config.Qos), p.config.Retained, payload)
 		<-t.Done()
 		if t.Error() != nil {
 			p.logger.Err(t.Error()).Msgf("could not publish to %s", answerTopic)
 			return
 		}
+		signalingDuration.WithLabelValues(offer.Meta.TrackSource.String()).Observe(time.Since(receivedAt).Seconds())
 		logger.Info().Str("answer_topic", answerTopic).Msg("sent answer to edge")
 	}
 }
 
+// verifyOffer verifies signature of offer, and that it's received on offer topic of its metadata,
+// so that a signed offer can't be replayed for another stream.
+func (p *Publisher) verifyOffer(topic string, offer *pb.SessionDescription) error {
+	if err := p.verifier.VerifySDP(offer); err != nil {
+		return err
+	}
+	if want := p.config.OfferTopicPrefix + pb.TopicSuffix(offer.Meta); topic != want {
+		return fmt.Errorf("offer received on %s, want %s", topic, want)
+	}
+	return nil
+}
+
 // signalPeerConnection creates video track and performs webRTC signaling.
 func (p *Publisher) signalPeerConnection(offer *pb.SessionDescription, logger *zerolog.Logger) (
 	*webrtc.SessionDescription,
@@ -169,26 +214,28 @@ func (p *Publisher) signalPeerConnection(offer *pb.SessionDescription, logger *z
 		return nil, err
 	}
 
-	videoTrack, err := webrtcx.CreateLocalTrack()
+	tracks, err := webrtcx.CreateLocalTracks(&sdp, offer.Meta)
 	if err != nil {
-		return nil, fmt.Errorf("could not create webRTC local video track: %w", err)
+		return nil, fmt.Errorf("could not create webRTC local tracks: %w", err)
 	}
-	logger.Info().Msg("created video track")
+	logger.Info().Bool("audio", tracks.Audio != nil).Msg("created local tracks")
 
 	w := webrtcx.New(
 		p.config.WebRTCConfigOptions,
 		logger,
 		p.sendCandidate(offer.Meta),
 		p.recvCandidate(offer.Meta),
-		p.registerSession(offer.Meta, videoTrack),
+		p.registerSession(offer.Meta, tracks),
 		webrtcx.NoopUpdateCounterFunc,
 	)
 
 	// TODO: handle blocking case with timeout for channels.
 	w.SignalChan <- &sdp
-	if err := w.CreatePublisher(videoTrack); err != nil {
+	if err := w.CreatePublisher(tracks); err != nil {
 		return nil, fmt.Errorf("failed to create webRTC publisher: %w", err)
 	}
+	p.peers.Add(w)
+	go p.unregisterSession(offer.Meta, tracks, w.Done())
 	logger.Info().Msg("created publisher")
 
 	return <-w.SignalChan, nil
@@ -196,16 +243,23 @@ func (p *Publisher) signalPeerConnection(offer *pb.SessionDescription, logger *z
 
 func (p *Publisher) registerSession(
 	meta *pb.Meta,
-	videoTrack *webrtc.TrackLocalStaticRTP,
+	tracks *webrtcx.LocalTracks,
 ) webrtcx.RegisterSessionFunc {
 	return func() {
-		sessionID := meta.Id + strconv.Itoa(int(meta.TrackSource))
-		_, ok := p.sessions.Load(sessionID)
-		p.sessions.Store(sessionID, videoTrack)
-		if ok {
-			p.logger.Info().Str("key", sessionID).Int32("value", int32(meta.TrackSource)).Msg("re-registered old session")
+		logger := p.logger.With().Str("id", meta.Id).Int32("track_source", int32(meta.TrackSource)).Str("stream", meta.Stream).Logger()
+		if p.sessions.Register(session.KeyFromMeta(meta), session.New(meta, tracks)) {
+			logger.Info().Msg("re-registered old session")
 		} else {
-			p.logger.Info().Str("key", sessionID).Int32("value", int32(meta.TrackSource)).Msg("registered session")
+			logger.Info().Msg("registered session")
 		}
 	}
 }
+
+// unregisterSession removes session of tracks after publisher peer is closed,
+// unless the session is replaced by a reconnected publisher.
+func (p *Publisher) unregisterSession(meta *pb.Meta, tracks *webrtcx.LocalTracks, done <-chan struct{}) {
+	<-done
+	if p.sessions.Unregister(session.KeyFromMeta(meta), tracks) {
+		p.logger.Info().Str("id", meta.Id).Int32("track_source", int32(meta.TrackSource)).Str("stream", meta.Stream).Msg("unregistered session")
+	}
+}
@@ -0,0 +1,101 @@
+package publisher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+func TestRegisterSession(t *testing.T) {
+	logger := zerolog.Nop()
+	sessions := session.NewRegistry()
+	p := New(nil, sessions, &logger, &cfg.PublisherConfigOptions{}, nil)
+
+	videoTrack := webrtcx.CreateLocalTrack(webrtc.MimeTypeH264, nil)
+	tracks := &webrtcx.LocalTracks{Video: videoTrack}
+	p.registerSession(&pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR}, tracks)()
+
+	// Subscriber looks session up with metadata decoded from its own offer.
+	s, ok := sessions.Load(session.KeyFromMeta(&pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR}))
+	if !ok {
+		t.Fatal("registered session not found by subscriber")
+	}
+	if s.Tracks != tracks {
+		t.Fatal("registered session has wrong tracks")
+	}
+}
+
+func TestWHIPUnauthorized(t *testing.T) {
+	logger := zerolog.Nop()
+	for _, tc := range []struct {
+		name   string
+		token  string
+		header string
+	}{
+		{"no token configured", "", "Bearer "},
+		{"no header", "token", ""},
+		{"wrong token", "token", "Bearer wrong"},
+		{"wrong scheme", "token", "Basic token"},
+	} {
+		// TURN credentials are configured, but they're not issued to unauthorized publishers.
+		p := New(nil, session.NewRegistry(), &logger, &cfg.PublisherConfigOptions{
+			WHIPConfigOptions: cfg.WHIPConfigOptions{Token: tc.token},
+			TURNCredentialConfigOptions: cfg.TURNCredentialConfigOptions{
+				URLs:         []string{"turn:turn.example.com"},
+				SharedSecret: "secret",
+				TTL:          time.Hour,
+			},
+		}, nil)
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodPost, "/v1/broadcast/whip/abc/1", strings.NewReader("v=0")),
+			httptest.NewRequest(http.MethodDelete, "/v1/broadcast/whip/abc/1/resource", nil),
+		} {
+			r.Header.Set("Content-Type", "application/sdp")
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			if r.Method == http.MethodPost {
+				p.HandleWHIP()(w, r)
+			} else {
+				p.HandleWHIPDelete()(w, r)
+			}
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s: %s got status %d, want %d", tc.name, r.Method, w.Code, http.StatusUnauthorized)
+			}
+			if links := w.Header().Values("Link"); len(links) != 0 {
+				t.Errorf("%s: %s got ICE servers %v", tc.name, r.Method, links)
+			}
+		}
+	}
+}
+
+func TestWHIPUnsigned(t *testing.T) {
+	logger := zerolog.Nop()
+	verifier, err := pb.NewVerifier(t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := session.NewRegistry()
+	p := New(nil, sessions, &logger, &cfg.PublisherConfigOptions{
+		WHIPConfigOptions: cfg.WHIPConfigOptions{Token: "token"},
+	}, verifier)
+	r := httptest.NewRequest(http.MethodPost, "/v1/broadcast/whip/abc/1", strings.NewReader("v=0"))
+	r.Header.Set("Content-Type", "application/sdp")
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	p.HandleWHIP()(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
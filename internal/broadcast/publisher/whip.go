@@ -0,0 +1,150 @@
+package publisher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+	"github.com/SB-IM/charoite/internal/turn"
+)
+
+// HandleWHIP handles WHIP ingress from edge devices which don't signal over MQTT.
+// See: https://datatracker.ietf.org/doc/draft-ietf-wish-whip/
+// Candidates are not trickled, answer carries all gathered candidates.
+func (p *Publisher) HandleWHIP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.authorizeWHIP(r) {
+			p.logger.Warn().Str("remote_addr", r.RemoteAddr).Msg("unauthorized WHIP offer")
+			unauthorized(w)
+			return
+		}
+		// WHIP offers are not signed, they'd replace sessions of edge devices whose offers must be signed.
+		if p.verifier != nil {
+			p.logger.Warn().Str("remote_addr", r.RemoteAddr).Msg("rejected unsigned WHIP offer")
+			httpx.Error(w, httpx.ErrUnsigned, http.StatusForbidden)
+			return
+		}
+		meta, err := httpx.MetaFromRequest(r)
+		if err != nil {
+			p.logger.Err(err).Msg("incorrect metadata")
+			httpx.Error(w, httpx.ErrIncorrectMetadata, http.StatusBadRequest)
+			return
+		}
+		logger := p.logger.With().Str("id", meta.Id).Int32("track_source", int32(meta.TrackSource)).Str("stream", meta.Stream).Logger()
+
+		offer, err := httpx.ReadOffer(r)
+		if err != nil {
+			logger.Err(err).Msg("could not read offer")
+			if errors.Is(err, httpx.ErrContentType) {
+				httpx.Error(w, httpx.ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
+			} else {
+				httpx.Error(w, httpx.ErrReadMessage, http.StatusBadRequest)
+			}
+			return
+		}
+		logger.Info().Msg("received WHIP offer from edge")
+
+		tracks, err := webrtcx.CreateLocalTracks(offer, meta)
+		if err != nil {
+			logger.Err(err).Msg("could not create webRTC local tracks")
+			httpx.Error(w, httpx.ErrFailedToCreatePublisher, http.StatusBadRequest)
+			return
+		}
+
+		wcx := webrtcx.New(
+			p.config.WebRTCConfigOptions,
+			&logger,
+			webrtcx.NoopSendCandidateFunc,
+			webrtcx.NoopRecvCandidateFunc,
+			p.registerSession(meta, tracks),
+			webrtcx.NoopUpdateCounterFunc,
+		)
+		wcx.NonTrickle = true
+
+		wcx.SignalChan <- offer
+		if err := wcx.CreatePublisher(tracks); err != nil {
+			logger.Err(err).Msg("failed to create publisher")
+			httpx.Error(w, httpx.ErrFailedToCreatePublisher, http.StatusInternalServerError)
+			return
+		}
+		p.peers.Add(wcx)
+		go p.unregisterSession(meta, tracks, wcx.Done())
+		answer := <-wcx.SignalChan
+
+		resourceID := uuid.NewString()
+		p.resources.Store(resourceID, wcx)
+		go func() {
+			<-wcx.Done()
+			p.resources.Delete(resourceID)
+		}()
+		httpx.SetICEServerLinks(w.Header(), p.iceServers(meta.Id))
+		if err := httpx.WriteAnswer(w, path.Join(r.URL.Path, resourceID), answer); err != nil {
+			logger.Err(err).Msg("could not write answer")
+			return
+		}
+		logger.Info().Str("resource_id", resourceID).Msg("sent WHIP answer to edge")
+	}
+}
+
+// iceServers returns TURN servers with time-limited credentials issued to edge device of machine id, nil if they're not configured.
+// See: https://datatracker.ietf.org/doc/html/draft-ietf-wish-whip-01#section-4.4
+func (p *Publisher) iceServers(id string) []webrtc.ICEServer {
+	options := &p.config.TURNCredentialConfigOptions
+	if len(options.URLs) == 0 {
+		return nil
+	}
+	username, password := turn.RESTCredentials(options.SharedSecret, id, options.TTL)
+	return []webrtc.ICEServer{{
+		URLs:           options.URLs,
+		Username:       username,
+		Credential:     password,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	}}
+}
+
+// HandleWHIPDelete tears down a WHIP resource.
+func (p *Publisher) HandleWHIPDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.authorizeWHIP(r) {
+			p.logger.Warn().Str("remote_addr", r.RemoteAddr).Msg("unauthorized WHIP deletion")
+			unauthorized(w)
+			return
+		}
+		resourceID := mux.Vars(r)["resource_id"]
+		value, ok := p.resources.LoadAndDelete(resourceID)
+		if !ok {
+			httpx.Error(w, httpx.ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		if err := value.(*webrtcx.WebRTC).Close(); err != nil {
+			p.logger.Err(err).Str("resource_id", resourceID).Msg("could not close peer connection")
+		}
+		p.logger.Info().Str("resource_id", resourceID).Msg("deleted WHIP resource")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// authorizeWHIP reports whether request carries WHIP token as bearer token in Authorization header,
+// so that a live session can't be replaced or torn down by anyone else. No request is authorized without WHIP token.
+func (p *Publisher) authorizeWHIP(r *http.Request) bool {
+	token := p.config.WHIPConfigOptions.Token
+	if token == "" {
+		return false
+	}
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Bearer") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(token)) == 1
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	httpx.Error(w, httpx.ErrUnauthorized, http.StatusUnauthorized)
+}
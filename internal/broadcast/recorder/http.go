@@ -0,0 +1,102 @@
+package recorder
+
+import (
+	"errors"
+	"net/http"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+
+	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+)
+
+// HandleStart starts recording a live session by id, track source and optional stream query parameter.
+func (r *Recorder) HandleStart() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		meta, err := httpx.MetaFromRequest(req)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrIncorrectMetadata)
+			return
+		}
+		if !r.authorize(w, req, meta) {
+			return
+		}
+		info, err := r.Start(session.KeyFromMeta(meta))
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrSessionNotFound):
+			_ = httpx.WriteJSONError(w, http.StatusNotFound, httpx.ErrMetadataNotMatched)
+			return
+		case errors.Is(err, ErrAlreadyRecording):
+			_ = httpx.WriteJSONError(w, http.StatusConflict, httpx.ErrAlreadyRecording)
+			return
+		case errors.Is(err, ErrInvalidName):
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrIncorrectMetadata)
+			return
+		default:
+			r.logger.Err(err).Msg("could not start recording")
+			_ = httpx.WriteJSONError(w, http.StatusInternalServerError, httpx.ErrFailedToRecord)
+			return
+		}
+		if err := httpx.WriteJSON(w, http.StatusCreated, info); err != nil {
+			r.logger.Err(err).Msg("could not write recording")
+		}
+	}
+}
+
+// HandleStop stops recording a session.
+func (r *Recorder) HandleStop() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		meta, err := httpx.MetaFromRequest(req)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrIncorrectMetadata)
+			return
+		}
+		if !r.authorize(w, req, meta) {
+			return
+		}
+		info, err := r.Stop(session.KeyFromMeta(meta))
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusNotFound, httpx.ErrNotRecording)
+			return
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, info); err != nil {
+			r.logger.Err(err).Msg("could not write recording")
+		}
+	}
+}
+
+// HandleList lists ongoing recordings of sessions request is permitted to subscribe to.
+func (r *Recorder) HandleList() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		grant, err := r.authorizer.Authorize(req)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusUnauthorized, httpx.ErrUnauthorized)
+			return
+		}
+		infos := make([]Info, 0)
+		for _, info := range r.List() {
+			if grant.Allows(&pb.Meta{Id: info.ID, TrackSource: info.TrackSource, Stream: info.Stream}) {
+				infos = append(infos, info)
+			}
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, infos); err != nil {
+			r.logger.Err(err).Msg("could not write recordings")
+		}
+	}
+}
+
+// authorize replies an error unless request is permitted to subscribe to session of meta,
+// and reports whether it's permitted.
+func (r *Recorder) authorize(w http.ResponseWriter, req *http.Request, meta *pb.Meta) bool {
+	grant, err := r.authorizer.Authorize(req)
+	if err != nil {
+		_ = httpx.WriteJSONError(w, http.StatusUnauthorized, httpx.ErrUnauthorized)
+		return false
+	}
+	if !grant.Allows(meta) {
+		_ = httpx.WriteJSONError(w, http.StatusForbidden, httpx.ErrForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+// Package recorder records broadcast sessions to local files.
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+)
+
+// cleanInterval is interval of deleting expired recordings.
+const cleanInterval = time.Minute
+
+var (
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrAlreadyRecording = errors.New("session is already being recorded")
+	ErrNotRecording     = errors.New("session is not being recorded")
+	ErrInvalidName      = errors.New("invalid name for recording directory")
+)
+
+// Info is a snapshot of recording, it's used for REST API.
+type Info struct {
+	ID          string         `json:"id"`
+	TrackSource pb.TrackSource `json:"track_source"`
+	Stream      string         `json:"stream,omitempty"`
+	StartedAt   time.Time      `json:"started_at"`
+	Dir         string         `json:"dir"`
+}
+
+// Recorder records live sessions on demand.
+// Each session is recorded to its own directory "dir/id/track_source[/stream]" in segments.
+// H.264 and H.265 are written to MP4, VP8 and VP9 to IVF and Opus to OGG.
+type Recorder struct {
+	logger zerolog.Logger
+	config *cfg.RecorderConfigOptions
+
+	// sessions is shared with publishers and subscribers, it's only read by recorder.
+	sessions *session.Registry
+
+	// authorizer authorizes requests of recording API by the same credentials as viewers.
+	authorizer auth.Authorizer
+
+	mu         sync.Mutex
+	recordings map[session.Key]*recording
+}
+
+// New returns a new Recorder.
+func New(sessions *session.Registry, logger *zerolog.Logger, config *cfg.RecorderConfigOptions, authorizer auth.Authorizer) *Recorder {
+	l := logger.With().Str("component", "Recorder").Logger()
+	return &Recorder{
+		logger:     l,
+		config:     config,
+		sessions:   sessions,
+		authorizer: authorizer,
+		recordings: make(map[session.Key]*recording),
+	}
+}
+
+// Start starts recording a live session.
+func (r *Recorder) Start(key session.Key) (Info, error) {
+	sess, ok := r.sessions.Load(key)
+	if !ok {
+		return Info{}, ErrSessionNotFound
+	}
+	dir, err := r.dir(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.recordings[key]; ok {
+		return Info{}, ErrAlreadyRecording
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Info{}, fmt.Errorf("could not create recording directory: %w", err)
+	}
+
+	logger := r.logger.With().Str("id", key.ID).Int32("track_source", int32(key.TrackSource)).Str("stream", key.Stream).Logger()
+	rec := newRecording(key, dir, r.config.SegmentDuration, &logger)
+	r.recordings[key] = rec
+	go rec.run()
+	sess.Tracks.AddSink(rec)
+	// Video can't be recorded until next key frame.
+	sess.Tracks.RequestKeyFrame()
+
+	logger.Info().Str("dir", dir).Msg("started recording")
+	return rec.info(), nil
+}
+
+// Stop stops recording a session and closes its files.
+func (r *Recorder) Stop(key session.Key) (Info, error) {
+	r.mu.Lock()
+	rec, ok := r.recordings[key]
+	delete(r.recordings, key)
+	r.mu.Unlock()
+
+	if !ok {
+		return Info{}, ErrNotRecording
+	}
+	r.stop(rec)
+	return rec.info(), nil
+}
+
+// List returns all recordings ordered by id, track source and stream.
+func (r *Recorder) List() []Info {
+	r.mu.Lock()
+	infos := make([]Info, 0, len(r.recordings))
+	for _, rec := range r.recordings {
+		infos = append(infos, rec.info())
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ID != infos[j].ID {
+			return infos[i].ID < infos[j].ID
+		}
+		if infos[i].TrackSource != infos[j].TrackSource {
+			return infos[i].TrackSource < infos[j].TrackSource
+		}
+		return infos[i].Stream < infos[j].Stream
+	})
+	return infos
+}
+
+// Close stops all recordings, it's called on shutdown.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	recordings := r.recordings
+	r.recordings = make(map[session.Key]*recording)
+	r.mu.Unlock()
+
+	for _, rec := range recordings {
+		r.stop(rec)
+	}
+	return nil
+}
+
+// Clean deletes recordings older than retention periodically until ctx is done.
+// It returns immediately if retention is not set.
+func (r *Recorder) Clean(ctx context.Context) {
+	if r.config.Retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.removeExpired(); err != nil {
+			r.logger.Err(err).Msg("could not remove expired recordings")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop detaches recording from its session, then closes recording.
+func (r *Recorder) stop(rec *recording) {
+	if sess, ok := r.sessions.Load(rec.key); ok {
+		sess.Tracks.RemoveSink(rec)
+	}
+	rec.close()
+	rec.logger.Info().Msg("stopped recording")
+}
+
+func (r *Recorder) removeExpired() error {
+	deadline := time.Now().Add(-r.config.Retention)
+	return filepath.WalkDir(r.config.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(deadline) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			r.logger.Info().Str("file", path).Msg("removed expired recording")
+		}
+		return nil
+	})
+}
+
+// dir returns recording directory of a session.
+// Id and stream come from edge and users, so they are checked not to escape recording root directory.
+func (r *Recorder) dir(key session.Key) (string, error) {
+	elems := []string{r.config.Dir, key.ID, strings.ToLower(key.TrackSource.String())}
+	if key.Stream != "" {
+		elems = append(elems, key.Stream)
+	}
+	for _, name := range elems[1:] {
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return "", ErrInvalidName
+		}
+	}
+	return filepath.Join(elems...), nil
+}
@@ -0,0 +1,200 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+)
+
+func TestRecorderDir(t *testing.T) {
+	logger := zerolog.Nop()
+	r := New(session.NewRegistry(), &logger, &cfg.RecorderConfigOptions{Dir: "recordings"}, auth.AllowAll)
+
+	dir, err := r.dir(session.Key{ID: "0cbab001", TrackSource: pb.TrackSource_DRONE, Stream: "front"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("recordings", "0cbab001", "drone", "front"); dir != want {
+		t.Errorf("got %s, want %s", dir, want)
+	}
+
+	for _, key := range []session.Key{
+		{ID: "..", TrackSource: pb.TrackSource_DRONE},
+		{ID: "a/b", TrackSource: pb.TrackSource_DRONE},
+		{ID: "a", TrackSource: pb.TrackSource_DRONE, Stream: "../b"},
+	} {
+		if _, err := r.dir(key); err != ErrInvalidName {
+			t.Errorf("%+v: got %v, want %v", key, err, ErrInvalidName)
+		}
+	}
+}
+
+func TestH265Depacketizer(t *testing.T) {
+	var d h265Depacketizer
+
+	// Aggregation packet of VPS and SPS.
+	ap := []byte{h265NALUTypeAP << 1, 0x01, 0x00, 0x02, 0x40, 0x01, 0x00, 0x03, 0x42, 0x01, 0x01}
+	out, err := d.Unmarshal(ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 1, 0x40, 0x01, 0, 0, 0, 1, 0x42, 0x01, 0x01}; !bytes.Equal(out, want) {
+		t.Errorf("aggregation packet: got %x, want %x", out, want)
+	}
+
+	// Fragmentation units of an IDR_W_RADL NAL unit.
+	start := []byte{h265NALUTypeFU << 1, 0x01, 0x80 | 19, 0xaa}
+	end := []byte{h265NALUTypeFU << 1, 0x01, 0x40 | 19, 0xbb}
+	if !d.IsPartitionHead(start) || d.IsPartitionHead(end) {
+		t.Error("only the start fragment is partition head")
+	}
+	var nalu []byte
+	for _, fu := range [][]byte{start, end} {
+		out, err := d.Unmarshal(fu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nalu = append(nalu, out...)
+	}
+	if want := []byte{0, 0, 0, 1, 19 << 1, 0x01, 0xaa, 0xbb}; !bytes.Equal(nalu, want) {
+		t.Errorf("fragmentation units: got %x, want %x", nalu, want)
+	}
+}
+
+func TestVP9KeyFrame(t *testing.T) {
+	for _, tc := range []struct {
+		header byte
+		want   bool
+	}{
+		{0b10_0_0_0_0_00, true},  // Profile 0 key frame.
+		{0b10_0_0_0_1_00, false}, // Profile 0 inter frame.
+		{0b10_0_0_1_000, false},  // Profile 0 show existing frame.
+		{0b10_1_1_0_0_0_0, true}, // Profile 3 key frame.
+		{0b10_1_1_0_0_1_0, false},
+		{0b00_0_0_0_0_00, false}, // Bad frame marker.
+	} {
+		if got := vp9KeyFrame([]byte{tc.header}); got != tc.want {
+			t.Errorf("%08b: got %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestRecordingVP8(t *testing.T) {
+	dir := t.TempDir()
+	logger := zerolog.Nop()
+	rec := newRecording(session.Key{ID: "id"}, dir, 0, &logger)
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+	}
+
+	// An inter frame before key frame is dropped, the last frame is pending in samplebuilder.
+	frames := [][]byte{{0x01, 0xaa}, {0x00, 0xbb}, {0x01, 0xcc}, {0x01, 0xdd}}
+	for i, frame := range frames {
+		rec.write(packet{
+			kind:  webrtc.RTPCodecTypeVideo,
+			codec: codec,
+			Packet: &rtp.Packet{
+				Header: rtp.Header{
+					Marker:         true,
+					SequenceNumber: uint16(i),
+					Timestamp:      uint32(i * 3000),
+					SSRC:           1,
+				},
+				// VP8 payload descriptor with start of partition.
+				Payload: append([]byte{0x10}, frame...),
+			},
+		})
+	}
+	rec.closeTrack(webrtc.RTPCodecTypeVideo)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.ivf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("DKIF")) || string(b[8:12]) != "VP80" {
+		t.Fatalf("bad IVF header %x", b[:32])
+	}
+	if n := binary.LittleEndian.Uint32(b[24:]); n != 2 {
+		t.Errorf("got %d frames, want 2", n)
+	}
+	// The second frame is 1/30 second after the first one.
+	if pts := binary.LittleEndian.Uint64(b[32+12+2+4:]); pts != 3000 {
+		t.Errorf("got pts %d, want 3000", pts)
+	}
+}
+
+func TestRecordingH264(t *testing.T) {
+	dir := t.TempDir()
+	logger := zerolog.Nop()
+	rec := newRecording(session.Key{ID: "id"}, dir, 0, &logger)
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000},
+	}
+
+	// STAP-A of SPS and PPS.
+	stapA := []byte{
+		0x78,
+		0x00, 0x0f, 0x67, 0x42, 0xc0, 0x1f, 0x1a, 0x32, 0x35, 0x01, 0x40, 0x7a, 0x40, 0x3c, 0x22, 0x11, 0xa8,
+		0x00, 0x05, 0x68, 0x1a, 0x34, 0xe3, 0xc8,
+	}
+	payloads := []struct {
+		payload   []byte
+		timestamp uint32
+		marker    bool
+	}{
+		{stapA, 0, false},
+		{[]byte{0x65, 0x88, 0x84}, 0, true}, // IDR.
+		{[]byte{0x41, 0x9a, 0x02}, 3000, true},
+		{[]byte{0x41, 0x9a, 0x04}, 6000, true},
+	}
+	for i, p := range payloads {
+		rec.write(packet{
+			kind:  webrtc.RTPCodecTypeVideo,
+			codec: codec,
+			Packet: &rtp.Packet{
+				Header:  rtp.Header{Marker: p.marker, SequenceNumber: uint16(i), Timestamp: p.timestamp, SSRC: 1},
+				Payload: p.payload,
+			},
+		})
+	}
+	rec.closeTrack(webrtc.RTPCodecTypeVideo)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, box := range []string{"mdat", "moov", "avc1", "avcC"} {
+		if !bytes.Contains(b, []byte(box)) {
+			t.Errorf("no %s box", box)
+		}
+	}
+	// Parameter sets are moved to avcC, the IDR frame is length prefixed in mdat.
+	if !bytes.Contains(b, []byte{0x00, 0x00, 0x00, 0x03, 0x65, 0x88, 0x84}) {
+		t.Error("no IDR frame in mdat")
+	}
+}
@@ -0,0 +1,248 @@
+package recorder
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+)
+
+const (
+	// packetQueueSize is number of packets buffered between forwarding loop and file writing.
+	// Packets are dropped if it's full, so that slow disk never stalls forwarding.
+	packetQueueSize = 1024
+
+	// idleTimeout is how long a track can receive nothing before its file is closed.
+	idleTimeout = 10 * time.Second
+
+	// segmentTimeLayout is layout of segment file names, it's sortable.
+	segmentTimeLayout = "20060102T150405.000Z"
+)
+
+type packet struct {
+	kind  webrtc.RTPCodecType
+	codec webrtc.RTPCodecParameters
+	*rtp.Packet
+}
+
+// recording writes RTP packets of a session to segment files.
+// It implements webrtc.RTPSink.
+type recording struct {
+	key             session.Key
+	dir             string
+	segmentDuration time.Duration
+	startedAt       time.Time
+	logger          zerolog.Logger
+
+	packets chan packet
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	// tracks are only accessed in run loop.
+	tracks map[webrtc.RTPCodecType]trackWriter
+}
+
+// trackWriter writes packets of a track to segment files.
+type trackWriter interface {
+	writeRTP(p *rtp.Packet) error
+	close() error
+	// codec and ssrc identify the remote track, a new writer is created if they change.
+	codec() webrtc.RTPCodecParameters
+	ssrc() uint32
+	lastPacketAt() time.Time
+}
+
+func newRecording(key session.Key, dir string, segmentDuration time.Duration, logger *zerolog.Logger) *recording {
+	return &recording{
+		key:             key,
+		dir:             dir,
+		segmentDuration: segmentDuration,
+		startedAt:       time.Now(),
+		logger:          *logger,
+		packets:         make(chan packet, packetQueueSize),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+		tracks:          make(map[webrtc.RTPCodecType]trackWriter),
+	}
+}
+
+// WriteRTP queues a packet without blocking.
+func (rec *recording) WriteRTP(kind webrtc.RTPCodecType, codec webrtc.RTPCodecParameters, p *rtp.Packet) {
+	select {
+	case rec.packets <- packet{kind: kind, codec: codec, Packet: p}:
+	default:
+		packetsDropped.Inc()
+	}
+}
+
+func (rec *recording) info() Info {
+	return Info{
+		ID:          rec.key.ID,
+		TrackSource: rec.key.TrackSource,
+		Stream:      rec.key.Stream,
+		StartedAt:   rec.startedAt,
+		Dir:         rec.dir,
+	}
+}
+
+func (rec *recording) run() {
+	defer close(rec.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case p := <-rec.packets:
+			rec.write(p)
+		case <-ticker.C:
+			for kind, w := range rec.tracks {
+				if time.Since(w.lastPacketAt()) > idleTimeout {
+					rec.logger.Info().Str("kind", kind.String()).Msg("track is idle, closing file")
+					rec.closeTrack(kind)
+				}
+			}
+		case <-rec.stop:
+			for kind := range rec.tracks {
+				rec.closeTrack(kind)
+			}
+			return
+		}
+	}
+}
+
+// close stops run loop and waits for files to be closed.
+func (rec *recording) close() {
+	rec.once.Do(func() { close(rec.stop) })
+	<-rec.done
+}
+
+func (rec *recording) write(p packet) {
+	w, ok := rec.tracks[p.kind]
+	if ok && (w.ssrc() != uint32(p.SSRC) || !strings.EqualFold(w.codec().MimeType, p.codec.MimeType)) {
+		// Edge reconnected or changed codec.
+		rec.closeTrack(p.kind)
+		ok = false
+	}
+	if !ok {
+		w = rec.newTrackWriter(p)
+		rec.tracks[p.kind] = w
+	}
+	if err := w.writeRTP(p.Packet); err != nil {
+		rec.logger.Err(err).Str("kind", p.kind.String()).Msg("could not write recording, closing file")
+		rec.closeTrack(p.kind)
+		// Drop the rest of track rather than failing on every packet, until edge reconnects.
+		rec.tracks[p.kind] = &discardWriter{codecParameters: p.codec, ssrcValue: uint32(p.SSRC), lastPacket: time.Now()}
+	}
+}
+
+// newTrackWriter returns a writer of track codec or a discardWriter if the codec can't be recorded.
+func (rec *recording) newTrackWriter(p packet) trackWriter {
+	segmentName := func(ext string) func(time.Time) string {
+		return func(t time.Time) string {
+			return filepath.Join(rec.dir, t.UTC().Format(segmentTimeLayout)+"."+ext)
+		}
+	}
+
+	logger := rec.logger.With().Str("kind", p.kind.String()).Str("mime_type", p.codec.MimeType).Logger()
+	if format := newVideoFormat(p.codec); format != nil {
+		logger.Info().Msg("recording video track")
+		return newVideoWriter(p.codec, uint32(p.SSRC), format, rec.segmentDuration, segmentName(format.ext()), &logger)
+	}
+	if strings.EqualFold(p.codec.MimeType, webrtc.MimeTypeOpus) {
+		logger.Info().Msg("recording audio track")
+		return newOggWriter(p.codec, uint32(p.SSRC), rec.segmentDuration, segmentName("ogg"))
+	}
+
+	logger.Warn().Msg("codec is not supported by recorder, dropping track")
+	return &discardWriter{codecParameters: p.codec, ssrcValue: uint32(p.SSRC), lastPacket: time.Now()}
+}
+
+func (rec *recording) closeTrack(kind webrtc.RTPCodecType) {
+	if err := rec.tracks[kind].close(); err != nil {
+		rec.logger.Err(err).Str("kind", kind.String()).Msg("could not close recording")
+	}
+	delete(rec.tracks, kind)
+}
+
+// oggWriter writes Opus packets to OGG segments rotated by time.
+type oggWriter struct {
+	codecParameters webrtc.RTPCodecParameters
+	ssrcValue       uint32
+	segmentDuration time.Duration
+	segmentName     func(time.Time) string
+
+	writer           *oggwriter.OggWriter
+	segmentStartedAt time.Time
+	lastPacket       time.Time
+}
+
+func newOggWriter(codec webrtc.RTPCodecParameters, ssrc uint32, segmentDuration time.Duration, segmentName func(time.Time) string) *oggWriter {
+	return &oggWriter{
+		codecParameters: codec,
+		ssrcValue:       ssrc,
+		segmentDuration: segmentDuration,
+		segmentName:     segmentName,
+		lastPacket:      time.Now(),
+	}
+}
+
+func (w *oggWriter) writeRTP(p *rtp.Packet) error {
+	now := time.Now()
+	w.lastPacket = now
+	if w.writer != nil && w.segmentDuration > 0 && now.Sub(w.segmentStartedAt) >= w.segmentDuration {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+	if w.writer == nil {
+		channels := w.codecParameters.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		writer, err := oggwriter.New(w.segmentName(now), w.codecParameters.ClockRate, channels)
+		if err != nil {
+			return err
+		}
+		w.writer = writer
+		w.segmentStartedAt = now
+	}
+	return w.writer.WriteRTP(p)
+}
+
+func (w *oggWriter) close() error {
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Close()
+	w.writer = nil
+	return err
+}
+
+func (w *oggWriter) codec() webrtc.RTPCodecParameters { return w.codecParameters }
+func (w *oggWriter) ssrc() uint32                     { return w.ssrcValue }
+func (w *oggWriter) lastPacketAt() time.Time          { return w.lastPacket }
+
+// discardWriter drops packets of a track which can't be recorded.
+type discardWriter struct {
+	codecParameters webrtc.RTPCodecParameters
+	ssrcValue       uint32
+	lastPacket      time.Time
+}
+
+func (w *discardWriter) writeRTP(_ *rtp.Packet) error {
+	w.lastPacket = time.Now()
+	return nil
+}
+
+func (w *discardWriter) close() error                     { return nil }
+func (w *discardWriter) codec() webrtc.RTPCodecParameters { return w.codecParameters }
+func (w *discardWriter) ssrc() uint32                     { return w.ssrcValue }
+func (w *discardWriter) lastPacketAt() time.Time          { return w.lastPacket }
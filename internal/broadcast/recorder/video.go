@@ -0,0 +1,469 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/format/mp4"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// maxLate is how many packets samplebuilder holds to reorder packets and assemble frames.
+	maxLate = 512
+
+	// ivfTimeScale is time base of IVF frame timestamps, the same as RTP video clock rate.
+	ivfTimeScale = 90000
+)
+
+// errNoCodecData means a segment can't be started as decoder configuration hasn't been received yet.
+var errNoCodecData = errors.New("no codec data")
+
+// videoFormat converts depacketized samples to frames of a container format.
+type videoFormat interface {
+	depacketizer() rtp.Depacketizer
+	ext() string
+	// frame returns sample in container format and reports whether it's a key frame.
+	frame(sample []byte) ([]byte, bool)
+	// newSegment creates a segment file, it fails with errNoCodecData if stream isn't decodable yet.
+	newSegment(name string) (segment, error)
+}
+
+// segment is a file of a video track starting with a key frame.
+type segment interface {
+	writeFrame(frame []byte, keyFrame bool, pts time.Duration) error
+	close() error
+}
+
+// newVideoFormat returns format of video codec, or nil if the codec can't be recorded.
+func newVideoFormat(codec webrtc.RTPCodecParameters) videoFormat {
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		return &h26xFormat{parameterSets: make(map[byte][]byte)}
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH265):
+		return &h26xFormat{hevc: true, parameterSets: make(map[byte][]byte)}
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		return &vpxFormat{fourcc: "VP80"}
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP9):
+		return &vpxFormat{fourcc: "VP90", vp9: true}
+	default:
+		return nil
+	}
+}
+
+// videoWriter assembles frames from RTP packets and writes them to segments starting with key frames.
+// A new segment is started on the first key frame after segment duration.
+type videoWriter struct {
+	codecParameters webrtc.RTPCodecParameters
+	ssrcValue       uint32
+	format          videoFormat
+	segmentDuration time.Duration
+	segmentName     func(time.Time) string
+	logger          zerolog.Logger
+
+	builder          *samplebuilder.SampleBuilder
+	segment          segment
+	segmentStartedAt time.Time
+	firstTimestamp   uint32
+	lastPacket       time.Time
+}
+
+func newVideoWriter(
+	codec webrtc.RTPCodecParameters,
+	ssrc uint32,
+	format videoFormat,
+	segmentDuration time.Duration,
+	segmentName func(time.Time) string,
+	logger *zerolog.Logger,
+) *videoWriter {
+	if codec.ClockRate == 0 {
+		codec.ClockRate = ivfTimeScale
+	}
+	return &videoWriter{
+		codecParameters: codec,
+		ssrcValue:       ssrc,
+		format:          format,
+		segmentDuration: segmentDuration,
+		segmentName:     segmentName,
+		logger:          *logger,
+		builder:         samplebuilder.New(maxLate, format.depacketizer(), codec.ClockRate),
+		lastPacket:      time.Now(),
+	}
+}
+
+func (w *videoWriter) writeRTP(p *rtp.Packet) error {
+	w.lastPacket = time.Now()
+	w.builder.Push(p)
+	for sample := w.builder.Pop(); sample != nil; sample = w.builder.Pop() {
+		if err := w.writeSample(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *videoWriter) writeSample(sample *media.Sample) error {
+	frame, keyFrame := w.format.frame(sample.Data)
+	if len(frame) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if keyFrame && (w.segment == nil || (w.segmentDuration > 0 && now.Sub(w.segmentStartedAt) >= w.segmentDuration)) {
+		if err := w.close(); err != nil {
+			return err
+		}
+		name := w.segmentName(now)
+		seg, err := w.format.newSegment(name)
+		if errors.Is(err, errNoCodecData) {
+			w.logger.Debug().Msg("key frame without codec data, waiting for next key frame")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.segment = seg
+		w.segmentStartedAt = now
+		w.firstTimestamp = sample.PacketTimestamp
+		w.logger.Info().Str("file", name).Msg("started segment")
+	}
+	if w.segment == nil {
+		// Waiting for a key frame.
+		return nil
+	}
+
+	pts := time.Duration(sample.PacketTimestamp-w.firstTimestamp) * time.Second / time.Duration(w.codecParameters.ClockRate)
+	return w.segment.writeFrame(frame, keyFrame, pts)
+}
+
+func (w *videoWriter) close() error {
+	if w.segment == nil {
+		return nil
+	}
+	err := w.segment.close()
+	w.segment = nil
+	return err
+}
+
+func (w *videoWriter) codec() webrtc.RTPCodecParameters { return w.codecParameters }
+func (w *videoWriter) ssrc() uint32                     { return w.ssrcValue }
+func (w *videoWriter) lastPacketAt() time.Time          { return w.lastPacket }
+
+// h26xFormat writes H.264 or H.265 to MP4.
+// Parameter sets are taken out of frames into decoder configuration of MP4.
+type h26xFormat struct {
+	hevc bool
+
+	// parameterSets are the latest parameter sets by NAL unit type.
+	parameterSets map[byte][]byte
+}
+
+func (f *h26xFormat) depacketizer() rtp.Depacketizer {
+	if f.hevc {
+		return &h265Depacketizer{}
+	}
+	return &codecs.H264Packet{}
+}
+
+func (f *h26xFormat) ext() string { return "mp4" }
+
+// frame converts Annex-B sample to length prefixed NAL units.
+func (f *h26xFormat) frame(sample []byte) ([]byte, bool) {
+	var (
+		frame    []byte
+		keyFrame bool
+	)
+	for _, nalu := range splitAnnexB(sample) {
+		naluType := f.naluType(nalu)
+		if f.isParameterSet(naluType) {
+			f.parameterSets[naluType] = append([]byte(nil), nalu...)
+			continue
+		}
+		if f.isKeyFrame(naluType) {
+			keyFrame = true
+		}
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(nalu)))
+		frame = append(frame, nalu...)
+	}
+	return frame, keyFrame
+}
+
+func (f *h26xFormat) newSegment(name string) (segment, error) {
+	var (
+		codec av.CodecData
+		err   error
+	)
+	if f.hevc {
+		vps, sps, pps := f.parameterSets[32], f.parameterSets[33], f.parameterSets[34]
+		if vps == nil || sps == nil || pps == nil {
+			return nil, errNoCodecData
+		}
+		codec, err = h265parser.NewCodecDataFromVPSAndSPSAndPPS(vps, sps, pps)
+	} else {
+		sps, pps := f.parameterSets[7], f.parameterSets[8]
+		if sps == nil || pps == nil {
+			return nil, errNoCodecData
+		}
+		codec, err = h264parser.NewCodecDataFromSPSAndPPS(sps, pps)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse parameter sets: %w", err)
+	}
+	return newMP4Segment(name, codec)
+}
+
+func (f *h26xFormat) naluType(nalu []byte) byte {
+	if f.hevc {
+		return (nalu[0] >> 1) & 0x3f
+	}
+	return nalu[0] & 0x1f
+}
+
+// isParameterSet reports whether NAL unit type is VPS, SPS or PPS.
+func (f *h26xFormat) isParameterSet(naluType byte) bool {
+	if f.hevc {
+		return naluType >= 32 && naluType <= 34
+	}
+	return naluType == 7 || naluType == 8
+}
+
+// isKeyFrame reports whether NAL unit type is IDR, or IRAP of H.265.
+func (f *h26xFormat) isKeyFrame(naluType byte) bool {
+	if f.hevc {
+		return naluType >= 16 && naluType <= 21
+	}
+	return naluType == 5
+}
+
+type mp4Segment struct {
+	file  *os.File
+	muxer *mp4.Muxer
+}
+
+func newMP4Segment(name string, codec av.CodecData) (*mp4Segment, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	muxer := mp4.NewMuxer(file)
+	muxer.NegativeTsMakeZero = true
+	if err := muxer.WriteHeader([]av.CodecData{codec}); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	return &mp4Segment{file: file, muxer: muxer}, nil
+}
+
+func (s *mp4Segment) writeFrame(frame []byte, keyFrame bool, pts time.Duration) error {
+	return s.muxer.WritePacket(av.Packet{
+		IsKeyFrame: keyFrame,
+		Time:       pts,
+		Data:       frame,
+	})
+}
+
+func (s *mp4Segment) close() error {
+	return errors.Join(s.muxer.WriteTrailer(), s.file.Close())
+}
+
+// vpxFormat writes VP8 or VP9 to IVF.
+type vpxFormat struct {
+	fourcc string
+	vp9    bool
+}
+
+func (f *vpxFormat) depacketizer() rtp.Depacketizer {
+	if f.vp9 {
+		return &codecs.VP9Packet{}
+	}
+	return &codecs.VP8Packet{}
+}
+
+func (f *vpxFormat) ext() string { return "ivf" }
+
+func (f *vpxFormat) frame(sample []byte) ([]byte, bool) {
+	if len(sample) == 0 {
+		return nil, false
+	}
+	if f.vp9 {
+		return sample, vp9KeyFrame(sample)
+	}
+	// Inverse key frame flag of VP8 frame tag.
+	return sample, sample[0]&0x01 == 0
+}
+
+func (f *vpxFormat) newSegment(name string) (segment, error) {
+	return newIVFSegment(name, f.fourcc)
+}
+
+// vp9KeyFrame reports whether frame is a key frame by its uncompressed header.
+// See: VP9 Bitstream Specification 6.2
+func vp9KeyFrame(frame []byte) bool {
+	// frame_marker.
+	if frame[0]>>6 != 0x2 {
+		return false
+	}
+	profile := (frame[0]>>5)&0x1 | ((frame[0]>>4)&0x1)<<1
+	bit := 3
+	if profile == 3 {
+		// reserved_zero.
+		bit--
+	}
+	// show_existing_frame.
+	if (frame[0]>>bit)&0x1 == 1 {
+		return false
+	}
+	// frame_type, 0 is KEY_FRAME.
+	return (frame[0]>>(bit-1))&0x1 == 0
+}
+
+// ivfSegment is an IVF file, pion's IVFWriter doesn't support VP9.
+// See: https://wiki.multimedia.cx/index.php/Duck_IVF
+type ivfSegment struct {
+	file   *os.File
+	frames uint32
+}
+
+func newIVFSegment(name, fourcc string) (*ivfSegment, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)  // Version
+	binary.LittleEndian.PutUint16(header[6:], 32) // Header size
+	copy(header[8:], fourcc)
+	// Width and height are left zero, decoders take them from bitstream.
+	binary.LittleEndian.PutUint32(header[16:], ivfTimeScale) // Time base denominator
+	binary.LittleEndian.PutUint32(header[20:], 1)            // Time base numerator
+	// Frame count is updated on close.
+	if _, err := file.Write(header); err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+	return &ivfSegment{file: file}, nil
+}
+
+func (s *ivfSegment) writeFrame(frame []byte, _ bool, pts time.Duration) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], uint64((pts*ivfTimeScale+time.Second/2)/time.Second)) // Rounded to nearest tick
+	if _, err := s.file.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(frame); err != nil {
+		return err
+	}
+	s.frames++
+	return nil
+}
+
+func (s *ivfSegment) close() error {
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, s.frames)
+	_, err := s.file.WriteAt(count, 24)
+	return errors.Join(err, s.file.Close())
+}
+
+// h265Depacketizer depacketizes H.265 RTP payloads to Annex-B NAL units,
+// pion's H265Packet can't be used by samplebuilder as it doesn't tell partition heads.
+// DONL is not supported as it's never negotiated by edge.
+// See: https://datatracker.ietf.org/doc/html/rfc7798#section-4.4
+type h265Depacketizer struct{}
+
+const (
+	h265NALUTypeAP = 48
+	h265NALUTypeFU = 49
+)
+
+var errShortPacket = errors.New("packet is not large enough")
+
+func (d *h265Depacketizer) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) < 3 {
+		return nil, errShortPacket
+	}
+	switch (payload[0] >> 1) & 0x3f {
+	case h265NALUTypeAP:
+		var out []byte
+		for b := payload[2:]; len(b) > 0; {
+			if len(b) < 2 {
+				return nil, errShortPacket
+			}
+			size := int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+			if size > len(b) {
+				return nil, errShortPacket
+			}
+			out = append(out, annexBStartCode...)
+			out = append(out, b[:size]...)
+			b = b[size:]
+		}
+		return out, nil
+	case h265NALUTypeFU:
+		fuHeader := payload[2]
+		if fuHeader&0x80 == 0 {
+			return append([]byte(nil), payload[3:]...), nil
+		}
+		// Restore NAL unit header from payload header and FU type.
+		out := append([]byte(nil), annexBStartCode...)
+		out = append(out, (payload[0]&0x81)|(fuHeader&0x3f)<<1, payload[1])
+		return append(out, payload[3:]...), nil
+	default:
+		return append(append([]byte(nil), annexBStartCode...), payload...), nil
+	}
+}
+
+func (d *h265Depacketizer) IsPartitionHead(payload []byte) bool {
+	if len(payload) < 3 {
+		return false
+	}
+	if (payload[0]>>1)&0x3f == h265NALUTypeFU {
+		return payload[2]&0x80 != 0
+	}
+	return true
+}
+
+func (d *h265Depacketizer) IsPartitionTail(marker bool, _ []byte) bool {
+	return marker
+}
+
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// splitAnnexB splits an Annex-B byte stream into NAL units without start codes.
+func splitAnnexB(b []byte) [][]byte {
+	startCode := annexBStartCode[1:]
+
+	var nalus [][]byte
+	start := bytes.Index(b, startCode)
+	if start < 0 {
+		return nil
+	}
+	start += len(startCode)
+	for {
+		next := bytes.Index(b[start:], startCode)
+		end := start + next
+		if next < 0 {
+			end = len(b)
+		}
+		// Zero byte before a 3 bytes start code belongs to a 4 bytes start code.
+		if nalu := bytes.TrimRight(b[start:end], "\x00"); len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+		if next < 0 {
+			return nalus
+		}
+		start = end + len(startCode)
+	}
+}
@@ -0,0 +1,14 @@
+package session
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// viewers are set of registered sessions only, a session's series is deleted after it's unregistered.
+var viewers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "charoite",
+	Subsystem: "broadcast",
+	Name:      "viewers",
+	Help:      "Number of connected subscribers of a session.",
+}, []string{"id", "track_source", "stream"})
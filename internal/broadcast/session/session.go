@@ -0,0 +1,185 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+// Key identifies a session by edge device id, track source and stream label.
+// It's comparable and used as map key.
+type Key struct {
+	ID          string
+	TrackSource pb.TrackSource
+	Stream      string
+}
+
+// KeyFromMeta returns session key of edge device metadata.
+func KeyFromMeta(meta *pb.Meta) Key {
+	return Key{
+		ID:          meta.Id,
+		TrackSource: meta.TrackSource,
+		Stream:      meta.Stream,
+	}
+}
+
+// Session is a live stream published by an edge device.
+type Session struct {
+	Meta      *pb.Meta
+	StartedAt time.Time
+	Tracks    *webrtcx.LocalTracks
+
+	viewers atomic.Int64
+}
+
+// Info is a snapshot of session, it's used for REST API.
+type Info struct {
+	ID           string         `json:"id"`
+	TrackSource  pb.TrackSource `json:"track_source"`
+	Stream       string         `json:"stream,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	VideoCodec   string         `json:"video_codec"`
+	AudioCodec   string         `json:"audio_codec,omitempty"`
+	Layers       []string       `json:"layers,omitempty"` // RTP stream ids of simulcast video layers.
+	Viewers      int64          `json:"viewers"`
+	LastPacketAt *time.Time     `json:"last_packet_at,omitempty"`
+}
+
+// New returns a new Session started now.
+func New(meta *pb.Meta, tracks *webrtcx.LocalTracks) *Session {
+	return &Session{
+		Meta:      meta,
+		StartedAt: time.Now(),
+		Tracks:    tracks,
+	}
+}
+
+// Viewers returns viewers number.
+func (s *Session) Viewers() int64 {
+	return s.viewers.Load()
+}
+
+// Info returns a snapshot of session.
+func (s *Session) Info() Info {
+	info := Info{
+		ID:          s.Meta.Id,
+		TrackSource: s.Meta.TrackSource,
+		Stream:      s.Meta.Stream,
+		StartedAt:   s.StartedAt,
+		VideoCodec:  s.Tracks.Video.Codec().MimeType,
+		Viewers:     s.Viewers(),
+	}
+	for _, layer := range s.Tracks.Layers {
+		if layer.RID() != "" {
+			info.Layers = append(info.Layers, layer.RID())
+		}
+	}
+	if s.Tracks.Audio != nil {
+		info.AudioCodec = s.Tracks.Audio.Codec().MimeType
+	}
+	if t := s.Tracks.LastPacketAt(); !t.IsZero() {
+		info.LastPacketAt = &t
+	}
+	return info
+}
+
+// Registry holds all sessions, it's shared between publishers and subscribers.
+// It's mainly written by publishers and read by subscribers.
+type Registry struct {
+	mu       sync.RWMutex
+	sessions map[Key]*Session
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		sessions: make(map[Key]*Session),
+	}
+}
+
+// Register stores a session by key and reports whether an old session was replaced.
+// Viewers of the old session stay counted by it, as they are still subscribed to its tracks.
+// RTP sinks of the old session are taken over, so that e.g. a recording continues after edge reconnects.
+func (r *Registry) Register(key Key, s *Session) (replaced bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.sessions[key]
+	if ok {
+		for _, sink := range old.Tracks.Sinks() {
+			s.Tracks.AddSink(sink)
+		}
+	}
+	r.sessions[key] = s
+	viewers.WithLabelValues(s.Meta.Id, s.Meta.TrackSource.String(), s.Meta.Stream).Set(float64(s.Viewers()))
+	return ok
+}
+
+// Unregister removes the session of key if it's still the session of tracks, and reports whether it's removed.
+// A session replaced by a reconnected publisher is kept when the old publisher is closed.
+func (r *Registry) Unregister(key Key, tracks *webrtcx.LocalTracks) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[key]
+	if !ok || s.Tracks != tracks {
+		return false
+	}
+	delete(r.sessions, key)
+	viewers.DeleteLabelValues(s.Meta.Id, s.Meta.TrackSource.String(), s.Meta.Stream)
+	return true
+}
+
+// AddViewers adds delta to viewers number of s, the session subscribers joined, and returns viewers number of its key:
+// the new number of s if it's still the session of key, or the number of the session replacing it.
+// Viewers leaving a replaced or unregistered session are never counted in another session.
+func (r *Registry) AddViewers(s *Session, delta int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := s.viewers.Add(delta)
+	current, ok := r.sessions[KeyFromMeta(s.Meta)]
+	if !ok {
+		return n
+	}
+	if current != s {
+		return current.Viewers()
+	}
+	viewers.WithLabelValues(s.Meta.Id, s.Meta.TrackSource.String(), s.Meta.Stream).Set(float64(n))
+	return n
+}
+
+// Load returns the session of key if any.
+func (r *Registry) Load(key Key) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.sessions[key]
+	return s, ok
+}
+
+// List returns all sessions ordered by id, track source and stream.
+func (r *Registry) List() []*Session {
+	r.mu.RLock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Meta.Id != sessions[j].Meta.Id {
+			return sessions[i].Meta.Id < sessions[j].Meta.Id
+		}
+		if sessions[i].Meta.TrackSource != sessions[j].Meta.TrackSource {
+			return sessions[i].Meta.TrackSource < sessions[j].Meta.TrackSource
+		}
+		return sessions[i].Meta.Stream < sessions[j].Meta.Stream
+	})
+	return sessions
+}
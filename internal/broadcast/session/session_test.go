@@ -0,0 +1,108 @@
+package session
+
+import (
+	"testing"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/pion/webrtc/v3"
+
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+func newTestSession(t *testing.T, meta *pb.Meta) *Session {
+	t.Helper()
+
+	videoTrack := webrtcx.CreateLocalTrack(webrtc.MimeTypeH264, nil)
+	return New(meta, &webrtcx.LocalTracks{Video: videoTrack})
+}
+
+func TestKeyCollision(t *testing.T) {
+	a := &pb.Meta{Id: "ab1", TrackSource: pb.TrackSource(2)}
+	b := &pb.Meta{Id: "ab", TrackSource: pb.TrackSource(12)}
+
+	if KeyFromMeta(a) == KeyFromMeta(b) {
+		t.Fatalf("keys of %v and %v should not collide", a, b)
+	}
+
+	r := NewRegistry()
+	r.Register(KeyFromMeta(a), newTestSession(t, a))
+	if _, ok := r.Load(KeyFromMeta(b)); ok {
+		t.Fatalf("session of %v should not be found by %v", a, b)
+	}
+}
+
+func TestKeyStream(t *testing.T) {
+	cam1 := &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR, Stream: "cam1"}
+	cam2 := &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR, Stream: "cam2"}
+	legacy := &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_MONITOR}
+
+	r := NewRegistry()
+	for _, meta := range []*pb.Meta{cam2, legacy, cam1} {
+		if r.Register(KeyFromMeta(meta), newTestSession(t, meta)) {
+			t.Fatalf("session of %v should not replace any session", meta)
+		}
+	}
+
+	sessions := r.List()
+	if len(sessions) != 3 {
+		t.Fatalf("got %d sessions, want 3", len(sessions))
+	}
+	for i, want := range []string{"", "cam1", "cam2"} {
+		if got := sessions[i].Meta.Stream; got != want {
+			t.Errorf("session %d: got stream %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	meta := &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_DRONE}
+	r := NewRegistry()
+
+	old := newTestSession(t, meta)
+	if r.Register(KeyFromMeta(meta), old) {
+		t.Fatal("first registration should not replace any session")
+	}
+	r.AddViewers(old, 2)
+
+	// An equal key from a different metadata instance finds the same session.
+	key := KeyFromMeta(&pb.Meta{Id: "abc", TrackSource: pb.TrackSource_DRONE})
+	s, ok := r.Load(key)
+	if !ok || s != old {
+		t.Fatal("registered session not found")
+	}
+
+	renewed := newTestSession(t, meta)
+	if !r.Register(key, renewed) {
+		t.Fatal("second registration should replace old session")
+	}
+	// Viewers of the old session leave it, they're not counted in the renewed session.
+	if got := r.AddViewers(old, -1); got != 0 {
+		t.Fatalf("viewers of renewed session are incorrect, got %d want %d", got, 0)
+	}
+	if old.Viewers() != 1 || renewed.Viewers() != 0 {
+		t.Fatalf("got %d viewers of old session and %d of renewed session, want 1 and 0", old.Viewers(), renewed.Viewers())
+	}
+
+	r.Register(Key{ID: "abb", TrackSource: pb.TrackSource_MONITOR}, newTestSession(t, &pb.Meta{Id: "abb", TrackSource: pb.TrackSource_MONITOR}))
+	sessions := r.List()
+	if len(sessions) != 2 || sessions[0].Meta.Id != "abb" || sessions[1] != renewed {
+		t.Fatalf("sessions are not listed in order: %v", sessions)
+	}
+
+	// Closing the replaced publisher keeps the session of the reconnected one.
+	if r.Unregister(key, old.Tracks) {
+		t.Fatal("replaced session is unregistered")
+	}
+	if s, ok := r.Load(key); !ok || s != renewed {
+		t.Fatal("session of reconnected publisher is removed")
+	}
+	if !r.Unregister(key, renewed.Tracks) {
+		t.Fatal("session is not unregistered")
+	}
+	if _, ok := r.Load(key); ok {
+		t.Fatal("unregistered session is loaded")
+	}
+	if viewers.DeleteLabelValues(meta.Id, meta.TrackSource.String(), meta.Stream) {
+		t.Fatal("viewers of unregistered session are still exported")
+	}
+}
@@ -0,0 +1,57 @@
+package subscriber
+
+import (
+	"net/http"
+
+	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+)
+
+// handleListSessions lists live sessions viewer is permitted to subscribe to.
+func (s *Subscriber) handleListSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grant, err := s.authorizer.Authorize(r)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusUnauthorized, httpx.ErrUnauthorized)
+			return
+		}
+		sessions := s.sessions.List()
+		infos := make([]session.Info, 0, len(sessions))
+		for _, sess := range sessions {
+			if grant.Allows(sess.Meta) {
+				infos = append(infos, sess.Info())
+			}
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, infos); err != nil {
+			s.logger.Err(err).Msg("could not write sessions")
+		}
+	}
+}
+
+// handleGetSession gets a live session by id, track source and optional stream query parameter, if viewer is permitted to subscribe to it.
+func (s *Subscriber) handleGetSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meta, err := httpx.MetaFromRequest(r)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusBadRequest, httpx.ErrIncorrectMetadata)
+			return
+		}
+		grant, err := s.authorizer.Authorize(r)
+		if err != nil {
+			_ = httpx.WriteJSONError(w, http.StatusUnauthorized, httpx.ErrUnauthorized)
+			return
+		}
+		if !grant.Allows(meta) {
+			_ = httpx.WriteJSONError(w, http.StatusForbidden, httpx.ErrForbidden)
+			return
+		}
+		sess, ok := s.sessions.Load(session.KeyFromMeta(meta))
+		if !ok {
+			_ = httpx.WriteJSONError(w, http.StatusNotFound, httpx.ErrMetadataNotMatched)
+			return
+		}
+		if err := httpx.WriteJSON(w, http.StatusOK, sess.Info()); err != nil {
+			s.logger.Err(err).Msg("could not write session")
+		}
+	}
+}
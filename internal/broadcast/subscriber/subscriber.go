@@ -15,8 +15,10 @@ import (
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
 
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
 	"github.com/SB-IM/charoite/internal/broadcast/cfg"
 	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
 	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
 )
 
@@ -26,11 +28,18 @@ type Subscriber struct {
 	config *cfg.SubscriberConfigOptions
 	logger zerolog.Logger
 
+	// authorizer authorizes viewers on webSocket upgrade and WHEP offer.
+	authorizer auth.Authorizer
+
 	// sessions must be created before used by publisher and is shared between publishers ans subscribers.
 	// It's only read by subscriber.
-	sessions *sync.Map
+	sessions *session.Registry
+
+	// resources are WHEP resources of subscribers.
+	resources sync.Map
 
-	counter map[string]int
+	// peers are all alive subscriber peers, they're closed on shutdown.
+	peers webrtcx.Peers
 }
 
 // incomingMessage is a generic WebSocket incoming message.
@@ -47,29 +56,60 @@ type outgoingMessage struct {
 	Data  interface{} `json:"data"`
 }
 
+// answer is data of "video-answer" event, it carries TURN servers with credentials issued to viewer if any.
+type answer struct {
+	*pb.SessionDescription
+	ICEServers []webrtc.ICEServer `json:"ice_servers,omitempty"`
+}
+
+// layerSelection is data of "select-layer" event and its "layer-selected" reply,
+// it selects video layer of a stream subscribed over the same webSocket connection.
+type layerSelection struct {
+	Meta *pb.Meta `json:"meta"`
+
+	// Auto selects layer by bandwidth estimate of subscriber, the other fields are ignored.
+	Auto bool `json:"auto,omitempty"`
+
+	// RID is RTP stream id of a simulcast layer, empty selects the first layer publisher offers.
+	RID string `json:"rid,omitempty"`
+
+	// SpatialLayer and TemporalLayer are the highest layers of VP9 SVC forwarded, nil forwards all of them.
+	SpatialLayer  *int `json:"spatial_layer,omitempty"`
+	TemporalLayer *int `json:"temporal_layer,omitempty"`
+}
+
 // New returns a new Subscriber.
 func New(
 	client mqtt.Client,
-	sessions *sync.Map,
+	sessions *session.Registry,
 	logger *zerolog.Logger,
 	config *cfg.SubscriberConfigOptions,
+	authorizer auth.Authorizer,
 ) *Subscriber {
 	l := logger.With().Str("component", "Subscriber").Logger()
 	return &Subscriber{
-		client:   client,
-		sessions: sessions,
-		config:   config,
-		logger:   l,
-		counter:  make(map[string]int),
+		client:     client,
+		sessions:   sessions,
+		config:     config,
+		logger:     l,
+		authorizer: authorizer,
 	}
 }
 
 // Signal performs webRTC signaling for all subscriber peers.
-func (s *Subscriber) Signal() http.Handler {
+func (s *Subscriber) Signal() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/broadcast/signal", s.handleSignal()) // WebRTC SDP signaling. candidates trickling
 	s.logger.Info().Msg("registered signal HTTP handler")
 
+	r.HandleFunc("/v1/broadcast/whep/{id}/{track_source}", s.handleWHEP()).Methods(http.MethodPost)
+	r.HandleFunc("/v1/broadcast/whep/{id}/{track_source}/{resource_id}", s.handleWHEPDelete()).Methods(http.MethodDelete)
+	s.logger.Info().Msg("registered WHEP HTTP handler")
+
+	r.HandleFunc("/v1/broadcast/sessions", s.handleListSessions()).Methods(http.MethodGet)
+	r.HandleFunc("/v1/broadcast/sessions/{id}/{track_source}", s.handleGetSession()).Methods(http.MethodGet)
+	s.logger.Info().Msg("registered sessions HTTP handler")
+
 	if s.config.EnableFrontend {
 		r.Handle("/v1/test/e2e/broadcast", http.StripPrefix("/v1/test/e2e/broadcast", http.FileServer(http.Dir("e2e/broadcast/static")))) // E2e static file server for debuging
 		s.logger.Debug().Str("address", "http://localhost:8080/v1/test/e2e/broadcast").Msg("registered broadcast e2e static file server handler")
@@ -79,10 +119,18 @@ func (s *Subscriber) Signal() http.Handler {
 
 // handleSignal handles subscriber with webSocket api.
 // Has candidate trickle support.
+// Viewer is authorized before upgrade, and every stream offered is checked against what it's granted.
 func (s *Subscriber) handleSignal() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		grant, err := s.authorizer.Authorize(r)
+		if err != nil {
+			s.logger.Err(err).Msg("could not authorize webSocket connection")
+			httpx.Error(w, httpx.ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+
 		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			OriginPatterns: []string{"*"}, // TODO: Must remove this option on production environment.
+			OriginPatterns: s.config.AllowedOrigins,
 		})
 		if err != nil {
 			s.logger.Err(err).Msg("could not upgrade to webSocket connection")
@@ -93,20 +141,29 @@ func (s *Subscriber) handleSignal() http.HandlerFunc {
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		s.processMessage(ctx, c)
+		s.processMessage(ctx, c, grant)
 	}
 }
 
-func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
-	candidateChan := map[pb.TrackSource]chan string{
-		pb.TrackSource_DRONE:   make(chan string, 2), // make buffer 2 because we send candidate at least twice.
-		pb.TrackSource_MONITOR: make(chan string, 2), // make buffer 2 because we send candidate at least twice.
+func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn, grant auth.Grant) {
+	// A subscriber may subscribe to many streams over the same webSocket connection,
+	// candidates are dispatched to peer connection of each stream.
+	candidateChans := make(map[session.Key]chan string)
+	candidateChan := func(key session.Key) chan string {
+		ch, ok := candidateChans[key]
+		if !ok {
+			ch = make(chan string, 2) // make buffer 2 because we send candidate at least twice.
+			candidateChans[key] = ch
+		}
+		return ch
 	}
 	defer func() {
-		for _, ch := range candidateChan {
+		for _, ch := range candidateChans {
 			close(ch)
 		}
 	}()
+	// Subscriber peers of streams are looked up by layer selection.
+	subscribers := make(map[session.Key]*webrtcx.WebRTC)
 
 	for {
 		var msg incomingMessage
@@ -134,11 +191,16 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 				_ = replyErr(ctx, c, msg.ID, nil, httpx.ErrIncorrectMetadata)
 				return
 			}
-			logger := s.logger.With().Str("event_id", msg.ID).Str("id", offer.Meta.Id).Int32("track_source", int32(offer.Meta.TrackSource)).Logger()
+			logger := s.logger.With().Str("event_id", msg.ID).Str("id", offer.Meta.Id).Int32("track_source", int32(offer.Meta.TrackSource)).Str("stream", offer.Meta.Stream).Logger()
 			logger.Info().Msg("received offer from subscriber")
 
-			sessionID := offer.Meta.Id + strconv.Itoa(int(offer.Meta.TrackSource))
-			value, ok := s.sessions.Load(sessionID)
+			if !grant.Allows(offer.Meta) {
+				logger.Warn().Msg("subscriber is not permitted to subscribe to stream")
+				_ = replyErr(ctx, c, msg.ID, offer.Meta, httpx.ErrForbidden)
+				continue
+			}
+
+			sess, ok := s.sessions.Load(session.KeyFromMeta(offer.Meta))
 			if !ok {
 				logger.Error().Msg("no machine id or track source found in existing sessions")
 				_ = replyErr(ctx, c, msg.ID, offer.Meta, httpx.ErrMetadataNotMatched)
@@ -149,9 +211,9 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 				s.config.WebRTCConfigOptions,
 				&logger,
 				sendCandidate(ctx, c, offer.Meta),
-				recvCandidate(candidateChan[offer.Meta.TrackSource]),
+				recvCandidate(candidateChan(session.KeyFromMeta(offer.Meta))),
 				webrtcx.NoopRegisterSessionFunc,
-				s.updateCounter(offer.Meta),
+				s.UpdateCounter(sess),
 			)
 
 			var sdp webrtc.SessionDescription
@@ -162,16 +224,18 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 			}
 			// TODO: handle blocking case with timeout for channels.
 			wcx.SignalChan <- &sdp
-			if err := wcx.CreateSubscriber(value.(*webrtc.TrackLocalStaticRTP)); err != nil {
+			if err := wcx.CreateSubscriber(sess.Tracks); err != nil {
 				logger.Err(err).Msg("failed to create subscriber")
 				_ = replyErr(ctx, c, msg.ID, offer.Meta, httpx.ErrFailedToCreateSubscriber)
 				return
 			}
+			s.peers.Add(wcx)
+			subscribers[session.KeyFromMeta(offer.Meta)] = wcx
 			logger.Info().Msg("successfully created subscriber")
 
 			// TODO: Timeout channel receiving to avoid blocking.
-			answer := <-wcx.SignalChan
-			b, err := json.Marshal(answer)
+			sdpAnswer := <-wcx.SignalChan
+			b, err := json.Marshal(sdpAnswer)
 			if err != nil {
 				s.logger.Err(err).Msg("could not unmarshal answer to JSON")
 				_ = replyErr(ctx, c, msg.ID, offer.Meta, httpx.ErrUnmarshalJSON)
@@ -179,9 +243,12 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 			}
 			if err := wsjson.Write(ctx, c, &outgoingMessage{
 				Event: "video-answer",
-				Data: &pb.SessionDescription{
-					Meta: offer.Meta,
-					Sdp:  string(b),
+				Data: &answer{
+					SessionDescription: &pb.SessionDescription{
+						Meta: offer.Meta,
+						Sdp:  string(b),
+					},
+					ICEServers: s.iceServers(grant),
 				},
 			}); err != nil {
 				s.logger.Err(err).Msg("could not write answer JSON")
@@ -200,9 +267,13 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 				_ = replyErr(ctx, c, msg.ID, nil, httpx.ErrIncorrectMetadata)
 				return
 			}
-			sessionID := candidate.Meta.Id + strconv.Itoa(int(candidate.Meta.TrackSource))
-			_, ok := s.sessions.Load(sessionID)
-			if !ok {
+			if !grant.Allows(candidate.Meta) {
+				s.logger.Warn().Msg("subscriber is not permitted to subscribe to stream")
+				_ = replyErr(ctx, c, msg.ID, candidate.Meta, httpx.ErrForbidden)
+				continue
+			}
+			key := session.KeyFromMeta(candidate.Meta)
+			if _, ok := s.sessions.Load(key); !ok {
 				s.logger.Error().Msg("no machine id or track source found in existing sessions")
 				_ = replyErr(ctx, c, msg.ID, candidate.Meta, httpx.ErrMetadataNotMatched)
 				return
@@ -214,10 +285,42 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 				_ = replyErr(ctx, c, msg.ID, candidate.Meta, httpx.ErrUnmarshalJSON)
 				return
 			}
-			if candidate.Meta.TrackSource == pb.TrackSource_DRONE {
-				candidateChan[pb.TrackSource_DRONE] <- candidateInit.Candidate
-			} else {
-				candidateChan[pb.TrackSource_MONITOR] <- candidateInit.Candidate
+			candidateChan(key) <- candidateInit.Candidate
+		case "select-layer":
+			var selection layerSelection
+			if err := json.Unmarshal(msg.Data, &selection); err != nil {
+				s.logger.Err(err).Msg("could not unmarshal JSON data")
+				_ = replyErr(ctx, c, msg.ID, nil, httpx.ErrUnmarshalJSON)
+				return
+			}
+			if selection.Meta == nil || selection.Meta.Id == "" {
+				s.logger.Error().Msg("incorrect metadata")
+				_ = replyErr(ctx, c, msg.ID, nil, httpx.ErrIncorrectMetadata)
+				return
+			}
+			wcx, ok := subscribers[session.KeyFromMeta(selection.Meta)]
+			if !ok {
+				s.logger.Error().Msg("no stream subscribed for metadata")
+				_ = replyErr(ctx, c, msg.ID, selection.Meta, httpx.ErrMetadataNotMatched)
+				continue
+			}
+			if err := wcx.SelectLayer(selection.layer()); err != nil {
+				s.logger.Err(err).Str("rid", selection.RID).Msg("could not select layer")
+				_ = replyErr(ctx, c, msg.ID, selection.Meta, httpx.ErrUnknownLayer)
+				continue
+			}
+			layer, auto, err := wcx.SelectedLayer()
+			if err != nil {
+				s.logger.Err(err).Msg("could not get selected layer")
+				continue
+			}
+			if err := wsjson.Write(ctx, c, &outgoingMessage{
+				Event: "layer-selected",
+				ID:    msg.ID,
+				Data:  newLayerSelection(selection.Meta, layer, auto),
+			}); err != nil {
+				s.logger.Err(err).Msg("could not write layer selected JSON")
+				return
 			}
 		default:
 			s.logger.Warn().Str("event", msg.Event).Msg("unknown event")
@@ -225,8 +328,40 @@ func (s *Subscriber) processMessage(ctx context.Context, c *websocket.Conn) {
 	}
 }
 
+// layer returns layer selected, it's nil if layer is selected by bandwidth estimate.
+func (l *layerSelection) layer() *webrtcx.Layer {
+	if l.Auto {
+		return nil
+	}
+	layer := &webrtcx.Layer{RID: l.RID, SpatialLayer: webrtcx.AllLayers, TemporalLayer: webrtcx.AllLayers}
+	if l.SpatialLayer != nil {
+		layer.SpatialLayer = *l.SpatialLayer
+	}
+	if l.TemporalLayer != nil {
+		layer.TemporalLayer = *l.TemporalLayer
+	}
+	return layer
+}
+
+// newLayerSelection returns layer selection of a stream.
+func newLayerSelection(meta *pb.Meta, layer webrtcx.Layer, auto bool) *layerSelection {
+	selection := &layerSelection{Meta: meta, Auto: auto, RID: layer.RID}
+	if layer.SpatialLayer != webrtcx.AllLayers {
+		selection.SpatialLayer = &layer.SpatialLayer
+	}
+	if layer.TemporalLayer != webrtcx.AllLayers {
+		selection.TemporalLayer = &layer.TemporalLayer
+	}
+	return selection
+}
+
+// Close closes all subscriber peers.
+func (s *Subscriber) Close() error {
+	return s.peers.Close()
+}
+
 func (s *Subscriber) notifySubscriptions(meta *pb.Meta, subscriptions int) {
-	topic := s.config.NotifyStreamTopicPrefix + "/" + meta.Id + "/" + strconv.Itoa(int(meta.TrackSource))
+	topic := s.config.NotifyStreamTopicPrefix + pb.TopicSuffix(meta)
 	t := s.client.Publish(topic, byte(s.config.Qos), s.config.Retained, strconv.Itoa(subscriptions))
 	go func() {
 		<-t.Done()
@@ -238,21 +373,11 @@ func (s *Subscriber) notifySubscriptions(meta *pb.Meta, subscriptions int) {
 	}()
 }
 
-func (s *Subscriber) updateCounter(meta *pb.Meta) webrtcx.UpdateCounterFunc {
+// UpdateCounter returns a function updating viewers of sess, the session subscriber joined, and notifying edge of it,
+// edge consuming stream on demand starts streaming only if there is any viewer.
+func (s *Subscriber) UpdateCounter(sess *session.Session) webrtcx.UpdateCounterFunc {
 	return func(n int) {
-		key := meta.Id + "/" + strconv.Itoa(int(meta.TrackSource))
-
-		var val int
-
-		v, ok := s.counter[key]
-		if ok {
-			val = v + n
-		} else {
-			val = n
-		}
-
-		s.counter[key] = val
-		s.notifySubscriptions(meta, val)
+		s.notifySubscriptions(sess.Meta, int(s.sessions.AddViewers(sess, int64(n))))
 	}
 }
 
@@ -0,0 +1,153 @@
+package subscriber
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
+	"github.com/SB-IM/charoite/internal/broadcast/cfg"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+// notifyClient records payloads of stream notification.
+type notifyClient struct {
+	mqtt.Client
+	payloads chan interface{}
+}
+
+func (c *notifyClient) Publish(_ string, _ byte, _ bool, payload interface{}) mqtt.Token {
+	c.payloads <- payload
+	return &mqtt.DummyToken{}
+}
+
+func TestUpdateCounter(t *testing.T) {
+	logger := zerolog.Nop()
+	sessions := session.NewRegistry()
+	client := &notifyClient{payloads: make(chan interface{}, 3)}
+	s := New(client, sessions, &logger, &cfg.SubscriberConfigOptions{}, auth.AllowAll)
+
+	videoTrack := webrtcx.CreateLocalTrack(webrtc.MimeTypeH264, nil)
+	meta := &pb.Meta{Id: "abc", TrackSource: pb.TrackSource_DRONE}
+	sess := session.New(meta, &webrtcx.LocalTracks{Video: videoTrack})
+	sessions.Register(session.KeyFromMeta(meta), sess)
+
+	counter := s.UpdateCounter(sess)
+	counter(1)
+	counter(1)
+	if got := sess.Viewers(); got != 2 {
+		t.Fatalf("viewers are incorrect, got %d want %d", got, 2)
+	}
+
+	// A viewer of the old session leaves after publisher reconnects, edge is notified of viewers of the new session.
+	renewed := session.New(meta, &webrtcx.LocalTracks{Video: videoTrack})
+	sessions.Register(session.KeyFromMeta(meta), renewed)
+	counter(-1)
+	if sess.Viewers() != 1 || renewed.Viewers() != 0 {
+		t.Fatalf("got %d viewers of old session and %d of new session, want 1 and 0", sess.Viewers(), renewed.Viewers())
+	}
+	for _, want := range []string{"1", "2", "0"} {
+		if got := <-client.payloads; got != want {
+			t.Fatalf("notified subscriptions are incorrect, got %v want %s", got, want)
+		}
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	logger := zerolog.Nop()
+	s := New(nil, session.NewRegistry(), &logger, &cfg.SubscriberConfigOptions{}, auth.AllowAll)
+	anonymous, err := auth.AllowAll.Authorize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers := s.iceServers(anonymous); servers != nil {
+		t.Fatalf("got ICE servers %v without TURN URLs", servers)
+	}
+
+	s.config.TURNCredentialConfigOptions = cfg.TURNCredentialConfigOptions{
+		URLs:         []string{"turn:turn.example.com:3478"},
+		SharedSecret: "secret",
+		TTL:          time.Hour,
+	}
+	claims := &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}}
+	for _, tc := range []struct {
+		grant auth.Grant
+		user  string
+	}{
+		{anonymous, anonymousViewer},
+		{claims, "alice"},
+	} {
+		servers := s.iceServers(tc.grant)
+		if len(servers) != 1 || len(servers[0].URLs) != 1 {
+			t.Fatalf("got ICE servers %v, want one TURN server", servers)
+		}
+		expiry, user, _ := strings.Cut(servers[0].Username, ":")
+		if user != tc.user {
+			t.Errorf("got user %q, want %q", user, tc.user)
+		}
+		if ts, err := strconv.ParseInt(expiry, 10, 64); err != nil || time.Until(time.Unix(ts, 0)) <= 59*time.Minute {
+			t.Errorf("got expiry %q of username, want an hour later", expiry)
+		}
+		if servers[0].Credential == "" {
+			t.Error("empty credential")
+		}
+	}
+}
+
+// grantAuthorizer grants every request the same grant, or none if grant is nil.
+type grantAuthorizer struct {
+	grant auth.Grant
+}
+
+func (a grantAuthorizer) Authorize(_ *http.Request) (auth.Grant, error) {
+	if a.grant == nil {
+		return nil, auth.ErrNoToken
+	}
+	return a.grant, nil
+}
+
+func TestSessionsAuthorized(t *testing.T) {
+	logger := zerolog.Nop()
+	sessions := session.NewRegistry()
+	for _, id := range []string{"abc", "abd"} {
+		videoTrack := webrtcx.CreateLocalTrack(webrtc.MimeTypeH264, nil)
+		meta := &pb.Meta{Id: id, TrackSource: pb.TrackSource_DRONE}
+		sessions.Register(session.KeyFromMeta(meta), session.New(meta, &webrtcx.LocalTracks{Video: videoTrack}))
+	}
+	serve := func(authorizer auth.Authorizer, target string) *httptest.ResponseRecorder {
+		s := New(nil, sessions, &logger, &cfg.SubscriberConfigOptions{}, authorizer)
+		w := httptest.NewRecorder()
+		s.Signal().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	if w := serve(grantAuthorizer{}, "/v1/broadcast/sessions"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d without token", w.Code)
+	}
+	claims := grantAuthorizer{&auth.Claims{Streams: []auth.StreamClaim{{ID: "abc"}}}}
+	w := serve(claims, "/v1/broadcast/sessions")
+	var infos []session.Info
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != "abc" {
+		t.Fatalf("got sessions %+v, want only permitted session", infos)
+	}
+	if w := serve(claims, "/v1/broadcast/sessions/abd/1"); w.Code != http.StatusForbidden {
+		t.Fatalf("got status %d of session not permitted", w.Code)
+	}
+	if w := serve(claims, "/v1/broadcast/sessions/abc/1"); w.Code != http.StatusOK {
+		t.Fatalf("got status %d of session permitted", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package subscriber
+
+import (
+	"github.com/pion/webrtc/v3"
+
+	"github.com/SB-IM/charoite/internal/broadcast/auth"
+	"github.com/SB-IM/charoite/internal/turn"
+)
+
+// anonymousViewer is the user of TURN credentials issued to viewers without subject in their tokens.
+const anonymousViewer = "viewer"
+
+// iceServers returns TURN servers with time-limited credentials issued to viewer, nil if they're not configured.
+// The user of credentials is subject of viewer token, so that relayed traffic is accounted to the viewer.
+func (s *Subscriber) iceServers(grant auth.Grant) []webrtc.ICEServer {
+	options := &s.config.TURNCredentialConfigOptions
+	if len(options.URLs) == 0 {
+		return nil
+	}
+	user := anonymousViewer
+	if claims, ok := grant.(*auth.Claims); ok && claims.Subject != "" {
+		user = claims.Subject
+	}
+	username, password := turn.RESTCredentials(options.SharedSecret, user, options.TTL)
+	return []webrtc.ICEServer{{
+		URLs:           options.URLs,
+		Username:       username,
+		Credential:     password,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	}}
+}
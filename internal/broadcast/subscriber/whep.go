@@ -0,0 +1,109 @@
+package subscriber
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/SB-IM/charoite/internal/broadcast/httpx"
+	"github.com/SB-IM/charoite/internal/broadcast/session"
+	webrtcx "github.com/SB-IM/charoite/internal/broadcast/webrtc"
+)
+
+// handleWHEP handles WHEP egress of a session.
+// See: https://datatracker.ietf.org/doc/draft-murillo-whep/
+// Candidates are not trickled, answer carries all gathered candidates.
+func (s *Subscriber) handleWHEP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meta, err := httpx.MetaFromRequest(r)
+		if err != nil {
+			s.logger.Err(err).Msg("incorrect metadata")
+			httpx.Error(w, httpx.ErrIncorrectMetadata, http.StatusBadRequest)
+			return
+		}
+		logger := s.logger.With().Str("id", meta.Id).Int32("track_source", int32(meta.TrackSource)).Str("stream", meta.Stream).Logger()
+
+		grant, err := s.authorizer.Authorize(r)
+		if err != nil {
+			logger.Err(err).Msg("could not authorize WHEP request")
+			httpx.Error(w, httpx.ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+		if !grant.Allows(meta) {
+			logger.Warn().Msg("subscriber is not permitted to subscribe to stream")
+			httpx.Error(w, httpx.ErrForbidden, http.StatusForbidden)
+			return
+		}
+
+		offer, err := httpx.ReadOffer(r)
+		if err != nil {
+			logger.Err(err).Msg("could not read offer")
+			if errors.Is(err, httpx.ErrContentType) {
+				httpx.Error(w, httpx.ErrUnsupportedMediaType, http.StatusUnsupportedMediaType)
+			} else {
+				httpx.Error(w, httpx.ErrReadMessage, http.StatusBadRequest)
+			}
+			return
+		}
+		logger.Info().Msg("received WHEP offer from subscriber")
+
+		sess, ok := s.sessions.Load(session.KeyFromMeta(meta))
+		if !ok {
+			logger.Error().Msg("no machine id or track source found in existing sessions")
+			httpx.Error(w, httpx.ErrMetadataNotMatched, http.StatusNotFound)
+			return
+		}
+
+		wcx := webrtcx.New(
+			s.config.WebRTCConfigOptions,
+			&logger,
+			webrtcx.NoopSendCandidateFunc,
+			webrtcx.NoopRecvCandidateFunc,
+			webrtcx.NoopRegisterSessionFunc,
+			s.UpdateCounter(sess),
+		)
+		wcx.NonTrickle = true
+
+		wcx.SignalChan <- offer
+		if err := wcx.CreateSubscriber(sess.Tracks); err != nil {
+			logger.Err(err).Msg("failed to create subscriber")
+			httpx.Error(w, httpx.ErrFailedToCreateSubscriber, http.StatusInternalServerError)
+			return
+		}
+		s.peers.Add(wcx)
+		answer := <-wcx.SignalChan
+
+		resourceID := uuid.NewString()
+		s.resources.Store(resourceID, wcx)
+		go func() {
+			<-wcx.Done()
+			s.resources.Delete(resourceID)
+		}()
+		httpx.SetICEServerLinks(w.Header(), s.iceServers(grant))
+		if err := httpx.WriteAnswer(w, path.Join(r.URL.Path, resourceID), answer); err != nil {
+			logger.Err(err).Msg("could not write answer")
+			return
+		}
+		logger.Info().Str("resource_id", resourceID).Msg("sent WHEP answer to subscriber")
+	}
+}
+
+// handleWHEPDelete tears down a WHEP resource.
+func (s *Subscriber) handleWHEPDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resourceID := mux.Vars(r)["resource_id"]
+		value, ok := s.resources.LoadAndDelete(resourceID)
+		if !ok {
+			httpx.Error(w, httpx.ErrResourceNotFound, http.StatusNotFound)
+			return
+		}
+		if err := value.(*webrtcx.WebRTC).Close(); err != nil {
+			s.logger.Err(err).Str("resource_id", resourceID).Msg("could not close peer connection")
+		}
+		s.logger.Info().Str("resource_id", resourceID).Msg("deleted WHEP resource")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,79 @@
+package broadcast
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/SB-IM/charoite/internal/turn"
+)
+
+// embeddedTURNUser is the static user of embedded TURN server that peers of broadcast relay through,
+// if static user is not configured.
+const embeddedTURNUser = "broadcast"
+
+// embedTURN configures embedded TURN server and advertises it as ICE server instead of webrtc.ice_server:
+// peers of broadcast relay through it as the static user, viewers and WHIP publishers get time-limited credentials of it,
+// unless URLs of TURN credentials are configured to other TURN servers.
+// Static user and shared secret are generated if they're not configured, they're only known to this process.
+// Public IP must be reachable by peers, so an empty or loopback address is rejected.
+func (s *Service) embedTURN() error {
+	options := &s.config.EmbeddedTURNConfigOptions.TURN
+	if options.PublicIP == "" {
+		return errors.New("public IP of embedded TURN server is required")
+	}
+	if ip := net.ParseIP(options.PublicIP); ip != nil && ip.IsLoopback() {
+		return fmt.Errorf("public IP of embedded TURN server is loopback address %s, peers can't reach it", options.PublicIP)
+	}
+	options.ShutdownTimeout = s.config.ShutdownTimeout
+	if options.Username == "" {
+		password, err := randomSecret()
+		if err != nil {
+			return err
+		}
+		options.Username, options.Password = embeddedTURNUser, password
+	}
+
+	host := net.JoinHostPort(options.PublicIP, strconv.Itoa(options.Port))
+	s.config.WebRTCConfigOptions.ICEServer = "turn:" + host
+	s.config.WebRTCConfigOptions.Username = options.Username
+	s.config.WebRTCConfigOptions.Credential = options.Password
+
+	credentials := &s.config.TURNCredentialConfigOptions
+	if len(credentials.URLs) != 0 {
+		return nil
+	}
+	credentials.URLs = []string{"turn:" + host + "?transport=udp"}
+	if options.TCP {
+		credentials.URLs = append(credentials.URLs, "turn:"+host+"?transport=tcp")
+	}
+	if options.TLSPort != 0 {
+		credentials.URLs = append(credentials.URLs, "turns:"+net.JoinHostPort(options.PublicIP, strconv.Itoa(options.TLSPort))+"?transport=tcp")
+	}
+	if options.SharedSecret == "" {
+		secret, err := randomSecret()
+		if err != nil {
+			return err
+		}
+		options.SharedSecret = secret
+	}
+	credentials.SharedSecret = options.SharedSecret
+	return nil
+}
+
+// serveTURN serves embedded TURN server until service is shut down, its error is sent to errChan.
+func (s *Service) serveTURN(errChan chan<- error) {
+	logger := s.logger.With().Str("component", "TURN").Logger()
+	errChan <- turn.Serve(s.ctx, &logger, &s.config.EmbeddedTURNConfigOptions.TURN)
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate TURN secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
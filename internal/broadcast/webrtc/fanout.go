@@ -0,0 +1,569 @@
+package webrtc
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/randutil"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// subscriberQueueSize is number of packets queued for a subscriber, it's about a second of 5 Mbps video.
+	subscriberQueueSize = 512
+
+	// bitrateWindow is the period bitrate of a track is measured over.
+	bitrateWindow = time.Second
+
+	// videoClockRate is RTP clock rate of all video codecs.
+	videoClockRate = 90000
+)
+
+// Fanout is a local track of a session, it forwards RTP packets of publisher to subscribers.
+// Every subscriber is bound to its own local track with its own queue, SSRC, sequence numbers and timestamps,
+// so a slow or lossy subscriber drops its own packets rather than holding up publisher and other subscribers.
+// A simulcast publisher sends a Fanout per layer, and subscribers may switch among them.
+type Fanout struct {
+	codec    webrtc.RTPCodecCapability
+	id       string
+	streamID string
+	rid      string // RTP stream id of a simulcast layer, it's empty if publisher doesn't send simulcast.
+
+	// gop caches video from the last key frame on for new subscribers, it's nil for audio or unknown video codec.
+	gop *gopCache
+
+	// keyFrameRequests coalesces key frame requests of viewers, publisher sends one PLI for all pending requests.
+	keyFrameRequests chan struct{}
+
+	// bitrate is measured by forwarding loop over bitrateWindow.
+	bitrate     atomic.Int64
+	windowStart time.Time
+	windowBytes int
+
+	dropped     prometheus.Counter
+	writeErrors prometheus.Counter
+
+	// writers is replaced on change rather than modified, so that it can be iterated without lock.
+	mu      sync.RWMutex
+	writers []*subscriberWriter
+}
+
+// newFanout returns a Fanout of codec, its metrics are labeled by labels.
+func newFanout(codec webrtc.RTPCodecCapability, id, streamID string, labels prometheus.Labels) *Fanout {
+	f := &Fanout{
+		codec:            codec,
+		id:               id,
+		streamID:         streamID,
+		keyFrameRequests: make(chan struct{}, 1),
+		dropped:          subscriberPacketsDropped.With(labels),
+		writeErrors:      rtpWriteErrors.With(labels),
+	}
+	if f.Kind() == webrtc.RTPCodecTypeVideo {
+		f.gop = newGOPCache(codec.MimeType)
+	}
+	return f
+}
+
+// Codec returns codec of the track.
+func (f *Fanout) Codec() webrtc.RTPCodecCapability {
+	return f.codec
+}
+
+// ID returns id of the track.
+func (f *Fanout) ID() string {
+	return f.id
+}
+
+// StreamID returns stream id of the track, audio and video of a session share it.
+func (f *Fanout) StreamID() string {
+	return f.streamID
+}
+
+// RID returns RTP stream id of a simulcast layer, it's empty if publisher doesn't send simulcast.
+func (f *Fanout) RID() string {
+	return f.rid
+}
+
+// Kind returns kind of the track by its MIME type.
+func (f *Fanout) Kind() webrtc.RTPCodecType {
+	switch {
+	case strings.HasPrefix(f.codec.MimeType, "audio/"):
+		return webrtc.RTPCodecTypeAudio
+	case strings.HasPrefix(f.codec.MimeType, "video/"):
+		return webrtc.RTPCodecTypeVideo
+	default:
+		return webrtc.RTPCodecType(0)
+	}
+}
+
+// Subscribers returns number of subscribers receiving packets.
+func (f *Fanout) Subscribers() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.writers)
+}
+
+// Bitrate returns bitrate of publisher in bits per second, it's zero if publisher has paused the track.
+func (f *Fanout) Bitrate() int {
+	return int(f.bitrate.Load())
+}
+
+// RequestKeyFrame asks publisher for a key frame without blocking.
+// Requests are coalesced and rate limited, so it's cheap to call for every viewer.
+func (f *Fanout) RequestKeyFrame() {
+	select {
+	case f.keyFrameRequests <- struct{}{}:
+	default:
+	}
+}
+
+// WriteRTP forwards a packet of publisher to subscribers without blocking.
+// The packet is shared by subscribers and GOP cache, so it must not be modified afterwards.
+// It's called by forwarding loop only.
+func (f *Fanout) WriteRTP(p *rtp.Packet) {
+	f.measure(p, time.Now())
+	if f.gop != nil {
+		f.gop.push(p)
+	}
+	f.mu.RLock()
+	writers := f.writers
+	f.mu.RUnlock()
+	for _, w := range writers {
+		w.enqueue(f, p)
+	}
+}
+
+// measure counts a packet into bitrate, it's called by forwarding loop only.
+func (f *Fanout) measure(p *rtp.Packet, now time.Time) {
+	f.windowBytes += len(p.Payload)
+	elapsed := now.Sub(f.windowStart)
+	if elapsed < bitrateWindow {
+		return
+	}
+	if elapsed < 2*bitrateWindow {
+		f.bitrate.Store(int64(f.windowBytes) * 8 * int64(time.Second) / int64(elapsed))
+	} else {
+		// Publisher has paused the track, or it's the first packet.
+		f.bitrate.Store(0)
+	}
+	f.windowStart = now
+	f.windowBytes = 0
+}
+
+func (f *Fanout) add(w *subscriberWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writers = append(f.writers[:len(f.writers):len(f.writers)], w)
+}
+
+func (f *Fanout) remove(w *subscriberWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	writers := make([]*subscriberWriter, 0, len(f.writers))
+	for _, v := range f.writers {
+		if v != w {
+			writers = append(writers, v)
+		}
+	}
+	f.writers = writers
+}
+
+// newSubscriberTrack returns a local track to add to a subscriber peer connection.
+// Subscriber receives packets of the first layer from the track is bound until it's unbound,
+// and it may switch to the other layers of the same kind later.
+func newSubscriberTrack(logger *zerolog.Logger, layers ...*Fanout) (*subscriberTrack, error) {
+	f := layers[0]
+	track, err := webrtc.NewTrackLocalStaticRTP(f.codec, f.id, f.streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &subscriberTrack{
+		TrackLocalStaticRTP: track,
+		layers:              layers,
+		logger:              *logger,
+		source:              f,
+		auto:                len(layers) > 1,
+		spatialLayer:        AllLayers,
+		temporalLayer:       AllLayers,
+	}, nil
+}
+
+// subscriberTrack is a local track of a single subscriber peer connection.
+// Its writer is added to fanout of selected layer on bind and removed on unbind.
+type subscriberTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	layers []*Fanout
+	logger zerolog.Logger
+
+	mu            sync.Mutex
+	source        *Fanout // Selected layer.
+	auto          bool    // Layer is selected by bandwidth estimate.
+	spatialLayer  int
+	temporalLayer int
+	selector      layerSelector
+	writer        *subscriberWriter
+}
+
+// Bind starts forwarding packets to subscriber after negotiation is complete.
+func (t *subscriberTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.TrackLocalStaticRTP.Bind(ctx)
+	if err != nil {
+		return codec, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.writer == nil {
+		t.writer = newSubscriberWriter(t.source, t.TrackLocalStaticRTP, &t.logger)
+		t.writer.setSVCLayers(t.spatialLayer, t.temporalLayer)
+		go t.writer.run()
+		t.source.add(t.writer)
+	}
+	return codec, nil
+}
+
+// Unbind stops forwarding packets to subscriber.
+func (t *subscriberTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	err := t.TrackLocalStaticRTP.Unbind(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.writer != nil {
+		t.writer.detach()
+		t.writer.close()
+		t.writer = nil
+	}
+	return err
+}
+
+// selectLayer selects a layer manually, it switches at the next key frame of the layer.
+func (t *subscriberTrack) selectLayer(layer Layer) error {
+	if layer.SpatialLayer < AllLayers || layer.TemporalLayer < AllLayers {
+		return ErrUnknownLayer
+	}
+	var source *Fanout
+	for _, f := range t.layers {
+		if f.rid == layer.RID || (layer.RID == "" && f == t.layers[0]) {
+			source = f
+			break
+		}
+	}
+	if source == nil {
+		return ErrUnknownLayer
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.auto = false
+	t.switchTo(source)
+	if layer.SpatialLayer != t.spatialLayer || layer.TemporalLayer != t.temporalLayer {
+		t.spatialLayer, t.temporalLayer = layer.SpatialLayer, layer.TemporalLayer
+		if t.writer != nil {
+			t.writer.setSVCLayers(t.spatialLayer, t.temporalLayer)
+		}
+		// Decoder needs a key frame to decode layers dropped before.
+		source.RequestKeyFrame()
+	}
+	return nil
+}
+
+// selectAuto lets layer be selected by bandwidth estimate, SVC layers are no longer filtered.
+func (t *subscriberTrack) selectAuto() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.auto = true
+	if t.spatialLayer != AllLayers || t.temporalLayer != AllLayers {
+		t.spatialLayer, t.temporalLayer = AllLayers, AllLayers
+		if t.writer != nil {
+			t.writer.setSVCLayers(AllLayers, AllLayers)
+		}
+		t.source.RequestKeyFrame()
+	}
+}
+
+// adapt switches layer by bandwidth estimate in bits per second if layer is selected automatically.
+func (t *subscriberTrack) adapt(estimate int, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.auto {
+		return
+	}
+	if source := t.selector.choose(t.layers, t.source, estimate, now); source != t.source {
+		t.logger.Debug().Str("from", t.source.rid).Str("to", source.rid).Int("estimate", estimate).Msg("switching layer")
+		t.switchTo(source)
+	}
+}
+
+// selectedLayer returns selected layer and whether it's selected automatically.
+func (t *subscriberTrack) selectedLayer() (Layer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Layer{RID: t.source.rid, SpatialLayer: t.spatialLayer, TemporalLayer: t.temporalLayer}, t.auto
+}
+
+// requestKeyFrame asks publisher of selected layer for a key frame.
+func (t *subscriberTrack) requestKeyFrame() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source.RequestKeyFrame()
+}
+
+func (t *subscriberTrack) switchTo(source *Fanout) {
+	t.source = source
+	if t.writer != nil {
+		t.writer.switchTo(source)
+	}
+}
+
+// queuedPacket is a packet queued for a subscriber.
+type queuedPacket struct {
+	*rtp.Packet
+
+	// replay is cached GOP written before the packet, it's only set for the first packet.
+	replay []*rtp.Packet
+
+	// resync is set if packets are dropped before it, its sequence number follows the last written one.
+	resync bool
+
+	// switched is set on the first packet of a layer switched to, its timestamp follows the last written one as well.
+	switched bool
+}
+
+// subscriberWriter writes packets queued by fanout to a subscriber track.
+// Sequence numbers and timestamps are shifted by random offsets of the subscriber,
+// and sequence numbers are continued over packets dropped by the writer itself.
+// When queue is full, audio packets are dropped, while video is dropped until the next key frame
+// as frames depending on dropped ones can't be decoded.
+// When switching layer, the writer keeps forwarding the old layer until a key frame of the new one.
+type subscriberWriter struct {
+	track       *webrtc.TrackLocalStaticRTP
+	svc         bool // Codec is VP9 whose spatial and temporal layers may be filtered.
+	logger      zerolog.Logger
+	writeErrors prometheus.Counter
+
+	queue chan queuedPacket
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+
+	// SVC layers forwarded, they're set by subscriber track and read by run loop.
+	spatialLayer  atomic.Int32
+	temporalLayer atomic.Int32
+
+	// Following fields are accessed by enqueue and switchTo,
+	// enqueue is called by forwarding loops of both layers while switching.
+	mu       sync.Mutex
+	source   *Fanout
+	target   *Fanout // Layer to switch to at its next key frame, it's nil if not switching.
+	started  bool
+	dropping bool // Video is dropped until the next key frame.
+	resync   bool
+
+	// Following fields are only accessed by run loop.
+	seqOffset     uint16
+	tsOffset      uint32
+	lastSeq       uint16
+	lastTimestamp uint32
+	lastWrittenAt time.Time
+	written       bool
+}
+
+func newSubscriberWriter(f *Fanout, track *webrtc.TrackLocalStaticRTP, logger *zerolog.Logger) *subscriberWriter {
+	r := randutil.NewMathRandomGenerator()
+	w := &subscriberWriter{
+		track:       track,
+		svc:         strings.EqualFold(f.codec.MimeType, webrtc.MimeTypeVP9),
+		logger:      *logger,
+		writeErrors: f.writeErrors,
+		queue:       make(chan queuedPacket, subscriberQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		source:      f,
+		seqOffset:   uint16(r.Uint32()),
+		tsOffset:    r.Uint32(),
+	}
+	w.setSVCLayers(AllLayers, AllLayers)
+	return w
+}
+
+// setSVCLayers sets the highest spatial and temporal layers forwarded.
+func (w *subscriberWriter) setSVCLayers(spatial, temporal int) {
+	w.spatialLayer.Store(int32(spatial))
+	w.temporalLayer.Store(int32(temporal))
+}
+
+// switchTo switches writer to layer f at its next key frame.
+func (w *subscriberWriter) switchTo(f *Fanout) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.target != nil {
+		w.target.remove(w)
+		w.target = nil
+	}
+	if f == w.source {
+		return
+	}
+	w.target = f
+	f.add(w)
+	f.RequestKeyFrame()
+}
+
+// detach removes writer from layers it's added to.
+func (w *subscriberWriter) detach() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.source.remove(w)
+	if w.target != nil {
+		w.target.remove(w)
+		w.target = nil
+	}
+}
+
+// enqueue queues a packet of layer f without blocking, it's called by forwarding loops only.
+func (w *subscriberWriter) enqueue(f *Fanout, p *rtp.Packet) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	item := queuedPacket{Packet: p}
+	switch f {
+	case w.source:
+	case w.target:
+		if f.gop != nil && !f.gop.keyFrameStart(p.Payload) {
+			return
+		}
+		w.source.remove(w)
+		w.source, w.target = f, nil
+		w.started = true
+		w.dropping = false
+		item.switched = true
+		if f.gop != nil {
+			// Parameter sets sent earlier are cached right before key frame.
+			item.replay = f.gop.before(p.SequenceNumber)
+		}
+	default:
+		// Layer switched from, it may be still forwarding packets.
+		return
+	}
+
+	gop := w.source.gop
+	if !w.started {
+		w.started = true
+		if gop != nil {
+			item.replay = gop.before(p.SequenceNumber)
+			if len(item.replay) == 0 && !gop.keyFrameStart(p.Payload) {
+				// Nothing cached yet, subscriber starts at the next key frame.
+				w.dropping = true
+				w.source.RequestKeyFrame()
+			}
+		}
+	}
+	if w.dropping {
+		if !gop.keyFrameStart(p.Payload) {
+			return
+		}
+		w.dropping = false
+		// Parameter sets sent earlier are cached right before key frame.
+		item.replay = gop.before(p.SequenceNumber)
+	}
+
+	item.resync = w.resync
+	select {
+	case w.queue <- item:
+		w.resync = false
+	default:
+		w.source.dropped.Inc()
+		w.resync = true
+		if gop != nil {
+			w.dropping = true
+			w.source.RequestKeyFrame()
+		}
+	}
+}
+
+// run writes queued packets until writer is closed.
+func (w *subscriberWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case item := <-w.queue:
+			w.writeItem(item)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// writeItem writes a queued packet after its replay, sequence numbers and timestamps are resynchronized
+// by the first packet written.
+func (w *subscriberWriter) writeItem(item queuedPacket) {
+	resync, switched := item.resync, item.switched
+	for _, p := range item.replay {
+		if w.write(p, resync, switched) {
+			resync, switched = false, false
+		}
+	}
+	w.write(item.Packet, resync, switched)
+}
+
+// write writes a packet to track unless it's filtered out by SVC layers, it reports whether the packet is written.
+// Sequence number of a resync packet follows the last written one,
+// and timestamp of the first packet of a layer switched to follows the last written one by time elapsed since.
+func (w *subscriberWriter) write(p *rtp.Packet, resync, switched bool) bool {
+	marker := p.Marker
+	if w.svc {
+		keep, end := filterVP9(p.Payload, int(w.spatialLayer.Load()), int(w.temporalLayer.Load()))
+		if !keep {
+			// Following packets take sequence number of the dropped one.
+			w.seqOffset--
+			return false
+		}
+		marker = marker || end
+	}
+
+	if w.written {
+		switch {
+		case switched:
+			elapsed := uint32(time.Since(w.lastWrittenAt).Seconds() * videoClockRate)
+			if elapsed == 0 {
+				elapsed = 1
+			}
+			w.tsOffset = w.lastTimestamp + elapsed - p.Timestamp
+			w.seqOffset = w.lastSeq + 1 - p.SequenceNumber
+		case resync:
+			w.seqOffset = w.lastSeq + 1 - p.SequenceNumber
+		}
+	}
+
+	// Header extensions are negotiated with publisher, interceptors of subscriber add their own.
+	packet := rtp.Packet{Header: p.Header, Payload: p.Payload, PaddingSize: p.PaddingSize}
+	packet.Extension = false
+	packet.ExtensionProfile = 0
+	packet.Extensions = nil
+	packet.Marker = marker
+	packet.SequenceNumber += w.seqOffset
+	packet.Timestamp += w.tsOffset
+	w.lastSeq = packet.SequenceNumber
+	w.lastTimestamp = packet.Timestamp
+	w.lastWrittenAt = time.Now()
+	w.written = true
+
+	// ErrClosedPipe means subscriber is closing, writer is stopped on unbind.
+	if err := w.track.WriteRTP(&packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		w.writeErrors.Inc()
+		w.logger.Debug().Err(err).Msg("could not write subscriber track")
+	}
+	return true
+}
+
+// close stops run loop and waits for it.
+func (w *subscriberWriter) close() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
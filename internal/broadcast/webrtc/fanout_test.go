@@ -0,0 +1,195 @@
+package webrtc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog"
+)
+
+// testContext binds a subscriber track as a peer connection does, packets written are sent to channel if any.
+type testContext struct {
+	id      string
+	packets chan rtp.Header
+}
+
+func (c *testContext) CodecParameters() []webrtc.RTPCodecParameters {
+	return []webrtc.RTPCodecParameters{{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000},
+		PayloadType:        102,
+	}}
+}
+
+func (c *testContext) HeaderExtensions() []webrtc.RTPHeaderExtensionParameter { return nil }
+func (c *testContext) SSRC() webrtc.SSRC                                      { return 5 }
+func (c *testContext) WriteStream() webrtc.TrackLocalWriter                   { return c }
+func (c *testContext) ID() string                                             { return c.id }
+func (c *testContext) RTCPReader() interceptor.RTCPReader                     { return nil }
+
+func (c *testContext) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	if c.packets != nil {
+		c.packets <- *header
+	}
+	return len(payload), nil
+}
+
+func (c *testContext) Write(b []byte) (int, error) { return len(b), nil }
+
+func bindSubscriber(tb testing.TB, ctx *testContext, layers ...*Fanout) *subscriberTrack {
+	tb.Helper()
+
+	logger := zerolog.Nop()
+	track, err := newSubscriberTrack(&logger, layers...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := track.Bind(ctx); err != nil {
+		tb.Fatal(err)
+	}
+	return track
+}
+
+func TestFanoutReplay(t *testing.T) {
+	f := CreateLocalTrack(webrtc.MimeTypeH264, nil)
+
+	// STAP-A of SPS and PPS.
+	stapA := []byte{0x78, 0x00, byte(len(h264SPS))}
+	stapA = append(stapA, h264SPS...)
+	stapA = append(stapA, 0x00, byte(len(h264PPS)))
+	stapA = append(stapA, h264PPS...)
+
+	f.WriteRTP(h264Packet(9, 0, h264Slice)) // Before key frame, not cached.
+	f.WriteRTP(h264Packet(10, 3000, stapA))
+	f.WriteRTP(h264Packet(11, 3000, h264IDR))
+
+	ctx := &testContext{id: "a", packets: make(chan rtp.Header, 8)}
+	track := bindSubscriber(t, ctx, f)
+	defer track.Unbind(ctx) //nolint:errcheck
+	f.WriteRTP(h264Packet(12, 6000, h264Slice))
+	f.WriteRTP(h264Packet(13, 9000, h264Slice))
+
+	var headers []rtp.Header
+	for len(headers) < 4 {
+		select {
+		case h := <-ctx.packets:
+			headers = append(headers, h)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d packets, want 4", len(headers))
+		}
+	}
+	// Cached key frame is replayed before live packets, with offsets of the subscriber.
+	for i, h := range headers {
+		if h.SSRC != 5 || h.PayloadType != 102 {
+			t.Errorf("packet %d: got ssrc %d payload type %d", i, h.SSRC, h.PayloadType)
+		}
+		if h.SequenceNumber != headers[0].SequenceNumber+uint16(i) {
+			t.Errorf("packet %d: sequence number is not continuous", i)
+		}
+	}
+	if headers[1].Timestamp != headers[0].Timestamp || headers[2].Timestamp-headers[0].Timestamp != 3000 {
+		t.Error("timestamps are not shifted by the same offset")
+	}
+}
+
+func TestSubscriberWriterDrop(t *testing.T) {
+	f := CreateLocalTrack(webrtc.MimeTypeH264, nil)
+	logger := zerolog.Nop()
+	w := newSubscriberWriter(f, nil, &logger)
+
+	// The writer isn't running, so its queue is full after a GOP as long as queue.
+	seq := uint16(0)
+	w.enqueue(f, h264Packet(seq, 0, h264IDR))
+	for seq = 1; seq < subscriberQueueSize; seq++ {
+		w.enqueue(f, h264Packet(seq, uint32(seq)*3000, h264Slice))
+	}
+	w.enqueue(f, h264Packet(seq, uint32(seq)*3000, h264Slice))
+	if len(w.queue) != subscriberQueueSize || !w.dropping || len(f.keyFrameRequests) != 1 {
+		t.Fatalf("queue is %d, dropping is %v, key frame requests are %d", len(w.queue), w.dropping, len(f.keyFrameRequests))
+	}
+
+	// Video is dropped until the next key frame, which is written right after the last written packet.
+	<-w.queue
+	seq++
+	w.enqueue(f, h264Packet(seq, uint32(seq)*3000, h264Slice))
+	if len(w.queue) != subscriberQueueSize-1 {
+		t.Fatal("inter frame is queued after dropping")
+	}
+	seq++
+	w.enqueue(f, h264Packet(seq, uint32(seq)*3000, h264IDR))
+	if len(w.queue) != subscriberQueueSize || w.dropping {
+		t.Fatal("key frame isn't queued after dropping")
+	}
+	for len(w.queue) > 1 {
+		<-w.queue
+	}
+	if item := <-w.queue; !item.resync || item.SequenceNumber != seq {
+		t.Errorf("got seq %d resync %v, want seq %d resync true", item.SequenceNumber, item.resync, seq)
+	}
+}
+
+func TestSubscriberWriterSwitch(t *testing.T) {
+	high := CreateLocalTrack(webrtc.MimeTypeH264, nil)
+	low := newFanout(high.codec, high.id, high.streamID, metricLabels(nil, webrtc.RTPCodecTypeVideo))
+	logger := zerolog.Nop()
+	w := newSubscriberWriter(high, nil, &logger)
+	high.add(w)
+
+	high.WriteRTP(h264Packet(100, 3000, h264IDR))
+	w.switchTo(low)
+	if len(low.keyFrameRequests) != 1 || low.Subscribers() != 1 {
+		t.Fatal("writer isn't waiting for key frame of layer switched to")
+	}
+
+	// The old layer is forwarded until a key frame of the new one.
+	low.WriteRTP(h264Packet(500, 90000, h264Slice))
+	high.WriteRTP(h264Packet(101, 6000, h264Slice))
+	low.WriteRTP(h264Packet(501, 93000, h264IDR))
+	high.WriteRTP(h264Packet(102, 9000, h264Slice))
+	if high.Subscribers() != 0 || w.source != low || w.target != nil {
+		t.Fatal("writer isn't switched at key frame")
+	}
+
+	var items []queuedPacket
+	for len(w.queue) > 0 {
+		items = append(items, <-w.queue)
+	}
+	if len(items) != 3 || items[1].SequenceNumber != 101 || items[2].SequenceNumber != 501 || !items[2].switched {
+		t.Fatalf("got %d packets queued, want key frame, inter frame of old layer and key frame of new layer", len(items))
+	}
+}
+
+func BenchmarkFanout(b *testing.B) {
+	for _, viewers := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("%d viewers", viewers), func(b *testing.B) {
+			f := CreateLocalTrack(webrtc.MimeTypeH264, nil)
+			for i := 0; i < viewers; i++ {
+				ctx := &testContext{id: fmt.Sprint(i)}
+				track := bindSubscriber(b, ctx, f)
+				defer track.Unbind(ctx) //nolint:errcheck
+			}
+
+			// A key frame every 300 packets, the other packets are inter frames.
+			keyFrame := make([]byte, 1200)
+			keyFrame[0] = h264IDR[0]
+			interFrame := make([]byte, 1200)
+			interFrame[0] = h264Slice[0]
+			b.SetBytes(int64(len(interFrame)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				payload := interFrame
+				if i%300 == 0 {
+					payload = keyFrame
+				}
+				f.WriteRTP(&rtp.Packet{
+					Header:  rtp.Header{Version: 2, SequenceNumber: uint16(i), Timestamp: uint32(i / 10 * 3000)},
+					Payload: payload,
+				})
+			}
+		})
+	}
+}
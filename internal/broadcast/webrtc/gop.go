@@ -0,0 +1,91 @@
+package webrtc
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/SB-IM/charoite/pkg/rtpcodec"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// maxGOPPackets bounds memory of a GOP cache, a longer GOP is dropped and new viewers wait for the next key frame.
+const maxGOPPackets = 4096
+
+// gopCache keeps video packets of a session from the last key frame on, including parameter sets.
+// It's written by forwarding loop and replayed to new subscribers by their writers.
+type gopCache struct {
+	keyFrameStart func(payload []byte) bool
+	paramSets     func(payload []byte) bool // Nil if codec has no out of band parameter sets.
+
+	mu      sync.Mutex
+	packets []*rtp.Packet
+	params  []*rtp.Packet // The last parameter set packets, they are prepended to a GOP without them.
+}
+
+// newGOPCache returns a GOP cache of video MIME type, it returns nil if codec is unknown.
+func newGOPCache(mimeType string) *gopCache {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return &gopCache{keyFrameStart: rtpcodec.H264KeyFrameStart, paramSets: rtpcodec.H264ParamSets}
+	case strings.ToLower(webrtc.MimeTypeH265):
+		return &gopCache{keyFrameStart: rtpcodec.H265KeyFrameStart, paramSets: rtpcodec.H265ParamSets}
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return &gopCache{keyFrameStart: rtpcodec.VP8KeyFrameStart}
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return &gopCache{keyFrameStart: rtpcodec.VP9KeyFrameStart}
+	default:
+		return nil
+	}
+}
+
+// push caches a packet received from publisher, the packet must not be modified afterwards.
+func (c *gopCache) push(p *rtp.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paramSets != nil && c.paramSets(p.Payload) {
+		if len(c.params) > 0 && c.params[len(c.params)-1].SequenceNumber+1 != p.SequenceNumber {
+			c.params = nil
+		}
+		c.params = append(c.params, p)
+	}
+
+	switch {
+	case c.keyFrameStart(p.Payload):
+		// Parameter sets and key frame of the same picture share a GOP.
+		if len(c.packets) > 0 && c.packets[0].Timestamp == p.Timestamp {
+			break
+		}
+		c.packets = c.packets[:0]
+		if c.paramSets != nil && !c.paramSets(p.Payload) {
+			// Parameter sets sent earlier are placed right before key frame.
+			for i, param := range c.params {
+				cp := *param
+				cp.SequenceNumber = p.SequenceNumber - uint16(len(c.params)-i)
+				cp.Timestamp = p.Timestamp
+				c.packets = append(c.packets, &cp)
+			}
+		}
+	case len(c.packets) == 0:
+		// Waiting for a key frame.
+		return
+	case len(c.packets) >= maxGOPPackets:
+		c.packets = nil
+		return
+	}
+	c.packets = append(c.packets, p)
+}
+
+// before returns cached packets sent before sequence number seq.
+func (c *gopCache) before(seq uint16) []*rtp.Packet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, p := range c.packets {
+		if int16(p.SequenceNumber-seq) >= 0 {
+			return append([]*rtp.Packet(nil), c.packets[:i]...)
+		}
+	}
+	return append([]*rtp.Packet(nil), c.packets...)
+}
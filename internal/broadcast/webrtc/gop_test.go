@@ -0,0 +1,46 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	h264SPS   = []byte{0x67, 0x42, 0xc0, 0x1f}
+	h264PPS   = []byte{0x68, 0x1a, 0x34}
+	h264IDR   = []byte{0x65, 0x88, 0x84}
+	h264Slice = []byte{0x41, 0x9a, 0x02}
+)
+
+func h264Packet(seq uint16, timestamp uint32, payload []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{SequenceNumber: seq, Timestamp: timestamp},
+		Payload: payload,
+	}
+}
+
+func TestGOPCacheParamSets(t *testing.T) {
+	c := newGOPCache(webrtc.MimeTypeH264)
+
+	c.push(h264Packet(20, 9000, h264SPS))
+	c.push(h264Packet(21, 9000, h264PPS))
+	c.push(h264Packet(22, 9000, h264IDR))
+	c.push(h264Packet(23, 12000, h264Slice))
+	// Key frame without parameter sets.
+	c.push(h264Packet(24, 15000, h264IDR))
+
+	packets := c.before(25)
+	if len(packets) != 3 {
+		t.Fatalf("got %d packets, want 3", len(packets))
+	}
+	for i, p := range packets {
+		if want := uint16(22 + i); p.SequenceNumber != want || p.Timestamp != 15000 {
+			t.Errorf("packet %d: got seq %d ts %d, want seq %d ts 15000", i, p.SequenceNumber, p.Timestamp, want)
+		}
+	}
+	if packets[0].Payload[0] != h264SPS[0] || packets[1].Payload[0] != h264PPS[0] {
+		t.Error("parameter sets are not placed before key frame")
+	}
+}
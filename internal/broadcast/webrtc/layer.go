@@ -0,0 +1,167 @@
+package webrtc
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// AllLayers forwards all spatial or temporal layers of SVC.
+const AllLayers = -1
+
+const (
+	// layerSwitchInterval is the interval layer is selected by bandwidth estimate at.
+	layerSwitchInterval = time.Second
+
+	// minLayerProbeInterval is the minimum time a layer is kept before a higher layer is tried.
+	// Bandwidth estimate is capped by what subscriber receives, so a higher layer has to be tried to know if it fits.
+	// The interval is doubled every time a try fails, up to maxLayerProbeInterval.
+	minLayerProbeInterval = time.Second * 8
+	maxLayerProbeInterval = time.Minute * 2
+)
+
+var (
+	// ErrUnknownLayer means publisher doesn't send the layer selected.
+	ErrUnknownLayer = errors.New("unknown layer")
+
+	// ErrNoVideoSubscribed means peer connection isn't a subscriber of video.
+	ErrNoVideoSubscribed = errors.New("no video subscribed")
+)
+
+// Layer is a video layer selected by a subscriber.
+type Layer struct {
+	RID string // RTP stream id of a simulcast layer, empty selects the first layer offered.
+
+	// Highest spatial and temporal layers of VP9 SVC forwarded, AllLayers forwards all of them.
+	SpatialLayer  int
+	TemporalLayer int
+}
+
+// layerSelector selects a simulcast layer by bandwidth estimate of a subscriber.
+// It switches down as soon as selected layer doesn't fit estimate, and tries a higher layer
+// if it fits estimate with headroom or selected layer has been kept for probe interval.
+// A try fails if estimate drops below where it was when the try started.
+type layerSelector struct {
+	stableSince   time.Time
+	probeInterval time.Duration
+
+	probing       bool
+	probeEstimate int
+}
+
+// ratedLayer is a layer with its bitrate measured when selecting.
+type ratedLayer struct {
+	*Fanout
+	rate int
+}
+
+// choose returns layer to forward for bandwidth estimate in bits per second.
+func (s *layerSelector) choose(layers []*Fanout, current *Fanout, estimate int, now time.Time) *Fanout {
+	if s.probeInterval == 0 {
+		s.probeInterval = minLayerProbeInterval
+	}
+	if s.stableSince.IsZero() {
+		s.stableSince = now
+	}
+	if estimate <= 0 {
+		return current
+	}
+
+	// Layers sent by publisher, from the highest bitrate to the lowest.
+	active := make([]ratedLayer, 0, len(layers))
+	for _, f := range layers {
+		if rate := f.Bitrate(); rate > 0 {
+			active = append(active, ratedLayer{Fanout: f, rate: rate})
+		}
+	}
+	if len(active) == 0 {
+		return current
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].rate > active[j].rate })
+	i := len(active)
+	for j, l := range active {
+		if l.Fanout == current {
+			i = j
+			break
+		}
+	}
+
+	switch {
+	case i == len(active):
+		// Publisher has paused selected layer.
+		s.probing = false
+		return s.switchTo(highestFitting(active, estimate), now)
+	case s.probing:
+		if active[i].rate <= estimate {
+			s.probing = false
+			s.probeInterval = minLayerProbeInterval
+		} else if estimate < s.probeEstimate && i+1 < len(active) {
+			s.probing = false
+			s.probeInterval *= 2
+			if s.probeInterval > maxLayerProbeInterval {
+				s.probeInterval = maxLayerProbeInterval
+			}
+			return s.switchTo(active[i+1].Fanout, now)
+		}
+		return current
+	case active[i].rate > estimate:
+		if i+1 == len(active) {
+			return current
+		}
+		return s.switchTo(highestFitting(active[i+1:], estimate), now)
+	case i > 0:
+		higher := active[i-1]
+		if higher.rate <= estimate*4/5 {
+			return s.switchTo(higher.Fanout, now)
+		}
+		if now.Sub(s.stableSince) >= s.probeInterval {
+			s.probing = true
+			s.probeEstimate = estimate
+			return s.switchTo(higher.Fanout, now)
+		}
+	}
+	return current
+}
+
+func (s *layerSelector) switchTo(f *Fanout, now time.Time) *Fanout {
+	s.stableSince = now
+	return f
+}
+
+// highestFitting returns the highest layer fitting bandwidth estimate, or the lowest layer if none fits.
+func highestFitting(layers []ratedLayer, estimate int) *Fanout {
+	for _, l := range layers {
+		if l.rate <= estimate {
+			return l.Fanout
+		}
+	}
+	return layers[len(layers)-1].Fanout
+}
+
+// filterVP9 reports whether a VP9 packet belongs to the spatial and temporal layers forwarded,
+// and whether it ends a picture of the highest spatial layer forwarded, which has to be marked as end of frame.
+// A packet without layer indices or failing to parse is forwarded.
+// See: https://datatracker.ietf.org/doc/html/draft-ietf-payload-vp9-16#section-4.2
+func filterVP9(payload []byte, spatialLayer, temporalLayer int) (keep, end bool) {
+	if len(payload) < 1 || payload[0]&0x20 == 0 {
+		return true, false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 { // Picture ID.
+		if len(payload) < i+1 {
+			return true, false
+		}
+		if payload[i]&0x80 != 0 {
+			i++
+		}
+		i++
+	}
+	if len(payload) < i+1 {
+		return true, false
+	}
+	temporal, spatial := int(payload[i]>>5), int(payload[i]>>1&0x07)
+	if (temporalLayer != AllLayers && temporal > temporalLayer) || (spatialLayer != AllLayers && spatial > spatialLayer) {
+		return false, false
+	}
+	return true, spatial == spatialLayer && payload[0]&0x04 != 0
+}
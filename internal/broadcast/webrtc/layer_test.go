@@ -0,0 +1,69 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestLayerSelector(t *testing.T) {
+	var layers []*Fanout
+	for _, rate := range []int64{2_500_000, 800_000, 200_000} {
+		f := newFanout(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "broadcast", metricLabels(nil, webrtc.RTPCodecTypeVideo))
+		f.bitrate.Store(rate)
+		layers = append(layers, f)
+	}
+	high, mid, low := layers[0], layers[1], layers[2]
+
+	var s layerSelector
+	now := time.Now()
+	for _, step := range []struct {
+		name     string
+		after    time.Duration
+		estimate int
+		current  *Fanout
+		want     *Fanout
+	}{
+		{"congested", 0, 600_000, high, low},
+		{"higher layer fits with headroom", time.Second, 1_100_000, low, mid},
+		{"kept before probe interval", time.Second, 1_000_000, mid, mid},
+		{"higher layer tried after probe interval", minLayerProbeInterval, 1_000_000, mid, high},
+		{"try kept while estimate grows", time.Second, 1_500_000, high, high},
+		{"try failed as estimate drops", time.Second, 900_000, high, mid},
+		{"next try is later", minLayerProbeInterval, 1_000_000, mid, mid},
+		{"next try after doubled interval", minLayerProbeInterval, 1_000_000, mid, high},
+	} {
+		now = now.Add(step.after)
+		if got := s.choose(layers, step.current, step.estimate, now); got != step.want {
+			t.Fatalf("%s: got layer of %d bps, want %d bps", step.name, got.Bitrate(), step.want.Bitrate())
+		}
+	}
+
+	// Layer paused by publisher is switched from.
+	high.bitrate.Store(0)
+	if got := s.choose(layers, high, 5_000_000, now.Add(time.Second)); got != mid {
+		t.Fatalf("got layer of %d bps, want %d bps", got.Bitrate(), mid.Bitrate())
+	}
+}
+
+func TestFilterVP9(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		payload           []byte
+		spatial, temporal int
+		keep, end         bool
+	}{
+		{"no layer indices", []byte{0x88}, 0, 0, true, false},
+		{"all layers", []byte{0xa4, 0x01, 0x42}, AllLayers, AllLayers, true, false},
+		{"higher spatial layer", []byte{0xa0, 0x01, 0x02}, 0, AllLayers, false, false},
+		{"end of highest spatial layer forwarded", []byte{0xa4, 0x81, 0x01, 0x02}, 1, AllLayers, true, true},
+		{"higher temporal layer", []byte{0x20, 0x40}, AllLayers, 1, false, false},
+		{"base temporal layer", []byte{0x24, 0x00}, 0, 1, true, true},
+	} {
+		keep, end := filterVP9(tc.payload, tc.spatial, tc.temporal)
+		if keep != tc.keep || end != tc.end {
+			t.Errorf("%s: got keep %v end %v, want keep %v end %v", tc.name, keep, end, tc.keep, tc.end)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package webrtc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	packetsForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "packets_forwarded_total",
+		Help:      "Number of RTP packets forwarded from publisher to subscribers.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	bytesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "bytes_forwarded_total",
+		Help:      "Number of RTP bytes forwarded from publisher to subscribers, its rate is session bitrate.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	rtpWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "rtp_write_errors_total",
+		Help:      "Number of RTP packets failed writing to subscriber tracks.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	subscriberPacketsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "subscriber_packets_dropped_total",
+		Help:      "Number of RTP packets dropped as subscriber queues are full.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	pliSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "pli_sent_total",
+		Help:      "Number of PLI sent to publishers requesting key frames.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	nacksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "nacks_received_total",
+		Help:      "Number of RTP packets requested by NACKs of subscribers.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	packetsRetransmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "packets_retransmitted_total",
+		Help:      "Number of RTP packets retransmitted to subscribers from NACK buffers.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	nacksSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "nacks_sent_total",
+		Help:      "Number of RTP packets lost from publishers and requested by NACKs.",
+	}, []string{"id", "track_source", "stream", "kind"})
+
+	iceStateTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "broadcast",
+		Name:      "ice_state_transitions_total",
+		Help:      "Number of ICE connection state transitions of peer connections.",
+	}, []string{"role", "state"})
+)
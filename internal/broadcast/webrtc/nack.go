@@ -0,0 +1,178 @@
+package webrtc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// nackCounterFactory creates a nackCounter for a peer connection of a session.
+type nackCounterFactory struct {
+	meta   *pb.Meta
+	logger zerolog.Logger
+}
+
+// NewInterceptor returns a nackCounter.
+func (f *nackCounterFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &nackCounter{
+		meta:    f.meta,
+		logger:  f.logger,
+		streams: make(map[uint32]*nackStream),
+	}, nil
+}
+
+// nackCounter counts NACKs and retransmissions of a peer connection, and logs the totals when it's closed.
+// It's registered before NACK interceptors, so that it writes NACKs sent by generator and packets resent by responder.
+// A packet resent has a sequence number not newer than what's already written.
+type nackCounter struct {
+	interceptor.NoOp
+	meta   *pb.Meta
+	logger zerolog.Logger
+
+	mu      sync.Mutex
+	streams map[uint32]*nackStream // Local and remote streams by SSRC.
+
+	received      atomic.Uint64
+	retransmitted atomic.Uint64
+	sent          atomic.Uint64
+}
+
+// nackStream is a stream counted by nackCounter.
+type nackStream struct {
+	received      prometheus.Counter
+	retransmitted prometheus.Counter
+	sent          prometheus.Counter
+
+	mu      sync.Mutex
+	written bool
+	lastSeq uint16 // The newest sequence number written.
+}
+
+func (n *nackCounter) addStream(info *interceptor.StreamInfo) *nackStream {
+	kind := webrtc.NewRTPCodecType(strings.SplitN(info.MimeType, "/", 2)[0])
+	labels := metricLabels(n.meta, kind)
+	stream := &nackStream{
+		received:      nacksReceived.With(labels),
+		retransmitted: packetsRetransmitted.With(labels),
+		sent:          nacksSent.With(labels),
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.streams[info.SSRC] = stream
+	return stream
+}
+
+func (n *nackCounter) stream(ssrc uint32) *nackStream {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.streams[ssrc]
+}
+
+func (n *nackCounter) removeStream(info *interceptor.StreamInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.streams, info.SSRC)
+}
+
+// BindRTCPReader counts packets requested by NACKs received.
+func (n *nackCounter) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		i, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		packets, err := attr.GetRTCPPackets(b[:i])
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, packet := range packets {
+			if nack, ok := packet.(*rtcp.TransportLayerNack); ok {
+				count := nackedPackets(nack)
+				n.received.Add(uint64(count))
+				if stream := n.stream(nack.MediaSSRC); stream != nil {
+					stream.received.Add(float64(count))
+				}
+			}
+		}
+		return i, attr, nil
+	})
+}
+
+// BindRTCPWriter counts packets requested by NACKs sent.
+func (n *nackCounter) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(packets []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		for _, packet := range packets {
+			if nack, ok := packet.(*rtcp.TransportLayerNack); ok {
+				count := nackedPackets(nack)
+				n.sent.Add(uint64(count))
+				if stream := n.stream(nack.MediaSSRC); stream != nil {
+					stream.sent.Add(float64(count))
+				}
+			}
+		}
+		return writer.Write(packets, attributes)
+	})
+}
+
+// BindLocalStream counts packets retransmitted.
+func (n *nackCounter) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	stream := n.addStream(info)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		stream.mu.Lock()
+		if stream.written && int16(header.SequenceNumber-stream.lastSeq) <= 0 {
+			stream.retransmitted.Inc()
+			n.retransmitted.Add(1)
+		} else {
+			stream.written = true
+			stream.lastSeq = header.SequenceNumber
+		}
+		stream.mu.Unlock()
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream stops counting a local stream.
+func (n *nackCounter) UnbindLocalStream(info *interceptor.StreamInfo) {
+	n.removeStream(info)
+}
+
+// BindRemoteStream counts NACKs sent for a remote stream.
+func (n *nackCounter) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	n.addStream(info)
+	return reader
+}
+
+// UnbindRemoteStream stops counting a remote stream.
+func (n *nackCounter) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	n.removeStream(info)
+}
+
+// Close logs totals of peer connection.
+func (n *nackCounter) Close() error {
+	n.logger.Info().
+		Uint64("nacks_received", n.received.Load()).
+		Uint64("packets_retransmitted", n.retransmitted.Load()).
+		Uint64("nacks_sent", n.sent.Load()).
+		Msg("NACK statistics of peer connection")
+	return nil
+}
+
+// nackedPackets returns number of packets requested by a NACK.
+func nackedPackets(nack *rtcp.TransportLayerNack) int {
+	count := 0
+	for _, pair := range nack.Nacks {
+		count += len(pair.PacketList())
+	}
+	return count
+}
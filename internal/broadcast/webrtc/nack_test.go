@@ -0,0 +1,43 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+)
+
+func TestNACKCounter(t *testing.T) {
+	factory := &nackCounterFactory{logger: zerolog.Nop()}
+	i, err := factory.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := i.(*nackCounter)
+
+	writer := n.BindLocalStream(&interceptor.StreamInfo{SSRC: 1, MimeType: "video/H264"}, interceptor.RTPWriterFunc(
+		func(_ *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) { return len(payload), nil },
+	))
+	// Sequence numbers wrap around, packets not newer than the last one are retransmissions.
+	for _, seq := range []uint16{65534, 65535, 0, 65535, 1, 0} {
+		if _, err := writer.Write(&rtp.Header{SequenceNumber: seq}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := n.retransmitted.Load(); got != 2 {
+		t.Errorf("got %d packets retransmitted, want 2", got)
+	}
+
+	nack := &rtcp.TransportLayerNack{MediaSSRC: 1, Nacks: []rtcp.NackPair{{PacketID: 10, LostPackets: 0b101}}}
+	rtcpWriter := n.BindRTCPWriter(interceptor.RTCPWriterFunc(
+		func(packets []rtcp.Packet, _ interceptor.Attributes) (int, error) { return len(packets), nil },
+	))
+	if _, err := rtcpWriter.Write([]rtcp.Packet{nack}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.sent.Load(); got != 3 {
+		t.Errorf("got %d packets NACKed, want 3", got)
+	}
+}
@@ -0,0 +1,48 @@
+package webrtc
+
+import (
+	"sync"
+)
+
+// Peers is a set of alive WebRTC peers, it's used to close all peers on shutdown.
+// A peer is removed from set after its peer connection is closed.
+type Peers struct {
+	mu    sync.Mutex
+	peers map[*WebRTC]struct{}
+}
+
+// Add adds a peer to set.
+func (p *Peers) Add(w *WebRTC) {
+	p.mu.Lock()
+	if p.peers == nil {
+		p.peers = make(map[*WebRTC]struct{})
+	}
+	p.peers[w] = struct{}{}
+	p.mu.Unlock()
+
+	go func() {
+		<-w.Done()
+
+		p.mu.Lock()
+		delete(p.peers, w)
+		p.mu.Unlock()
+	}()
+}
+
+// Close closes all peers and returns the first error if any.
+func (p *Peers) Close() error {
+	p.mu.Lock()
+	peers := make([]*WebRTC, 0, len(p.peers))
+	for w := range p.peers {
+		peers = append(peers, w)
+	}
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, w := range peers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
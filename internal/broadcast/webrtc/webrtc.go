@@ -4,12 +4,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/SB-IM/charoite/pkg/webrtcapi"
+	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/randutil"
 	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
 	"github.com/SB-IM/charoite/internal/broadcast/cfg"
@@ -29,9 +38,117 @@ type RegisterSessionFunc func()
 type UpdateCounterFunc func(int)
 
 const (
-	rtcpPLIInterval = time.Second * 3
+	// keyFrameRequestInterval is the minimum interval of PLI sent to a publisher on viewers' requests.
+	keyFrameRequestInterval = time.Millisecond * 500
+	gatherTimeout           = time.Second * 5
+
+	// initialBandwidthEstimate is bandwidth estimate of a subscriber in bits per second before any feedback.
+	initialBandwidthEstimate = 1_000_000
+	// rembTimeout is how long a REMB of a subscriber is used as its bandwidth estimate.
+	rembTimeout = time.Second * 5
 )
 
+// Roles of peer connections.
+const (
+	rolePublisher  = "publisher"
+	roleSubscriber = "subscriber"
+)
+
+// RTPSink consumes RTP packets received from publisher, e.g. a recorder.
+// WriteRTP is called in forwarding loop, so it must not block.
+type RTPSink interface {
+	WriteRTP(kind webrtc.RTPCodecType, codec webrtc.RTPCodecParameters, packet *rtp.Packet)
+}
+
+// LocalTracks are local tracks of a session, they are written by publisher and fanned out to subscribers.
+type LocalTracks struct {
+	Video *Fanout // The first video layer, it's what sinks receive and subscribers start with.
+	Audio *Fanout // Nil if publisher doesn't send audio.
+
+	// Layers are video layers in the order publisher offers them, Video is the first.
+	// There are more than one only if publisher sends simulcast.
+	Layers []*Fanout
+
+	// meta is metadata of the publisher, it labels metrics.
+	meta *pb.Meta
+
+	// lastPacketAt is unix nano time of the last packet received from publisher.
+	lastPacketAt atomic.Int64
+
+	// sinks is replaced on change rather than modified, so that it can be iterated without lock.
+	sinkMux sync.RWMutex
+	sinks   []RTPSink
+}
+
+// metricLabels returns metric labels of a track kind.
+func (t *LocalTracks) metricLabels(kind webrtc.RTPCodecType) prometheus.Labels {
+	return metricLabels(t.meta, kind)
+}
+
+// metricLabels returns metric labels of a track kind of publisher, meta may be nil.
+func metricLabels(meta *pb.Meta, kind webrtc.RTPCodecType) prometheus.Labels {
+	labels := prometheus.Labels{"id": "", "track_source": "", "stream": "", "kind": kind.String()}
+	if meta != nil {
+		labels["id"] = meta.Id
+		labels["track_source"] = meta.TrackSource.String()
+		labels["stream"] = meta.Stream
+	}
+	return labels
+}
+
+// LastPacketAt returns time of the last packet received from publisher, it's zero if nothing received.
+func (t *LocalTracks) LastPacketAt() time.Time {
+	n := t.lastPacketAt.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// RequestKeyFrame asks publisher for a key frame of the first video layer without blocking.
+// Requests are coalesced and rate limited, so it's cheap to call for every viewer.
+func (t *LocalTracks) RequestKeyFrame() {
+	t.Video.RequestKeyFrame()
+}
+
+// Layer returns video layer of RTP stream id, it returns nil if there is no such layer.
+// Empty stream id is of a publisher not sending simulcast.
+func (t *LocalTracks) Layer(rid string) *Fanout {
+	for _, f := range t.Layers {
+		if f.rid == rid {
+			return f
+		}
+	}
+	return nil
+}
+
+// Sinks returns RTP sinks of tracks.
+func (t *LocalTracks) Sinks() []RTPSink {
+	t.sinkMux.RLock()
+	defer t.sinkMux.RUnlock()
+	return t.sinks
+}
+
+// AddSink adds an RTP sink which receives a copy of every packet from publisher.
+func (t *LocalTracks) AddSink(sink RTPSink) {
+	t.sinkMux.Lock()
+	defer t.sinkMux.Unlock()
+	t.sinks = append(t.sinks[:len(t.sinks):len(t.sinks)], sink)
+}
+
+// RemoveSink removes an RTP sink, it does nothing if sink wasn't added.
+func (t *LocalTracks) RemoveSink(sink RTPSink) {
+	t.sinkMux.Lock()
+	defer t.sinkMux.Unlock()
+	sinks := make([]RTPSink, 0, len(t.sinks))
+	for _, s := range t.sinks {
+		if s != sink {
+			sinks = append(sinks, s)
+		}
+	}
+	t.sinks = sinks
+}
+
 type WebRTC struct {
 	logger zerolog.Logger
 	config cfg.WebRTCConfigOptions
@@ -39,11 +156,30 @@ type WebRTC struct {
 	// SignalChan is a bi-direction channel.
 	SignalChan chan *webrtc.SessionDescription
 
+	// NonTrickle makes answer wait for ICE gathering so that it carries all candidates.
+	// It's used by WHIP and WHEP signaling which don't trickle candidates.
+	NonTrickle bool
+
+	peerConnection *webrtc.PeerConnection
+	role           string
+
+	// done is closed after peer connection is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+
 	pendingCandidates []*webrtc.ICECandidate
 	candidatesMux     sync.Mutex
 
 	connectionCounter uint32
 
+	// video is video track of a subscriber, it's nil for publisher.
+	video *subscriberTrack
+
+	// Bandwidth estimates of a subscriber, by transport-wide congestion control feedback or the last REMB received.
+	estimator cc.BandwidthEstimator
+	remb      atomic.Int64
+	rembAt    atomic.Int64
+
 	sendCandidate SendCandidateFunc
 	recvCandidate RecvCandidateFunc
 
@@ -70,47 +206,194 @@ func New(
 		recvCandidate:   recvCandidate,
 		registerSession: registerSession,
 		updateCounter:   updateCounter,
+		done:            make(chan struct{}),
 	}
 }
 
-// CreateLocalTrack creates a TrackLocalStaticRTP and is only used by publisher.
-func CreateLocalTrack() (*webrtc.TrackLocalStaticRTP, error) {
-	return webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+// CreateLocalTrack creates a video track of MIME type fanning out to subscribers,
+// its metrics are labeled by meta, which may be nil.
+func CreateLocalTrack(mimeType string, meta *pb.Meta) *Fanout {
+	return newFanout(
+		webrtc.RTPCodecCapability{MimeType: mimeType},
 		fmt.Sprintf("video-%d", randutil.NewMathRandomGenerator().Uint32()),
 		fmt.Sprintf("broadcast-%d", randutil.NewMathRandomGenerator().Uint32()),
+		metricLabels(meta, webrtc.RTPCodecTypeVideo),
+	)
+}
+
+// CreateLocalTracks creates local tracks for a publisher offer.
+// Video track codec is the first one offered, which is what edge negotiates with H.264, H.265, VP8 or VP9.
+// A video track is created for every simulcast layer offered, all layers share id and stream id
+// as subscribers see one of them at a time.
+// An audio track is created only if the offer has audio, its codec is the first one offered.
+func CreateLocalTracks(offer *webrtc.SessionDescription, meta *pb.Meta) (*LocalTracks, error) {
+	mimeType, err := offeredMimeType(offer, webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		return nil, errors.New("no video offered")
+	}
+	videoTrack := CreateLocalTrack(mimeType, meta)
+	tracks := &LocalTracks{
+		Video:  videoTrack,
+		Layers: []*Fanout{videoTrack},
+		meta:   meta,
+	}
+
+	rids, err := offeredRIDs(offer)
+	if err != nil {
+		return nil, err
+	}
+	for i, rid := range rids {
+		layer := videoTrack
+		if i > 0 {
+			layer = newFanout(videoTrack.codec, videoTrack.id, videoTrack.streamID, metricLabels(meta, webrtc.RTPCodecTypeVideo))
+			tracks.Layers = append(tracks.Layers, layer)
+		}
+		layer.rid = rid
+	}
+
+	mimeType, err = offeredMimeType(offer, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		return tracks, nil
+	}
+
+	// Audio track shares stream id with video track so that subscribers can synchronize them.
+	tracks.Audio = newFanout(
+		webrtc.RTPCodecCapability{MimeType: mimeType},
+		fmt.Sprintf("audio-%d", randutil.NewMathRandomGenerator().Uint32()),
+		videoTrack.StreamID(),
+		metricLabels(meta, webrtc.RTPCodecTypeAudio),
 	)
+	return tracks, nil
+}
+
+// offeredMimeType returns MIME type of the first codec offered for given kind.
+// It returns empty string if there is no such media in offer.
+func offeredMimeType(offer *webrtc.SessionDescription, kind webrtc.RTPCodecType) (string, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return "", fmt.Errorf("could not parse offer: %w", err)
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != kind.String() || len(media.MediaName.Formats) == 0 {
+			continue
+		}
+		if _, inactive := media.Attribute(webrtc.RTPTransceiverDirectionInactive.String()); inactive {
+			continue
+		}
+		payloadType, err := strconv.ParseUint(media.MediaName.Formats[0], 10, 8)
+		if err != nil {
+			return "", fmt.Errorf("could not parse payload type: %w", err)
+		}
+		codec, err := parsed.GetCodecForPayloadType(uint8(payloadType))
+		if err != nil {
+			return "", fmt.Errorf("could not get codec of payload type %d: %w", payloadType, err)
+		}
+		return kind.String() + "/" + codec.Name, nil
+	}
+	return "", nil
+}
+
+// offeredRIDs returns RTP stream ids of simulcast video layers offered in order.
+// It returns nil if publisher doesn't send simulcast.
+func offeredRIDs(offer *webrtc.SessionDescription) ([]string, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse offer: %w", err)
+	}
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != webrtc.RTPCodecTypeVideo.String() {
+			continue
+		}
+		var rids []string
+		for _, attr := range media.Attributes {
+			if attr.Key != "rid" {
+				continue
+			}
+			// a=rid:<rid-id> send [...]
+			fields := strings.Fields(attr.Value)
+			if len(fields) >= 2 && fields[1] == "send" {
+				rids = append(rids, fields[0])
+			}
+		}
+		return rids, nil
+	}
+	return nil, nil
 }
 
 // CreatePublisher creates a webRTC publisher peer.
 // Caller must send offer first by OfferChan or this function blocks waiting for receiving offer forever.
-func (w *WebRTC) CreatePublisher(videoTrack *webrtc.TrackLocalStaticRTP) error {
-	peerConnection, err := w.newPeerConnection()
+func (w *WebRTC) CreatePublisher(tracks *LocalTracks) error {
+	peerConnection, err := w.newPeerConnection(tracks)
 	if err != nil {
 		return fmt.Errorf("could not create PeerConnection: %w", err)
 	}
+	w.peerConnection = peerConnection
+	w.role = rolePublisher
 
 	// Allow us to receive 1 video track
 	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
 		return fmt.Errorf("could not add tranceiver from kind: %w", err)
 	}
+	// And 1 audio track if publisher sends audio.
+	if tracks.Audio != nil {
+		if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			return fmt.Errorf("could not add tranceiver from kind: %w", err)
+		}
+	}
 
 	// Set a handler for when a new remote track starts, this just distributes all our packets
 	// to connected peers
 	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		go w.sendRTCP(peerConnection, t)
+		localTrack := tracks.Audio
+		if t.Kind() == webrtc.RTPCodecTypeVideo {
+			localTrack = tracks.Layer(t.RID())
+		}
+		if localTrack == nil {
+			w.logger.Warn().Str("kind", t.Kind().String()).Str("rid", t.RID()).Msg("no local track for remote track")
+			return
+		}
+		if t.Kind() == webrtc.RTPCodecTypeVideo {
+			go w.sendRTCP(peerConnection, t, localTrack, tracks.metricLabels(webrtc.RTPCodecTypeVideo))
+		}
+		// Only the first video layer is consumed by sinks.
+		toSinks := localTrack == tracks.Video || localTrack == tracks.Audio
+		w.logger.Info().Str("kind", t.Kind().String()).Str("rid", t.RID()).Str("mime_type", t.Codec().MimeType).Msg("received remote track")
+
+		labels := tracks.metricLabels(t.Kind())
+		packets := packetsForwarded.With(labels)
+		bytes := bytesForwarded.With(labels)
+
 		rtpBuf := make([]byte, 1400)
 		for {
 			i, _, readErr := t.Read(rtpBuf)
 			if readErr != nil {
-				w.logger.Err(err).Msg("could not read buffer")
+				w.logger.Err(readErr).Msg("could not read buffer")
 				return
 			}
-			// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
-			if _, err = videoTrack.Write(rtpBuf[:i]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
-				w.logger.Err(err).Msg("could not write video track")
-				return
+			tracks.lastPacketAt.Store(time.Now().UnixNano())
+
+			// Packet is copied as buffer is reused, subscribers and sinks share it and must not modify it.
+			packet := &rtp.Packet{}
+			if err := packet.Unmarshal(append([]byte(nil), rtpBuf[:i]...)); err != nil {
+				w.logger.Debug().Err(err).Msg("could not unmarshal RTP packet")
+				continue
 			}
+			localTrack.WriteRTP(packet)
+			if toSinks {
+				for _, sink := range tracks.Sinks() {
+					sink.WriteRTP(t.Kind(), t.Codec(), packet)
+				}
+			}
+			packets.Inc()
+			bytes.Add(float64(i))
 		}
 	})
 
@@ -124,26 +407,99 @@ func (w *WebRTC) CreatePublisher(videoTrack *webrtc.TrackLocalStaticRTP) error {
 
 // CreateSubscriber creates a webRTC subscriber peer.
 // Caller must send offer first by OfferChan or this function blocks waiting for receiving offer forever.
-func (w *WebRTC) CreateSubscriber(videoTrack *webrtc.TrackLocalStaticRTP) error {
-	peerConnection, err := w.newPeerConnection()
+// Subscriber of a simulcast publisher starts with the first layer, and layer is selected by its bandwidth estimate
+// until SelectLayer is called.
+func (w *WebRTC) CreateSubscriber(tracks *LocalTracks) error {
+	peerConnection, err := w.newPeerConnection(tracks, webrtcapi.WithBandwidthEstimator(initialBandwidthEstimate, func(estimator cc.BandwidthEstimator) {
+		w.estimator = estimator
+	}))
 	if err != nil {
 		return fmt.Errorf("could not create PeerConnection: %w", err)
 	}
+	w.peerConnection = peerConnection
+	w.role = roleSubscriber
 
-	rtpSender, err := peerConnection.AddTrack(videoTrack)
-	if err != nil {
-		return fmt.Errorf("could not add track: %w", err)
+	var videoSender *webrtc.RTPSender
+	for _, layers := range [][]*Fanout{tracks.Layers, {tracks.Audio}} {
+		if layers[0] == nil {
+			continue
+		}
+		track, err := newSubscriberTrack(&w.logger, layers...)
+		if err != nil {
+			return fmt.Errorf("could not create local track: %w", err)
+		}
+		rtpSender, err := peerConnection.AddTrack(track)
+		if err != nil {
+			return fmt.Errorf("could not add track: %w", err)
+		}
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			w.video, videoSender = track, rtpSender
+		}
+		go w.processRTCP(rtpSender, track)
 	}
-	go w.processRTCP(rtpSender)
 
 	if err := w.signalPeerConnection(peerConnection); err != nil {
 		return fmt.Errorf("failed to create peer connection: %w", err)
 	}
+	if len(tracks.Layers) > 1 {
+		go w.adaptLayers(videoSender)
+	}
 	w.logger.Info().Msg("created peer connection for subscriber")
 
 	return nil
 }
 
+// SelectLayer selects video layer of a subscriber, it switches at the next key frame of the layer.
+// Nil layer lets layer be selected by bandwidth estimate, which is the default.
+func (w *WebRTC) SelectLayer(layer *Layer) error {
+	if w.video == nil {
+		return ErrNoVideoSubscribed
+	}
+	if layer == nil {
+		w.video.selectAuto()
+		return nil
+	}
+	return w.video.selectLayer(*layer)
+}
+
+// SelectedLayer returns video layer of a subscriber and whether it's selected by bandwidth estimate.
+func (w *WebRTC) SelectedLayer() (Layer, bool, error) {
+	if w.video == nil {
+		return Layer{}, false, ErrNoVideoSubscribed
+	}
+	layer, auto := w.video.selectedLayer()
+	return layer, auto, nil
+}
+
+// adaptLayers selects video layer by bandwidth estimate of subscriber until peer connection is closed.
+// Transport-wide congestion control feedback is preferred, REMB is used if subscriber doesn't support it.
+func (w *WebRTC) adaptLayers(rtpSender *webrtc.RTPSender) {
+	twcc := false
+	for _, ext := range rtpSender.GetParameters().HeaderExtensions {
+		if ext.URI == sdp.TransportCCURI {
+			twcc = true
+		}
+	}
+
+	ticker := time.NewTicker(layerSwitchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			estimate := 0
+			switch {
+			case twcc && w.estimator != nil:
+				estimate = w.estimator.GetTargetBitrate()
+			case now.Sub(time.Unix(0, w.rembAt.Load())) < rembTimeout:
+				estimate = int(w.remb.Load())
+			}
+			w.video.adapt(estimate, now)
+		case <-w.done:
+			return
+		}
+	}
+}
+
 func (w *WebRTC) signalPeerConnection(peerConnection *webrtc.PeerConnection) error {
 	offer := <-w.SignalChan
 	candidateChan := w.recvCandidate()
@@ -168,11 +524,18 @@ func (w *WebRTC) signalPeerConnection(peerConnection *webrtc.PeerConnection) err
 
 	// Set the handler for ICE connection state
 	// This will notify you when the peer has connected/disconnected
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateClosed {
+			w.closeOnce.Do(func() { close(w.done) })
+		}
+	})
+
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		w.counterMux.Lock()
 		defer w.counterMux.Unlock()
 
 		w.logger.Info().Str("state", connectionState.String()).Msg("ICE connection state has changed")
+		iceStateTransitions.WithLabelValues(w.role, connectionState.String()).Inc()
 
 		oldCounter := w.connectionCounter
 
@@ -184,8 +547,11 @@ func (w *WebRTC) signalPeerConnection(peerConnection *webrtc.PeerConnection) err
 			w.logger.Info().Msg("peer connection has been closed")
 		case webrtc.ICEConnectionStateConnected:
 			w.connectionCounter++
-		case webrtc.ICEConnectionStateDisconnected:
-			w.connectionCounter--
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateClosed:
+			// Peer connection may be closed after disconnected.
+			if w.connectionCounter > 0 {
+				w.connectionCounter--
+			}
 		default:
 		}
 
@@ -213,10 +579,21 @@ func (w *WebRTC) signalPeerConnection(peerConnection *webrtc.PeerConnection) err
 		return fmt.Errorf("could not create answer: %w", err)
 	}
 
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 	if err = peerConnection.SetLocalDescription(answer); err != nil {
 		return fmt.Errorf("could not set local description: %w", err)
 	}
 
+	if w.NonTrickle {
+		timer := time.NewTimer(gatherTimeout)
+		defer timer.Stop()
+		select {
+		case <-gatherComplete:
+		case <-timer.C:
+			w.logger.Warn().Dur("timeout", gatherTimeout).Msg("timed out gathering ICE candidates")
+		}
+	}
+
 	// Send answer of local description.
 	w.SignalChan <- peerConnection.LocalDescription()
 
@@ -234,8 +611,27 @@ func (w *WebRTC) signalPeerConnection(peerConnection *webrtc.PeerConnection) err
 	return nil
 }
 
-func (w *WebRTC) newPeerConnection() (*webrtc.PeerConnection, error) {
-	return webrtc.NewPeerConnection(webrtc.Configuration{
+// Close closes peer connection created by CreatePublisher or CreateSubscriber.
+func (w *WebRTC) Close() error {
+	if w.peerConnection == nil || w.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return nil
+	}
+	return closePeerConnection(w.peerConnection)
+}
+
+// Done returns a channel that's closed after peer connection is closed.
+func (w *WebRTC) Done() <-chan struct{} {
+	return w.done
+}
+
+// newPeerConnection creates a peer connection of session tracks, NACKs and retransmissions are counted for the session.
+func (w *WebRTC) newPeerConnection(tracks *LocalTracks, options ...webrtcapi.Option) (*webrtc.PeerConnection, error) {
+	options = append(options, webrtcapi.WithNACK(w.config.NACKBufferSize, &nackCounterFactory{meta: tracks.meta, logger: w.logger}))
+	api, err := webrtcapi.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewPeerConnection(webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
 			{
 				URLs:       []string{w.config.ICEServer},
@@ -277,11 +673,42 @@ func closePeerConnection(peerConnection *webrtc.PeerConnection) error {
 	return peerConnection.Close()
 }
 
-// sendRTCP sends a PLI on an interval so that the publisher is pushing a keyframe every rtcpPLIInterval
-// This can be less wasteful by processing incoming RTCP events, then we would emit a NACK/PLI when a viewer requests it
-func (w *WebRTC) sendRTCP(peerConnection *webrtc.PeerConnection, remoteTrack *webrtc.TrackRemote) {
-	ticker := time.NewTicker(rtcpPLIInterval)
-	for range ticker.C {
+// sendRTCP sends a PLI to publisher when viewers request a key frame of a video layer,
+// at most once every keyFrameRequestInterval.
+// Requests arriving in the meantime are answered by the same PLI.
+// If PLI interval is configured, a PLI is also sent on the interval so that the publisher is pushing key frames regularly.
+func (w *WebRTC) sendRTCP(peerConnection *webrtc.PeerConnection, remoteTrack *webrtc.TrackRemote, layer *Fanout, labels prometheus.Labels) {
+	var periodic <-chan time.Time
+	if w.config.PLIInterval > 0 {
+		ticker := time.NewTicker(w.config.PLIInterval)
+		defer ticker.Stop()
+		periodic = ticker.C
+	}
+	sent := pliSent.With(labels)
+
+	var lastSentAt time.Time
+	for {
+		select {
+		case <-layer.keyFrameRequests:
+			if wait := keyFrameRequestInterval - time.Since(lastSentAt); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-w.done:
+					timer.Stop()
+					return
+				}
+			}
+		case <-periodic:
+		case <-w.done:
+			return
+		}
+		// Drop requests coalesced into this PLI.
+		select {
+		case <-layer.keyFrameRequests:
+		default:
+		}
+
 		if rtcpSendErr := peerConnection.WriteRTCP([]rtcp.Packet{
 			&rtcp.PictureLossIndication{
 				MediaSSRC: uint32(remoteTrack.SSRC()),
@@ -290,16 +717,20 @@ func (w *WebRTC) sendRTCP(peerConnection *webrtc.PeerConnection, remoteTrack *we
 			w.logger.Err(rtcpSendErr).Send()
 			return
 		}
+		lastSentAt = time.Now()
+		sent.Inc()
 	}
 }
 
-// processRTCP reads incoming RTCP packets
+// processRTCP reads incoming RTCP packets of a subscriber.
 // Before these packets are returned they are processed by interceptors.
 // For things like NACK this needs to be called.
-func (w *WebRTC) processRTCP(rtpSender *webrtc.RTPSender) {
-	rtcpBuf := make([]byte, 1500)
+// PLI and FIR from the viewer are forwarded to publisher of selected layer as key frame requests,
+// and REMB is kept as bandwidth estimate of the viewer.
+func (w *WebRTC) processRTCP(rtpSender *webrtc.RTPSender, track *subscriberTrack) {
 	for {
-		if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
+		packets, _, rtcpErr := rtpSender.ReadRTCP()
+		if rtcpErr != nil {
 			if errors.Is(rtcpErr, io.EOF) || errors.Is(rtcpErr, io.ErrClosedPipe) {
 				_ = rtpSender.Stop()
 			} else {
@@ -307,6 +738,15 @@ func (w *WebRTC) processRTCP(rtpSender *webrtc.RTPSender) {
 			}
 			return
 		}
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				track.requestKeyFrame()
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				w.remb.Store(int64(packet.Bitrate))
+				w.rembAt.Store(time.Now().UnixNano())
+			}
+		}
 	}
 }
 
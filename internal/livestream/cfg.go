@@ -1,11 +1,32 @@
 package livestream
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+)
+
 const (
 	protocolRTP  = "rtp"
 	protocolRTSP = "rtsp"
 	protocolRTMP = "rtmp"
 )
 
+const (
+	videoCodecH264 = "h264"
+	videoCodecH265 = "h265"
+	videoCodecVP8  = "vp8"
+	videoCodecVP9  = "vp9"
+)
+
+const (
+	audioCodecOpus = "opus"
+	audioCodecPCMU = "pcmu"
+	audioCodecPCMA = "pcma"
+)
+
 type PublisherConfigOptions struct {
 	UUID string
 	MQTTClientConfigOptions
@@ -14,6 +35,12 @@ type PublisherConfigOptions struct {
 	// Currently only RTP is supported for drone.
 	// Currently mainly RTSP, the other one is RTP for deport.
 	StreamSource
+
+	// BitrateHook overrides configured congestion control if it's not nil.
+	BitrateHook BitrateHook
+
+	// Signer signs offers and candidates sent to cloud, nil sends them unsigned.
+	Signer *pb.Signer
 }
 
 type broadcastConfigOptions struct {
@@ -21,6 +48,8 @@ type broadcastConfigOptions struct {
 	WebRTCConfigOptions
 
 	ConsumeStreamOnDemand bool
+	VideoCodec            string
+	AudioCodec            string
 }
 
 type MQTTClientConfigOptions struct {
@@ -37,21 +66,119 @@ type WebRTCConfigOptions struct {
 	ICEServer  string
 	Username   string
 	Credential string
+
+	// CongestionControl is how publisher reacts to uplink bandwidth estimate, one of none, drop_frames or command.
+	CongestionControl string
+	// BitrateCommand runs with estimated bitrate in bits per second appended to its arguments, it's used by command congestion control.
+	BitrateCommand string
 }
 
 type StreamSource struct {
+	// Name is the stream label which distinguishes multiple streams of the same track source.
+	// It's empty for drone_stream and deport_stream.
+	Name string
+	// TrackSource is used by NewPublisher only.
+	TrackSource pb.TrackSource
+
 	Protocol string // rtp or rtsp or rtmp
 	RTSPSourceConfigOptions
 	RTPOrRTMPSourceConfigOptions
 
 	ConsumeStreamOnDemand bool
+
+	// VideoCodec is one of h264, h265, vp8 or vp9, it's negotiated in edge offer.
+	// RTSP and RTMP stream sources support h264 and h265 only.
+	VideoCodec string
+
+	// AudioCodec is one of opus, pcmu or pcma, empty disables audio.
+	// Audio codec of stream source must be the same, audio is not transcoded.
+	AudioCodec string
 }
 
 type RTPOrRTMPSourceConfigOptions struct {
-	Host string
-	Port int
+	Host      string
+	Port      int
+	AudioPort int // RTP audio is sent to a separate port, 0 disables audio for RTP stream source.
 }
 
 type RTSPSourceConfigOptions struct {
 	Addr string
 }
+
+// ValidateWebRTC checks congestion control of WebRTC options.
+func ValidateWebRTC(options *WebRTCConfigOptions) error {
+	switch options.CongestionControl {
+	case congestionControlNone, congestionControlDropFrames:
+	case congestionControlCommand:
+		if strings.TrimSpace(options.BitrateCommand) == "" {
+			return errors.New("empty bitrate command for command congestion control")
+		}
+	default:
+		return fmt.Errorf("unsupported congestion control: %q", options.CongestionControl)
+	}
+	return nil
+}
+
+// ValidateStreams checks stream sources configured in [[streams]] list.
+// Stream names are part of MQTT topics, so they must be unique per track source and can't contain MQTT topic separator or wildcards.
+func ValidateStreams(streams []StreamSource) error {
+	type key struct {
+		trackSource pb.TrackSource
+		name        string
+	}
+	names := make(map[key]bool, len(streams))
+	ports := make(map[int]string) // Listening ports of RTP and RTMP stream sources.
+	usePort := func(name string, port int) error {
+		if other, ok := ports[port]; ok {
+			return fmt.Errorf("stream %s: port %d is already used by stream %s", name, port, other)
+		}
+		ports[port] = name
+		return nil
+	}
+	for i, s := range streams {
+		if s.Name == "" {
+			return fmt.Errorf("stream %d: empty name", i)
+		}
+		if strings.ContainsAny(s.Name, "/+#") {
+			return fmt.Errorf("stream %s: name must not contain '/', '+' or '#'", s.Name)
+		}
+		if s.TrackSource != pb.TrackSource_DRONE && s.TrackSource != pb.TrackSource_MONITOR {
+			return fmt.Errorf("stream %s: unsupported track source: %s", s.Name, s.TrackSource)
+		}
+		switch s.Protocol {
+		case protocolRTP, protocolRTMP:
+			if s.Port == 0 {
+				return fmt.Errorf("stream %s: empty port", s.Name)
+			}
+			if err := usePort(s.Name, s.Port); err != nil {
+				return err
+			}
+			if s.Protocol == protocolRTP && s.AudioPort != 0 {
+				if err := usePort(s.Name, s.AudioPort); err != nil {
+					return err
+				}
+			}
+		case protocolRTSP:
+			if s.Addr == "" {
+				return fmt.Errorf("stream %s: empty addr", s.Name)
+			}
+		default:
+			return fmt.Errorf("stream %s: unsupported protocol: %q", s.Name, s.Protocol)
+		}
+		switch s.VideoCodec {
+		case videoCodecH264, videoCodecH265:
+		case videoCodecVP8, videoCodecVP9:
+			if s.Protocol != protocolRTP {
+				return fmt.Errorf("stream %s: video codec %s is supported by rtp protocol only", s.Name, s.VideoCodec)
+			}
+		default:
+			return fmt.Errorf("stream %s: unsupported video codec: %q", s.Name, s.VideoCodec)
+		}
+		k := key{s.TrackSource, s.Name}
+		if names[k] {
+			return errors.New("duplicated stream name: " + s.Name)
+		}
+		names[k] = true
+	}
+	return nil
+}
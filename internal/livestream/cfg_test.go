@@ -0,0 +1,60 @@
+package livestream
+
+import (
+	"testing"
+
+	pb "github.com/SB-IM/charoite/internal/pb/signal"
+)
+
+func TestValidateStreams(t *testing.T) {
+	valid := []StreamSource{
+		{
+			Name:                    "cam1",
+			TrackSource:             pb.TrackSource_MONITOR,
+			Protocol:                protocolRTSP,
+			VideoCodec:              videoCodecH265,
+			RTSPSourceConfigOptions: RTSPSourceConfigOptions{Addr: "rtsp://192.168.1.10/stream"},
+		},
+		{
+			Name:                         "cam1",
+			TrackSource:                  pb.TrackSource_DRONE,
+			Protocol:                     protocolRTP,
+			VideoCodec:                   videoCodecVP8,
+			RTPOrRTMPSourceConfigOptions: RTPOrRTMPSourceConfigOptions{Host: "0.0.0.0", Port: 5004, AudioPort: 5006},
+		},
+		{
+			Name:                         "cam2",
+			TrackSource:                  pb.TrackSource_MONITOR,
+			Protocol:                     protocolRTMP,
+			VideoCodec:                   videoCodecH264,
+			RTPOrRTMPSourceConfigOptions: RTPOrRTMPSourceConfigOptions{Host: "0.0.0.0", Port: 1935},
+		},
+	}
+	if err := ValidateStreams(valid); err != nil {
+		t.Fatalf("valid streams: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *StreamSource)
+	}{
+		{"empty name", func(s *StreamSource) { s.Name = "" }},
+		{"topic wildcard in name", func(s *StreamSource) { s.Name = "cam/+" }},
+		{"unknown track source", func(s *StreamSource) { s.TrackSource = pb.TrackSource_UNKNOWN }},
+		{"unknown protocol", func(s *StreamSource) { s.Protocol = "srt" }},
+		{"unknown video codec", func(s *StreamSource) { s.VideoCodec = "av1" }},
+		{"vp8 over rtmp", func(s *StreamSource) { s.Protocol = protocolRTMP }},
+		{"duplicated name", func(s *StreamSource) { s.Name = "cam2"; s.TrackSource = pb.TrackSource_MONITOR }},
+		{"duplicated port", func(s *StreamSource) { s.Port = 1935 }},
+		{"duplicated audio port", func(s *StreamSource) { s.AudioPort = 1935 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streams := append([]StreamSource(nil), valid...)
+			tt.modify(&streams[1])
+			if err := ValidateStreams(streams); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package livestream
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SB-IM/charoite/pkg/rtpcodec"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+)
+
+const (
+	congestionControlNone       = "none"
+	congestionControlDropFrames = "drop_frames"
+	congestionControlCommand    = "command"
+)
+
+const (
+	// initialBitrateEstimate is where estimation of uplink starts.
+	initialBitrateEstimate = 1_000_000
+	// bitrateEstimateInterval is how often a bitrate estimate is made.
+	bitrateEstimateInterval = time.Second
+	// bitrateLogInterval is how often a bitrate estimate is logged.
+	bitrateLogInterval = 10 * time.Second
+	// rembTimeout is how long a REMB received limits the estimate.
+	rembTimeout = 5 * time.Second
+
+	// Uplink is congested if fraction lost is higher than congestedLoss,
+	// or the estimate is lower than 3/4 of bitrate sent.
+	congestedLoss = 0.1
+	// Frames are dropped at least minDropDuration after uplink is congested, and until fraction lost is lower than recoveredLoss.
+	// The duration doubles up to maxDropDuration if uplink is congested again within it after frames are sent.
+	recoveredLoss   = 0.02
+	minDropDuration = 10 * time.Second
+	maxDropDuration = 2 * time.Minute
+
+	// Bitrate command runs if the estimate changes by more than 1/bitrateCommandChange since it last ran,
+	// at most once in bitrateCommandInterval.
+	bitrateCommandChange   = 5
+	bitrateCommandInterval = 5 * time.Second
+	bitrateCommandTimeout  = 3 * time.Second
+)
+
+// BitrateEstimate is an estimate of uplink of a publisher.
+type BitrateEstimate struct {
+	Bitrate      int           // Estimated bitrate uplink affords, in bits per second.
+	SendBitrate  int           // Bitrate of RTP packets sent, in bits per second.
+	FractionLost float64       // Fraction of packets lost reported by receiver, from 0 to 1.
+	RTT          time.Duration // Round trip time reported by receiver, 0 if it's unknown.
+}
+
+// congested reports whether uplink can't afford what's sent.
+func (e BitrateEstimate) congested() bool {
+	return e.FractionLost > congestedLoss || e.Bitrate < e.SendBitrate*3/4
+}
+
+// BitrateHook reacts to bitrate estimates of a publisher, it's called every second while peer connection is open.
+// It can drop frames or signal camera or encoder to lower bitrate.
+type BitrateHook interface {
+	OnBitrateEstimate(e BitrateEstimate)
+}
+
+// newBitrateHook returns bitrate hook of configured congestion control, nil if it's none.
+func newBitrateHook(options *PublisherConfigOptions, u *uplink, logger *zerolog.Logger) BitrateHook {
+	if options.BitrateHook != nil {
+		return options.BitrateHook
+	}
+	switch options.CongestionControl {
+	case congestionControlDropFrames:
+		return &frameDropper{setDropping: u.dropping.Store, logger: logger}
+	case congestionControlCommand:
+		return &commandHook{command: strings.Fields(options.BitrateCommand), logger: logger}
+	default:
+		return nil
+	}
+}
+
+// uplink counts bytes sent by peer connections of a publisher, and drops non-key video frames while it's told to.
+// It persists across peer connections and is registered after all other interceptors,
+// so that frames dropped are neither kept for retransmission nor counted by congestion controller.
+type uplink struct {
+	sent     atomic.Uint64 // Bytes of RTP packets sent.
+	dropping atomic.Bool
+}
+
+// NewInterceptor returns an interceptor of a peer connection.
+func (u *uplink) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &uplinkInterceptor{uplink: u}, nil
+}
+
+// uplinkInterceptor is an interceptor of uplink.
+type uplinkInterceptor struct {
+	interceptor.NoOp
+	*uplink
+}
+
+// BindLocalStream drops video frames after the first frame dropped and until the next key frame,
+// so that every frame sent can be decoded. Sequence numbers of packets after dropped ones are renumbered to be continuous.
+func (i *uplinkInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	keyFrameStart := rtpcodec.KeyFrameStart(info.MimeType)
+	var (
+		mu        sync.Mutex
+		started   bool
+		timestamp uint32
+		skipping  bool
+		dropped   uint16 // Packets dropped, sequence numbers are shifted back by it.
+	)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if keyFrameStart != nil {
+			mu.Lock()
+			if !started || header.Timestamp != timestamp {
+				started = true
+				timestamp = header.Timestamp
+				skipping = !keyFrameStart(payload) && (skipping || i.dropping.Load())
+			}
+			if skipping {
+				dropped++
+				mu.Unlock()
+				return len(payload), nil
+			}
+			shift := dropped
+			mu.Unlock()
+			if shift != 0 {
+				h := *header
+				h.SequenceNumber -= shift
+				header = &h
+			}
+		}
+		i.sent.Add(uint64(header.MarshalSize() + len(payload)))
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// frameDropper drops non-key video frames while uplink is congested.
+type frameDropper struct {
+	setDropping func(bool)
+	logger      *zerolog.Logger
+
+	mu       sync.Mutex
+	dropping bool
+	since    time.Time     // When frames started or stopped being dropped.
+	holdFor  time.Duration // How long frames are dropped at least.
+}
+
+// OnBitrateEstimate implements BitrateHook.
+func (d *frameDropper) OnBitrateEstimate(e BitrateEstimate) {
+	d.update(e, time.Now())
+}
+
+func (d *frameDropper) update(e BitrateEstimate, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.holdFor == 0 {
+		d.holdFor = minDropDuration
+	}
+
+	switch {
+	case !d.dropping && e.congested():
+		// Congested again soon after frames are sent, it takes longer to recover.
+		if !d.since.IsZero() && now.Sub(d.since) < d.holdFor {
+			d.holdFor *= 2
+			if d.holdFor > maxDropDuration {
+				d.holdFor = maxDropDuration
+			}
+		} else {
+			d.holdFor = minDropDuration
+		}
+		d.dropping = true
+		d.since = now
+		d.logger.Warn().Int("bitrate", e.Bitrate).Int("send_bitrate", e.SendBitrate).Float64("fraction_lost", e.FractionLost).
+			Dur("hold_for", d.holdFor).Msg("uplink is congested, dropping non-key frames")
+	case d.dropping && e.FractionLost < recoveredLoss && now.Sub(d.since) >= d.holdFor:
+		d.dropping = false
+		d.since = now
+		d.logger.Info().Int("bitrate", e.Bitrate).Float64("fraction_lost", e.FractionLost).Msg("uplink recovered, sending all frames")
+	default:
+		return
+	}
+	d.setDropping(d.dropping)
+}
+
+// commandHook runs a command with estimated bitrate in bits per second as its last argument,
+// so that camera or encoder lowers bitrate when uplink is congested, and raises it after uplink recovers.
+type commandHook struct {
+	command []string
+	logger  *zerolog.Logger
+
+	mu      sync.Mutex
+	running bool
+	bitrate int // Bitrate command last ran with.
+	ranAt   time.Time
+}
+
+// OnBitrateEstimate implements BitrateHook.
+func (h *commandHook) OnBitrateEstimate(e BitrateEstimate) {
+	if bitrate, ok := h.due(e, time.Now()); ok {
+		go h.run(bitrate)
+	}
+}
+
+// due reports whether command runs for an estimate, with the bitrate it runs with.
+func (h *commandHook) due(e BitrateEstimate, now time.Time) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.running || now.Sub(h.ranAt) < bitrateCommandInterval {
+		return 0, false
+	}
+	change := e.Bitrate - h.bitrate
+	if change < 0 {
+		change = -change
+	}
+	if h.bitrate != 0 && change*bitrateCommandChange < h.bitrate {
+		return 0, false
+	}
+	h.running = true
+	h.bitrate = e.Bitrate
+	h.ranAt = now
+	return e.Bitrate, true
+}
+
+func (h *commandHook) run(bitrate int) {
+	defer func() {
+		h.mu.Lock()
+		h.running = false
+		h.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), bitrateCommandTimeout)
+	defer cancel()
+	args := append(h.command[1:len(h.command):len(h.command)], strconv.Itoa(bitrate))
+	output, err := exec.CommandContext(ctx, h.command[0], args...).CombinedOutput()
+	if err != nil {
+		h.logger.Err(err).Int("bitrate", bitrate).Bytes("output", output).Msg("bitrate command failed")
+		return
+	}
+	h.logger.Info().Int("bitrate", bitrate).Msg("ran bitrate command")
+}
+
+// uplinkStats are reported by receiver over RTCP for a peer connection.
+type uplinkStats struct {
+	mu           sync.Mutex
+	fractionLost float64
+	rtt          time.Duration
+	remb         int
+	rembAt       time.Time
+}
+
+// estimate makes a bitrate estimate from stats and estimate of congestion controller.
+// REMB received lately limits the estimate, it's the estimate if transport-wide congestion control isn't negotiated.
+func (s *uplinkStats) estimate(bitrate int, twcc bool, sendBitrate int, now time.Time) BitrateEstimate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.rembAt) < rembTimeout && (!twcc || s.remb < bitrate) {
+		bitrate = s.remb
+	} else if !twcc {
+		// Nothing is known about uplink but loss, what's sent is assumed to be affordable.
+		bitrate = sendBitrate
+	}
+	return BitrateEstimate{
+		Bitrate:      bitrate,
+		SendBitrate:  sendBitrate,
+		FractionLost: s.fractionLost,
+		RTT:          s.rtt,
+	}
+}
+
+// addReport updates stats by a reception report received at now.
+func (s *uplinkStats) addReport(report rtcp.ReceptionReport, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fractionLost = float64(report.FractionLost) / 256
+	// Round trip time is time since sender report was sent minus delay since it was received,
+	// in middle 32 bits of NTP timestamps, which is in 1/65536 seconds.
+	if report.LastSenderReport != 0 {
+		if rtt := int32(ntpMiddle(now) - report.LastSenderReport - report.Delay); rtt > 0 {
+			s.rtt = time.Duration(rtt) * time.Second / 65536
+		}
+	}
+}
+
+// addREMB updates stats by a REMB received at now.
+func (s *uplinkStats) addREMB(remb *rtcp.ReceiverEstimatedMaximumBitrate, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remb = int(remb.Bitrate)
+	s.rembAt = now
+}
+
+// ntpMiddle returns middle 32 bits of NTP timestamp of t.
+func ntpMiddle(t time.Time) uint32 {
+	const ntpEpochOffset = 2_208_988_800 // Seconds from 1900 to 1970.
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return uint32((seconds<<32 | fraction) >> 16)
+}
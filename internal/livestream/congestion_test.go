@@ -0,0 +1,116 @@
+package livestream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/rs/zerolog"
+)
+
+func TestFrameDropper(t *testing.T) {
+	var dropping bool
+	logger := zerolog.Nop()
+	d := &frameDropper{setDropping: func(v bool) { dropping = v }, logger: &logger}
+
+	congested := BitrateEstimate{Bitrate: 500_000, SendBitrate: 1_000_000}
+	lossy := BitrateEstimate{Bitrate: 1_000_000, SendBitrate: 1_000_000, FractionLost: 0.05}
+	fine := BitrateEstimate{Bitrate: 1_000_000, SendBitrate: 1_000_000}
+
+	now := time.Now()
+	for _, step := range []struct {
+		name  string
+		after time.Duration
+		e     BitrateEstimate
+		want  bool
+	}{
+		{"fine", 0, fine, false},
+		{"congested", time.Second, congested, true},
+		{"held after congestion", time.Second, fine, true},
+		{"resumed after hold", minDropDuration, fine, false},
+		{"congested again soon", time.Second, congested, true},
+		{"held for doubled duration", minDropDuration, fine, true},
+		{"kept while loss is not low enough", minDropDuration, lossy, true},
+		{"resumed after doubled hold", time.Second, fine, false},
+		{"congested again later", 3 * minDropDuration, congested, true},
+		{"hold is reset", minDropDuration, fine, false},
+	} {
+		now = now.Add(step.after)
+		d.update(step.e, now)
+		if dropping != step.want {
+			t.Fatalf("%s: got dropping %v, want %v", step.name, dropping, step.want)
+		}
+	}
+}
+
+func TestUplinkInterceptorDropFrames(t *testing.T) {
+	u := new(uplink)
+	i, err := u.NewInterceptor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []uint16
+	writer := i.BindLocalStream(&interceptor.StreamInfo{SSRC: 1, MimeType: "video/VP8"}, interceptor.RTPWriterFunc(
+		func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+			written = append(written, header.SequenceNumber)
+			return len(payload), nil
+		},
+	))
+
+	var (
+		keyFrame   = []byte{0x10, 0x00}
+		interFrame = []byte{0x10, 0x01}
+		next       = []byte{0x00, 0x00}
+	)
+	for _, p := range []struct {
+		seq       uint16
+		timestamp uint32
+		payload   []byte
+		dropping  bool
+	}{
+		{100, 0, keyFrame, false},
+		{101, 0, next, false},
+		{102, 3000, interFrame, true},  // Dropped.
+		{103, 3000, next, false},       // Dropped as the rest of its frame.
+		{104, 6000, interFrame, false}, // Dropped until next key frame.
+		{105, 9000, keyFrame, true},
+		{106, 9000, next, false},
+		{107, 12000, interFrame, false},
+	} {
+		u.dropping.Store(p.dropping)
+		if _, err := writer.Write(&rtp.Header{SequenceNumber: p.seq, Timestamp: p.timestamp}, p.payload, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []uint16{100, 101, 102, 103, 104}
+	if len(written) != len(want) {
+		t.Fatalf("got sequence numbers %v, want %v", written, want)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("got sequence numbers %v, want %v", written, want)
+		}
+	}
+}
+
+func TestUplinkStatsRTT(t *testing.T) {
+	var s uplinkStats
+	sentAt := time.Now()
+	receivedAt := sentAt.Add(150 * time.Millisecond)
+	// Receiver held sender report for 50ms, and lost 26 of 256 packets.
+	s.addReport(rtcp.ReceptionReport{SSRC: 1, FractionLost: 26, LastSenderReport: ntpMiddle(sentAt), Delay: 65536 / 20}, receivedAt)
+
+	e := s.estimate(1_000_000, true, 800_000, receivedAt)
+	if diff := e.RTT - 100*time.Millisecond; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("got RTT %v, want 100ms", e.RTT)
+	}
+	if e.FractionLost != 26.0/256 {
+		t.Errorf("got fraction lost %v, want %v", e.FractionLost, 26.0/256)
+	}
+	if e.Bitrate != 1_000_000 {
+		t.Errorf("got bitrate %d, want 1000000", e.Bitrate)
+	}
+}
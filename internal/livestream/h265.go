@@ -0,0 +1,139 @@
+package livestream
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// rtpOutboundMTU is the same MTU as pion's TrackLocalStaticSample.
+	rtpOutboundMTU = 1200
+
+	h265NALUHeaderSize = 2
+	h265FUHeaderSize   = 1
+	h265NALUTypeFU     = 49
+)
+
+// sampleTrack is a local track written by samples, it's implemented by
+// webrtc.TrackLocalStaticSample and h265TrackSample.
+type sampleTrack interface {
+	webrtc.TrackLocal
+	Codec() webrtc.RTPCodecCapability
+	WriteSample(sample media.Sample) error
+}
+
+// h265TrackSample is a TrackLocalStaticRTP which packetizes H.265 samples, as pion has no H.265 payloader
+// and TrackLocalStaticSample can't be bound with H.265 codec.
+type h265TrackSample struct {
+	*webrtc.TrackLocalStaticRTP
+
+	mu         sync.Mutex
+	packetizer rtp.Packetizer
+	clockRate  float64
+}
+
+func newH265TrackSample(capability webrtc.RTPCodecCapability, id, streamID string) (*h265TrackSample, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(capability, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &h265TrackSample{
+		TrackLocalStaticRTP: track,
+		// Payload type and SSRC are rewritten by TrackLocalStaticRTP for each binding.
+		packetizer: rtp.NewPacketizer(rtpOutboundMTU, 0, 0, &h265Payloader{}, rtp.NewRandomSequencer(), capability.ClockRate),
+		clockRate:  float64(capability.ClockRate),
+	}, nil
+}
+
+// WriteSample packetizes an Annex-B H.265 access unit and writes RTP packets to all bindings.
+func (t *h265TrackSample) WriteSample(sample media.Sample) error {
+	t.mu.Lock()
+	packets := t.packetizer.Packetize(sample.Data, uint32(sample.Duration.Seconds()*t.clockRate))
+	t.mu.Unlock()
+
+	var errs []error
+	for _, p := range packets {
+		if err := t.WriteRTP(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// h265Payloader payloads Annex-B H.265 NAL units to RTP payloads.
+// NAL units which don't fit in MTU are sent as fragmentation units, aggregation packets are not used.
+// See: https://datatracker.ietf.org/doc/html/rfc7798#section-4.4
+type h265Payloader struct{}
+
+func (p *h265Payloader) Payload(mtu uint16, payload []byte) [][]byte {
+	var payloads [][]byte
+	for _, nalu := range splitAnnexB(payload) {
+		if len(nalu) <= h265NALUHeaderSize {
+			continue
+		}
+		if len(nalu) <= int(mtu) {
+			payloads = append(payloads, append([]byte{}, nalu...))
+			continue
+		}
+
+		maxFragmentSize := int(mtu) - h265NALUHeaderSize - h265FUHeaderSize
+		if maxFragmentSize <= 0 {
+			return payloads
+		}
+		naluType := (nalu[0] >> 1) & 0x3f
+		// Payload header keeps F, LayerId and TID of NAL unit header with type FU.
+		payloadHeader := []byte{(nalu[0] & 0x81) | (h265NALUTypeFU << 1), nalu[1]}
+		data := nalu[h265NALUHeaderSize:]
+		for start := true; len(data) > 0; start = false {
+			size := maxFragmentSize
+			if len(data) < size {
+				size = len(data)
+			}
+			fuHeader := naluType
+			if start {
+				fuHeader |= 0x80
+			}
+			if size == len(data) {
+				fuHeader |= 0x40
+			}
+			out := make([]byte, 0, h265NALUHeaderSize+h265FUHeaderSize+size)
+			out = append(out, payloadHeader...)
+			out = append(out, fuHeader)
+			out = append(out, data[:size]...)
+			payloads = append(payloads, out)
+			data = data[size:]
+		}
+	}
+	return payloads
+}
+
+// splitAnnexB splits an Annex-B byte stream into NAL units without start codes.
+func splitAnnexB(b []byte) [][]byte {
+	startCode := []byte{0x00, 0x00, 0x01}
+
+	var nalus [][]byte
+	start := bytes.Index(b, startCode)
+	if start < 0 {
+		return [][]byte{b}
+	}
+	start += len(startCode)
+	for {
+		next := bytes.Index(b[start:], startCode)
+		if next < 0 {
+			nalus = append(nalus, b[start:])
+			return nalus
+		}
+		end := start + next
+		// Zero byte before a 3 bytes start code belongs to a 4 bytes start code.
+		nalu := bytes.TrimRight(b[start:end], "\x00")
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+		start = end + len(startCode)
+	}
+}
@@ -0,0 +1,70 @@
+package livestream
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitAnnexB(t *testing.T) {
+	stream := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x40, 0x01, 0x0c, // VPS with 4 bytes start code.
+		0x00, 0x00, 0x01, 0x42, 0x01, 0x01, // SPS with 3 bytes start code.
+		0x00, 0x00, 0x00, 0x01, 0x26, 0x01, 0xaf, // IDR.
+	}
+	nalus := splitAnnexB(stream)
+	want := [][]byte{{0x40, 0x01, 0x0c}, {0x42, 0x01, 0x01}, {0x26, 0x01, 0xaf}}
+	if len(nalus) != len(want) {
+		t.Fatalf("got %d NAL units, want %d", len(nalus), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(nalus[i], want[i]) {
+			t.Errorf("NAL unit %d: got %x, want %x", i, nalus[i], want[i])
+		}
+	}
+
+	// A NAL unit without start code is returned as it is.
+	if nalus := splitAnnexB([]byte{0x02, 0x01, 0xd0}); len(nalus) != 1 || len(nalus[0]) != 3 {
+		t.Errorf("got %x, want a single NAL unit", nalus)
+	}
+}
+
+func TestH265Payloader(t *testing.T) {
+	const mtu = 100
+
+	small := []byte{0x02, 0x01, 0xd0, 0x01}
+	large := append([]byte{0x26, 0x01}, bytes.Repeat([]byte{0xab}, 250)...) // IDR_W_RADL.
+	stream := append(append([]byte{0x00, 0x00, 0x00, 0x01}, small...), append([]byte{0x00, 0x00, 0x01}, large...)...)
+
+	payloads := (&h265Payloader{}).Payload(mtu, stream)
+	if !bytes.Equal(payloads[0], small) {
+		t.Fatalf("small NAL unit should be sent as a single NAL unit packet, got %x", payloads[0])
+	}
+
+	fragments := payloads[1:]
+	if len(fragments) != 3 {
+		t.Fatalf("got %d fragments, want 3", len(fragments))
+	}
+	var data []byte
+	for i, fu := range fragments {
+		if len(fu) > mtu {
+			t.Errorf("fragment %d exceeds MTU: %d", i, len(fu))
+		}
+		if naluType := (fu[0] >> 1) & 0x3f; naluType != h265NALUTypeFU {
+			t.Errorf("fragment %d: got payload header type %d, want %d", i, naluType, h265NALUTypeFU)
+		}
+		fuHeader := fu[2]
+		if start := fuHeader&0x80 != 0; start != (i == 0) {
+			t.Errorf("fragment %d: unexpected start bit", i)
+		}
+		if end := fuHeader&0x40 != 0; end != (i == len(fragments)-1) {
+			t.Errorf("fragment %d: unexpected end bit", i)
+		}
+		if naluType := fuHeader & 0x3f; naluType != 19 {
+			t.Errorf("fragment %d: got FU type %d, want 19", i, naluType)
+		}
+		data = append(data, fu[3:]...)
+	}
+	if !bytes.Equal(data, large[2:]) {
+		t.Error("reassembled fragments don't match NAL unit payload")
+	}
+}
@@ -2,6 +2,7 @@ package livestream
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 
 	pb "github.com/SB-IM/charoite/internal/pb/signal"
@@ -16,35 +17,60 @@ type Livestream interface {
 	Meta() *pb.Meta
 }
 
+// NewPublisher returns a publisher of stream source by its track source.
+// It's used for stream sources configured in [[streams]] list.
+func NewPublisher(ctx context.Context, configOptions *PublisherConfigOptions) (Livestream, error) {
+	switch configOptions.TrackSource {
+	case pb.TrackSource_DRONE:
+		return NewDronePublisher(ctx, configOptions), nil
+	case pb.TrackSource_MONITOR:
+		return NewDeportPublisher(ctx, configOptions), nil
+	default:
+		return nil, fmt.Errorf("unsupported track source: %s", configOptions.TrackSource)
+	}
+}
+
 func NewDronePublisher(ctx context.Context, configOptions *PublisherConfigOptions) Livestream {
 	publisher := &publisher{
+		ctx: ctx,
 		meta: &pb.Meta{
 			Id:          configOptions.UUID,
 			TrackSource: pb.TrackSource_DRONE,
+			Stream:      configOptions.Name,
 		},
 		config: broadcastConfigOptions{
 			configOptions.MQTTClientConfigOptions,
 			configOptions.WebRTCConfigOptions,
 			configOptions.ConsumeStreamOnDemand,
+			configOptions.VideoCodec,
+			rtpAudioCodec(&configOptions.StreamSource),
 		},
-		client:      mqttclient.FromContext(ctx),
-		createTrack: videoTrackRTP,
+		client:           mqttclient.FromContext(ctx),
+		signer:           configOptions.Signer,
+		createTrack:      videoTrackRTP,
+		createAudioTrack: audioTrackRTP,
 		streamSource: func() string {
 			return configOptions.Host + ":" + strconv.Itoa(configOptions.Port)
 		},
-		liveStream: rtpListener,
+		liveStream: rtpListener(rtpAudioAddress(&configOptions.StreamSource)),
 		logger:     *log.Ctx(ctx),
 	}
+	publisher.uplink = new(uplink)
+	publisher.bitrateHook = newBitrateHook(configOptions, publisher.uplink, &publisher.logger)
 
 	switch configOptions.Protocol {
 	case protocolRTSP:
+		publisher.config.AudioCodec = configOptions.AudioCodec
 		publisher.createTrack = videoTrackSample
+		publisher.createAudioTrack = audioTrackSample
 		publisher.streamSource = func() string {
 			return configOptions.Addr
 		}
 		publisher.liveStream = consumeRTSP
 	case protocolRTMP:
+		publisher.config.AudioCodec = configOptions.AudioCodec
 		publisher.createTrack = videoTrackSample
+		publisher.createAudioTrack = audioTrackSample
 		publisher.streamSource = func() string {
 			return configOptions.Host + ":" + strconv.Itoa(configOptions.Port)
 		}
@@ -59,32 +85,66 @@ func NewDronePublisher(ctx context.Context, configOptions *PublisherConfigOption
 func NewDeportPublisher(ctx context.Context, configOptions *PublisherConfigOptions) Livestream {
 	// Default deport stream source is rtsp.
 	publisher := &publisher{
+		ctx: ctx,
 		meta: &pb.Meta{
 			Id:          configOptions.UUID,
 			TrackSource: pb.TrackSource_MONITOR,
+			Stream:      configOptions.Name,
 		},
 		config: broadcastConfigOptions{
 			configOptions.MQTTClientConfigOptions,
 			configOptions.WebRTCConfigOptions,
 			configOptions.ConsumeStreamOnDemand,
+			configOptions.VideoCodec,
+			configOptions.AudioCodec,
 		},
-		client:      mqttclient.FromContext(ctx),
-		createTrack: videoTrackSample,
+		client:           mqttclient.FromContext(ctx),
+		signer:           configOptions.Signer,
+		createTrack:      videoTrackSample,
+		createAudioTrack: audioTrackSample,
 		streamSource: func() string {
 			return configOptions.Addr
 		},
 		liveStream: consumeRTSP,
 		logger:     *log.Ctx(ctx),
 	}
+	publisher.uplink = new(uplink)
+	publisher.bitrateHook = newBitrateHook(configOptions, publisher.uplink, &publisher.logger)
 
-	// If it's rtp stream source.
-	if configOptions.Protocol == protocolRTP {
+	switch configOptions.Protocol {
+	case protocolRTP:
+		publisher.config.AudioCodec = rtpAudioCodec(&configOptions.StreamSource)
 		publisher.createTrack = videoTrackRTP
+		publisher.createAudioTrack = audioTrackRTP
+		publisher.streamSource = func() string {
+			return configOptions.Host + ":" + strconv.Itoa(configOptions.Port)
+		}
+		publisher.liveStream = rtpListener(rtpAudioAddress(&configOptions.StreamSource))
+	case protocolRTMP:
 		publisher.streamSource = func() string {
 			return configOptions.Host + ":" + strconv.Itoa(configOptions.Port)
 		}
-		publisher.liveStream = rtpListener
+		publisher.liveStream = consumeRTMP
+	default:
+		// Default is rtsp.
 	}
 
 	return publisher
 }
+
+// rtpAudioCodec returns audio codec of a RTP stream source.
+// Audio is disabled if there is no RTP audio port.
+func rtpAudioCodec(source *StreamSource) string {
+	if source.AudioPort == 0 {
+		return ""
+	}
+	return source.AudioCodec
+}
+
+// rtpAudioAddress returns address of RTP audio listener, it's empty if audio is disabled.
+func rtpAudioAddress(source *StreamSource) string {
+	if rtpAudioCodec(source) == "" {
+		return ""
+	}
+	return source.Host + ":" + strconv.Itoa(source.AudioPort)
+}
@@ -0,0 +1,37 @@
+package livestream
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	signalingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "charoite",
+		Subsystem: "livestream",
+		Name:      "mqtt_signaling_duration_seconds",
+		Help:      "Time from sending an offer to receiving its answer over MQTT.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"track_source", "stream"})
+
+	iceStateTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "livestream",
+		Name:      "ice_state_transitions_total",
+		Help:      "Number of ICE connection state transitions of publisher peer connections.",
+	}, []string{"track_source", "stream", "state"})
+
+	uplinkBitrateEstimate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "charoite",
+		Subsystem: "livestream",
+		Name:      "uplink_bitrate_estimate_bits_per_second",
+		Help:      "Estimated bitrate uplink of publisher affords.",
+	}, []string{"track_source", "stream"})
+
+	uplinkFractionLost = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "charoite",
+		Subsystem: "livestream",
+		Name:      "uplink_fraction_lost",
+		Help:      "Fraction of video packets lost on uplink of publisher, reported by receiver.",
+	}, []string{"track_source", "stream"})
+)
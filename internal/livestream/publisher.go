@@ -10,8 +10,12 @@ import (
 	"time"
 
 	pb "github.com/SB-IM/charoite/internal/pb/signal"
+	"github.com/SB-IM/charoite/pkg/webrtcapi"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/randutil"
+	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog"
 )
@@ -20,20 +24,41 @@ const (
 	signalTimeout = 3 * time.Second
 )
 
+// liveStreamFunc blocks indefinitely if there no error.
+// It should listens to ctx.Done, and exit when done.
+type liveStreamFunc func(ctx context.Context, address string, tracks *localTracks, logger *zerolog.Logger) error
+
+// localTracks are tracks a live stream writes to.
+type localTracks struct {
+	video webrtc.TrackLocal
+	audio webrtc.TrackLocal // Nil if audio is disabled.
+}
+
 // publisher implements Livestream interface.
 type publisher struct {
+	// ctx is cancelled on shutdown, publisher stops live streaming and closes peer connection.
+	ctx context.Context
+
 	// meta contains id and track source of this publisher.
 	meta *pb.Meta
 
 	config broadcastConfigOptions
 	client mqtt.Client
+	signer *pb.Signer // Nil if signaling payloads are not signed.
+
+	createTrack      func(codec string) (webrtc.TrackLocal, error)
+	createAudioTrack func(codec string) (webrtc.TrackLocal, error)
+	streamSource     func() string
+
+	liveStream liveStreamFunc
 
-	createTrack  func() (webrtc.TrackLocal, error)
-	streamSource func() string
+	// uplink drops frames and counts bytes sent for peer connections, bitrateHook reacts to its estimates, it may be nil.
+	uplink      *uplink
+	bitrateHook BitrateHook
 
-	// liveStream blocks indefinitely if there no error.
-	// It should listens to ctx.Done, and exit when done.
-	liveStream func(ctx context.Context, address string, videoTrack webrtc.TrackLocal, logger *zerolog.Logger) error
+	// peerConnection is the current peer connection, it's renewed after ICE connection is closed.
+	peerConnection *webrtc.PeerConnection
+	pcMux          sync.Mutex
 
 	pendingCandidates []*webrtc.ICECandidate
 	candidatesMux     sync.Mutex
@@ -45,27 +70,29 @@ type publisher struct {
 }
 
 func (p *publisher) Publish() error {
-	p.logger = p.logger.With().Str("id", p.meta.Id).Int32("track_source", int32(p.meta.TrackSource)).Logger()
+	p.logger = p.logger.With().Str("id", p.meta.Id).Int32("track_source", int32(p.meta.TrackSource)).Str("stream", p.meta.Stream).Logger()
 	p.logger.Info().Msg("publishing stream")
 
-	videoTrack, err := p.createTrack()
+	tracks, err := p.createTracks()
 	if err != nil {
 		return err
 	}
-	p.logger.Info().Msg("created video track")
 
-	if err := p.createPeerConnection(videoTrack); err != nil {
+	if err := p.createPeerConnection(tracks); err != nil {
 		return fmt.Errorf("failed to create PeerConnection: %w", err)
 	}
 	p.logger.Info().Msg("created PeerConnection")
+	defer p.close()
 
 	p.logger.Info().Bool("consume_stream_on_demand", p.config.ConsumeStreamOnDemand).Send()
 	if p.config.ConsumeStreamOnDemand {
-		if err := <-p.listenSubscriber(videoTrack); err != nil {
+		select {
+		case err := <-p.listenSubscriber(tracks):
 			return fmt.Errorf("listening subscriber failed: %w", err)
+		case <-p.ctx.Done():
 		}
 	} else {
-		if err := p.liveStream(context.Background(), p.streamSource(), videoTrack, &p.logger); err != nil {
+		if err := p.liveStream(p.ctx, p.streamSource(), tracks, &p.logger); err != nil {
 			p.logger.Err(err).Msg("live stream failed")
 			return fmt.Errorf("live stream failed: %w", err)
 		}
@@ -75,15 +102,60 @@ func (p *publisher) Publish() error {
 	return nil
 }
 
+// close unsubscribes MQTT topics and closes current peer connection.
+func (p *publisher) close() {
+	p.unsubscribe()
+
+	p.pcMux.Lock()
+	defer p.pcMux.Unlock()
+	if p.peerConnection == nil || p.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+	if err := closePeerConnection(p.peerConnection); err != nil {
+		p.logger.Err(err).Msg("could not close PeerConnection")
+		return
+	}
+	p.logger.Info().Msg("closed PeerConnection")
+}
+
 func (p *publisher) Meta() *pb.Meta {
 	return p.meta
 }
 
-func (p *publisher) createPeerConnection(videoTrack webrtc.TrackLocal) error {
+// createTracks creates a video track, and an audio track if audio is enabled.
+func (p *publisher) createTracks() (*localTracks, error) {
+	videoTrack, err := p.createTrack(p.config.VideoCodec)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Info().Str("video_codec", p.config.VideoCodec).Msg("created video track")
+
+	tracks := &localTracks{video: videoTrack}
+	if p.config.AudioCodec == "" {
+		return tracks, nil
+	}
+
+	if tracks.audio, err = p.createAudioTrack(p.config.AudioCodec); err != nil {
+		return nil, err
+	}
+	p.logger.Info().Str("audio_codec", p.config.AudioCodec).Msg("created audio track")
+
+	return tracks, nil
+}
+
+func (p *publisher) createPeerConnection(tracks *localTracks) error {
 	answerChan := p.recvAnswer()
 	candidateChan := p.recvCandidate()
 
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
+	var estimator cc.BandwidthEstimator
+	api, err := webrtcapi.New(
+		webrtcapi.WithBandwidthEstimator(initialBitrateEstimate, func(e cc.BandwidthEstimator) { estimator = e }),
+		webrtcapi.WithInterceptor(p.uplink),
+	)
+	if err != nil {
+		return err
+	}
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
 			{
 				URLs:       []string{p.config.ICEServer},
@@ -95,12 +167,27 @@ func (p *publisher) createPeerConnection(videoTrack webrtc.TrackLocal) error {
 	if err != nil {
 		return fmt.Errorf("could not create PeerConnection: %w", err)
 	}
-
-	rtpSender, err := peerConnection.AddTrack(videoTrack)
-	if err != nil {
-		return fmt.Errorf("could not add track to PeerConnection: %w", err)
+	p.pcMux.Lock()
+	p.peerConnection = peerConnection
+	p.pcMux.Unlock()
+
+	stats := &uplinkStats{}
+	var videoSender *webrtc.RTPSender
+	for _, track := range []webrtc.TrackLocal{tracks.video, tracks.audio} {
+		if track == nil {
+			continue
+		}
+		rtpSender, err := peerConnection.AddTrack(track)
+		if err != nil {
+			return fmt.Errorf("could not add track to PeerConnection: %w", err)
+		}
+		if track == tracks.video {
+			videoSender = rtpSender
+			go p.processRTCP(rtpSender, stats)
+		} else {
+			go p.processRTCP(rtpSender, nil)
+		}
 	}
-	go p.processRTCP(rtpSender)
 
 	peerConnection.OnICECandidate(func(c *webrtc.ICECandidate) {
 		if c == nil {
@@ -123,7 +210,7 @@ func (p *publisher) createPeerConnection(videoTrack webrtc.TrackLocal) error {
 
 	// Set the handler for ICE connection state
 	// This will notify you when the peer has connected/disconnected
-	peerConnection.OnICEConnectionStateChange(p.handleICEConnectionStateChange(peerConnection, videoTrack))
+	peerConnection.OnICEConnectionStateChange(p.handleICEConnectionStateChange(peerConnection, tracks))
 
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
@@ -138,16 +225,19 @@ func (p *publisher) createPeerConnection(videoTrack webrtc.TrackLocal) error {
 		return fmt.Errorf("could not send offer: %w", err)
 	}
 	p.logger.Info().Msg("sent local description offer")
+	sentAt := time.Now()
 
 	// Receiving answer.
 	timer := time.NewTimer(signalTimeout)
 	defer timer.Stop()
 	select {
 	case answer := <-answerChan:
+		signalingDuration.WithLabelValues(p.meta.TrackSource.String(), p.meta.Stream).Observe(time.Since(sentAt).Seconds())
 		if err := peerConnection.SetRemoteDescription(*answer); err != nil {
 			p.logger.Err(err).Msg("could not set remote description")
 		}
 		p.logger.Info().Msg("set remote description")
+		go p.monitorUplink(peerConnection, videoSender, estimator, stats)
 	case <-timer.C:
 		p.logger.Warn().Dur("timeout", signalTimeout).Msg("timed out receiving answer")
 
@@ -177,9 +267,10 @@ func (p *publisher) createPeerConnection(videoTrack webrtc.TrackLocal) error {
 	return nil
 }
 
-func (p *publisher) handleICEConnectionStateChange(peerConnection *webrtc.PeerConnection, videoTrack webrtc.TrackLocal) func(connectionState webrtc.ICEConnectionState) {
+func (p *publisher) handleICEConnectionStateChange(peerConnection *webrtc.PeerConnection, tracks *localTracks) func(connectionState webrtc.ICEConnectionState) {
 	return func(connectionState webrtc.ICEConnectionState) {
 		p.logger.Info().Str("state", connectionState.String()).Msg("connection state has changed")
+		iceStateTransitions.WithLabelValues(p.meta.TrackSource.String(), p.meta.Stream, connectionState.String()).Inc()
 
 		if connectionState == webrtc.ICEConnectionStateFailed {
 			if err := closePeerConnection(peerConnection); err != nil {
@@ -190,11 +281,16 @@ func (p *publisher) handleICEConnectionStateChange(peerConnection *webrtc.PeerCo
 		if connectionState == webrtc.ICEConnectionStateClosed {
 			// Retry creating peer connection only when network is ok.
 			for {
+				// Peer connection is closed on shutdown, don't retry.
+				if p.ctx.Err() != nil {
+					return
+				}
+
 				if !p.client.IsConnected() || !p.client.IsConnectionOpen() {
 					continue
 				}
 
-				if err := p.createPeerConnection(videoTrack); err != nil {
+				if err := p.createPeerConnection(tracks); err != nil {
 					p.logger.Err(err).Msg("failed to create peer connection after retrying")
 					continue
 				}
@@ -206,7 +302,7 @@ func (p *publisher) handleICEConnectionStateChange(peerConnection *webrtc.PeerCo
 		if connectionState == webrtc.ICEConnectionStateDisconnected {
 			p.stateMux.Lock()
 			p.isLivestreamStarted = false
-			p.candidatesMux.Unlock()
+			p.stateMux.Unlock()
 		}
 	}
 }
@@ -248,9 +344,11 @@ func closePeerConnection(peerConnection *webrtc.PeerConnection) error {
 // processRTCP reads incoming RTCP packets
 // Before these packets are returned they are processed by interceptors.
 // For things like NACK this needs to be called.
-func (p *publisher) processRTCP(rtpSender *webrtc.RTPSender) {
+// Reception reports and REMBs of video are added to stats, stats is nil for audio.
+func (p *publisher) processRTCP(rtpSender *webrtc.RTPSender, stats *uplinkStats) {
 	for {
-		if _, _, rtcpErr := rtpSender.ReadRTCP(); rtcpErr != nil {
+		packets, _, rtcpErr := rtpSender.ReadRTCP()
+		if rtcpErr != nil {
 			if errors.Is(rtcpErr, io.EOF) || errors.Is(rtcpErr, io.ErrClosedPipe) {
 				_ = rtpSender.Stop()
 			} else {
@@ -258,14 +356,87 @@ func (p *publisher) processRTCP(rtpSender *webrtc.RTPSender) {
 			}
 			return
 		}
+		if stats == nil {
+			continue
+		}
+		now := time.Now()
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.ReceiverReport:
+				for _, report := range packet.Reports {
+					if encodings := rtpSender.GetParameters().Encodings; len(encodings) != 0 && report.SSRC == uint32(encodings[0].SSRC) {
+						stats.addReport(report, now)
+					}
+				}
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				stats.addREMB(packet, now)
+			}
+		}
+	}
+}
+
+// monitorUplink makes a bitrate estimate of uplink every second until peer connection is closed,
+// it's reported to bitrate hook, and logged with loss and round trip time periodically.
+// Transport-wide congestion control feedback is preferred, REMB is used if receiver doesn't support it.
+func (p *publisher) monitorUplink(peerConnection *webrtc.PeerConnection, videoSender *webrtc.RTPSender, estimator cc.BandwidthEstimator, stats *uplinkStats) {
+	twcc := false
+	if videoSender != nil && estimator != nil {
+		for _, ext := range videoSender.GetParameters().HeaderExtensions {
+			if ext.URI == sdp.TransportCCURI {
+				twcc = true
+			}
+		}
+	}
+	p.logger.Info().Bool("twcc", twcc).Msg("monitoring uplink bandwidth")
+
+	ticker := time.NewTicker(bitrateEstimateInterval)
+	defer ticker.Stop()
+	sent, sentAt := p.uplink.sent.Load(), time.Now()
+	var loggedAt time.Time
+	for {
+		select {
+		case now := <-ticker.C:
+			if peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				return
+			}
+			total := p.uplink.sent.Load()
+			sendBitrate := int(float64(total-sent) * 8 / now.Sub(sentAt).Seconds())
+			sent, sentAt = total, now
+
+			bitrate := 0
+			if twcc {
+				bitrate = estimator.GetTargetBitrate()
+			}
+			e := stats.estimate(bitrate, twcc, sendBitrate, now)
+			uplinkBitrateEstimate.WithLabelValues(p.meta.TrackSource.String(), p.meta.Stream).Set(float64(e.Bitrate))
+			uplinkFractionLost.WithLabelValues(p.meta.TrackSource.String(), p.meta.Stream).Set(e.FractionLost)
+			if now.Sub(loggedAt) >= bitrateLogInterval {
+				loggedAt = now
+				p.logger.Info().
+					Int("bitrate", e.Bitrate).
+					Int("send_bitrate", e.SendBitrate).
+					Float64("fraction_lost", e.FractionLost).
+					Dur("rtt", e.RTT).
+					Bool("dropping_frames", p.uplink.dropping.Load()).
+					Msg("uplink bitrate estimate")
+			}
+			if p.bitrateHook != nil {
+				p.bitrateHook.OnBitrateEstimate(e)
+			}
+		case <-p.ctx.Done():
+			return
+		}
 	}
 }
 
-// videoTrackRTP creates a RTP video track.
-// The default MIME type is H.264
-func videoTrackRTP() (webrtc.TrackLocal, error) {
+// videoTrackRTP creates a RTP video track of given codec.
+func videoTrackRTP(codec string) (webrtc.TrackLocal, error) {
+	capability, err := videoCodecCapability(codec)
+	if err != nil {
+		return nil, err
+	}
 	videoTrack, err := webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+		capability,
 		fmt.Sprintf("video-%d", randutil.NewMathRandomGenerator().Uint32()),
 		fmt.Sprintf("edge-%d", randutil.NewMathRandomGenerator().Uint32()),
 	)
@@ -275,11 +446,22 @@ func videoTrackRTP() (webrtc.TrackLocal, error) {
 	return videoTrack, nil
 }
 
-// videoTrackSample creates a sample video track.
-// The default MIME type is H.264
-func videoTrackSample() (webrtc.TrackLocal, error) {
+// videoTrackSample creates a sample video track of given codec.
+// Pion has no H.265 payloader, so H.265 samples are packetized by h265TrackSample.
+func videoTrackSample(codec string) (webrtc.TrackLocal, error) {
+	capability, err := videoCodecCapability(codec)
+	if err != nil {
+		return nil, err
+	}
+	if codec == videoCodecH265 {
+		return newH265TrackSample(
+			capability,
+			fmt.Sprintf("video-%d", randutil.NewMathRandomGenerator().Uint32()),
+			fmt.Sprintf("edge-%d", randutil.NewMathRandomGenerator().Uint32()),
+		)
+	}
 	videoTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264},
+		capability,
 		fmt.Sprintf("video-%d", randutil.NewMathRandomGenerator().Uint32()),
 		fmt.Sprintf("edge-%d", randutil.NewMathRandomGenerator().Uint32()),
 	)
@@ -289,16 +471,80 @@ func videoTrackSample() (webrtc.TrackLocal, error) {
 	return videoTrack, nil
 }
 
+// audioTrackRTP creates a RTP audio track of given codec.
+func audioTrackRTP(codec string) (webrtc.TrackLocal, error) {
+	capability, err := audioCodecCapability(codec)
+	if err != nil {
+		return nil, err
+	}
+	audioTrack, err := webrtc.NewTrackLocalStaticRTP(
+		capability,
+		fmt.Sprintf("audio-%d", randutil.NewMathRandomGenerator().Uint32()),
+		fmt.Sprintf("edge-%d", randutil.NewMathRandomGenerator().Uint32()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create TrackLocalStaticRTP: %w", err)
+	}
+	return audioTrack, nil
+}
+
+// audioTrackSample creates a sample audio track of given codec.
+func audioTrackSample(codec string) (webrtc.TrackLocal, error) {
+	capability, err := audioCodecCapability(codec)
+	if err != nil {
+		return nil, err
+	}
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(
+		capability,
+		fmt.Sprintf("audio-%d", randutil.NewMathRandomGenerator().Uint32()),
+		fmt.Sprintf("edge-%d", randutil.NewMathRandomGenerator().Uint32()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create TrackLocalStaticSample: %w", err)
+	}
+	return audioTrack, nil
+}
+
+// videoCodecCapability maps a configured video codec to its webRTC codec capability.
+func videoCodecCapability(codec string) (webrtc.RTPCodecCapability, error) {
+	switch codec {
+	case videoCodecH264:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, nil
+	case videoCodecH265:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH265, ClockRate: 90000}, nil
+	case videoCodecVP8:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, nil
+	case videoCodecVP9:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}, nil
+	default:
+		return webrtc.RTPCodecCapability{}, fmt.Errorf("unsupported video codec: %s", codec)
+	}
+}
+
+// audioCodecCapability maps a configured audio codec to its webRTC codec capability.
+func audioCodecCapability(codec string) (webrtc.RTPCodecCapability, error) {
+	switch codec {
+	case audioCodecOpus:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, nil
+	case audioCodecPCMU:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000}, nil
+	case audioCodecPCMA:
+		return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000}, nil
+	default:
+		return webrtc.RTPCodecCapability{}, fmt.Errorf("unsupported audio codec: %s", codec)
+	}
+}
+
 // emptyPendingCandidate is called after all ICE candidates were sent to release resources.
 func (p *publisher) emptyPendingCandidate() {
 	p.pendingCandidates = p.pendingCandidates[:0]
 }
 
-func (p *publisher) listenSubscriber(videoTrack webrtc.TrackLocal) <-chan error {
+func (p *publisher) listenSubscriber(tracks *localTracks) <-chan error {
 	// Ctx should be renewed on every canceling.
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(p.ctx)
 	errChan := make(chan error, 1)
-	topic := p.config.NotifyStreamTopicPrefix + "/" + p.meta.Id + "/" + strconv.Itoa(int(p.meta.TrackSource))
+	topic := p.config.NotifyStreamTopicPrefix + pb.TopicSuffix(p.meta)
 
 	p.client.Subscribe(topic, byte(p.config.Qos), func(_ mqtt.Client, m mqtt.Message) {
 		p.stateMux.Lock()
@@ -315,7 +561,7 @@ func (p *publisher) listenSubscriber(videoTrack webrtc.TrackLocal) <-chan error
 			cancel()
 			p.logger.Info().Msg("cancel living stream now")
 
-			ctx, cancel = context.WithCancel(context.Background())
+			ctx, cancel = context.WithCancel(p.ctx)
 			p.isLivestreamStarted = false
 
 			return
@@ -327,7 +573,7 @@ func (p *publisher) listenSubscriber(videoTrack webrtc.TrackLocal) <-chan error
 		}
 
 		go func() {
-			if err := p.liveStream(ctx, p.streamSource(), videoTrack, &p.logger); err != nil {
+			if err := p.liveStream(ctx, p.streamSource(), tracks, &p.logger); err != nil {
 				p.logger.Err(err).Msg("live stream failed")
 				errChan <- fmt.Errorf("live stream failed: %w", err)
 
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/pion/webrtc/v3"
@@ -23,9 +24,18 @@ const (
 	headerLengthField = 4
 	spsId             = 0x67
 	ppsId             = 0x68
+
+	g711SampleRate = 8000
+
+	// codecIDHEVC is the de-facto FLV codec id of HEVC which is not in FLV specification.
+	// Its video packet has the same layout as AVC, with HEVCDecoderConfigurationRecord as sequence header.
+	codecIDHEVC flvtag.CodecID = 12
+
+	// hvcCArraysOffset is offset of numOfArrays in HEVCDecoderConfigurationRecord.
+	hvcCArraysOffset = 22
 )
 
-func consumeRTMP(ctx context.Context, address string, videoTrack webrtc.TrackLocal, logger *zerolog.Logger) error {
+func consumeRTMP(ctx context.Context, address string, tracks *localTracks, logger *zerolog.Logger) error {
 	l, err := net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to listen tcp at %s: %w", address, err)
@@ -40,7 +50,8 @@ func consumeRTMP(ctx context.Context, address string, videoTrack webrtc.TrackLoc
 		OnConnect: func(conn net.Conn) (io.ReadWriteCloser, *rtmp.ConnConfig) {
 			return conn, &rtmp.ConnConfig{
 				Handler: &handler{
-					videoTrack: videoTrack,
+					videoTrack: tracks.video.(sampleTrack),
+					audioTrack: tracks.audio,
 					logger:     logger,
 				},
 				ControlState: rtmp.StreamControlStateConfig{
@@ -56,19 +67,39 @@ func consumeRTMP(ctx context.Context, address string, videoTrack webrtc.TrackLoc
 		}
 	}()
 
+	// Closing server unblocks Serve.
+	go func() {
+		<-ctx.Done()
+		if err := s.Close(); err != nil {
+			logger.Err(err).Msg("could not close rtmp server")
+		}
+	}()
+
 	logger.Info().Str("address", address).Msg("starting rtmp server")
 
-	return s.Serve(l)
+	if err := s.Serve(l); err != nil && ctx.Err() == nil {
+		return err
+	}
+	logger.Info().Str("err", ctx.Err().Error()).Msg("context is done, exiting live streaming")
+	return nil
 }
 
 type handler struct {
 	rtmp.DefaultHandler
 
-	videoTrack webrtc.TrackLocal
+	videoTrack sampleTrack
+	audioTrack webrtc.TrackLocal // Nil if audio is disabled.
+
+	// unsupportedVideo and unsupportedAudio are set after warning about unsupported codec once.
+	unsupportedVideo bool
+	unsupportedAudio bool
 
 	sps []byte
 	pps []byte
 
+	// hevcParameterSets are Annex-B VPS, SPS and PPS of HEVC stream.
+	hevcParameterSets []byte
+
 	logger *zerolog.Logger
 }
 
@@ -98,6 +129,28 @@ func (h *handler) OnVideo(timestamp uint32, payload io.Reader) error {
 		return err
 	}
 
+	var mimeType string
+	switch video.CodecID {
+	case flvtag.CodecIDAVC:
+		mimeType = webrtc.MimeTypeH264
+	case codecIDHEVC:
+		mimeType = webrtc.MimeTypeH265
+	default:
+	}
+	if !strings.EqualFold(mimeType, h.videoTrack.Codec().MimeType) {
+		if !h.unsupportedVideo {
+			h.logger.Warn().
+				Uint8("codec_id", uint8(video.CodecID)).
+				Str("mime_type", h.videoTrack.Codec().MimeType).
+				Msg("video codec doesn't match video track, dropping video")
+			h.unsupportedVideo = true
+		}
+		return nil
+	}
+	if video.CodecID == codecIDHEVC {
+		return h.onHEVC(&video)
+	}
+
 	data := new(bytes.Buffer)
 	if _, err := io.Copy(data, video.Data); err != nil {
 		return err
@@ -168,12 +221,146 @@ func (h *handler) OnVideo(timestamp uint32, payload io.Reader) error {
 		outBuf = append(append(h.sps, h.pps...), outBuf...)
 	}
 
-	return h.videoTrack.(*webrtc.TrackLocalStaticSample).WriteSample(media.Sample{
+	return h.videoTrack.WriteSample(media.Sample{
 		Data:     outBuf,
 		Duration: time.Second / 30,
 	})
 }
 
+// onHEVC writes HEVC video to video track.
+// Parameter sets of sequence header are pre-pended to key frames which don't carry them.
+func (h *handler) onHEVC(video *flvtag.VideoData) error {
+	var packet flvtag.AVCVideoPacket
+	if err := flvtag.DecodeAVCVideoPacket(video.Data, &packet); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(packet.Data)
+	if err != nil {
+		return err
+	}
+
+	switch packet.AVCPacketType {
+	case flvtag.AVCPacketTypeSequenceHeader:
+		parameterSets, err := parseHVCC(data)
+		if err != nil {
+			return err
+		}
+		h.hevcParameterSets = parameterSets
+		return nil
+	case flvtag.AVCPacketTypeNALU:
+	default:
+		h.logger.Warn().Uint8("AVCPacketType", uint8(packet.AVCPacketType)).Msg("unknown type")
+		return nil
+	}
+
+	var (
+		outBuf           []byte
+		hasParameterSets bool
+	)
+	for offset := 0; offset+headerLengthField <= len(data); {
+		naluLength := int(binary.BigEndian.Uint32(data[offset : offset+headerLengthField]))
+		offset += headerLengthField
+		if naluLength == 0 || offset+naluLength > len(data) {
+			break
+		}
+		nalu := data[offset : offset+naluLength]
+		offset += naluLength
+
+		switch (nalu[0] >> 1) & 0x3f {
+		case 32, 33, 34: // VPS, SPS and PPS.
+			hasParameterSets = true
+		}
+		outBuf = append(outBuf, annexBPrefix()...)
+		outBuf = append(outBuf, nalu...)
+	}
+
+	if video.FrameType == flvtag.FrameTypeKeyFrame && !hasParameterSets {
+		outBuf = append(append([]byte{}, h.hevcParameterSets...), outBuf...)
+	}
+
+	return h.videoTrack.WriteSample(media.Sample{
+		Data:     outBuf,
+		Duration: time.Second / 30,
+	})
+}
+
+// parseHVCC parses parameter sets of HEVCDecoderConfigurationRecord into Annex-B form.
+// See: ISO/IEC 14496-15 8.3.3.1
+func parseHVCC(record []byte) ([]byte, error) {
+	errMalformed := errors.New("malformed HEVCDecoderConfigurationRecord")
+	if len(record) <= hvcCArraysOffset {
+		return nil, errMalformed
+	}
+
+	var parameterSets []byte
+	numOfArrays := int(record[hvcCArraysOffset])
+	offset := hvcCArraysOffset + 1
+	for i := 0; i < numOfArrays; i++ {
+		if offset+3 > len(record) {
+			return nil, errMalformed
+		}
+		numNalus := int(binary.BigEndian.Uint16(record[offset+1 : offset+3])) // Skip NAL unit type.
+		offset += 3
+		for j := 0; j < numNalus; j++ {
+			if offset+2 > len(record) {
+				return nil, errMalformed
+			}
+			naluLength := int(binary.BigEndian.Uint16(record[offset : offset+2]))
+			offset += 2
+			if offset+naluLength > len(record) {
+				return nil, errMalformed
+			}
+			parameterSets = append(parameterSets, annexBPrefix()...)
+			parameterSets = append(parameterSets, record[offset:offset+naluLength]...)
+			offset += naluLength
+		}
+	}
+	return parameterSets, nil
+}
+
+// OnAudio writes G.711 audio to audio track, other sound formats need transcoding and are dropped.
+func (h *handler) OnAudio(timestamp uint32, payload io.Reader) error {
+	if h.audioTrack == nil {
+		return nil
+	}
+
+	var audio flvtag.AudioData
+	if err := flvtag.DecodeAudioData(payload, &audio); err != nil {
+		return err
+	}
+
+	audioTrack := h.audioTrack.(*webrtc.TrackLocalStaticSample)
+	var mimeType string
+	switch audio.SoundFormat {
+	case flvtag.SoundFormatG711muLawLogarithmicPCM:
+		mimeType = webrtc.MimeTypePCMU
+	case flvtag.SoundFormatG711ALawLogarithmicPCM:
+		mimeType = webrtc.MimeTypePCMA
+	default:
+	}
+	if !strings.EqualFold(mimeType, audioTrack.Codec().MimeType) {
+		if !h.unsupportedAudio {
+			h.logger.Warn().
+				Uint8("sound_format", uint8(audio.SoundFormat)).
+				Str("mime_type", audioTrack.Codec().MimeType).
+				Msg("sound format doesn't match audio track, dropping audio")
+			h.unsupportedAudio = true
+		}
+		return nil
+	}
+
+	data := new(bytes.Buffer)
+	if _, err := io.Copy(data, audio.Data); err != nil {
+		return err
+	}
+
+	// G.711 has one byte per sample.
+	return audioTrack.WriteSample(media.Sample{
+		Data:     data.Bytes(),
+		Duration: time.Duration(data.Len()) * time.Second / g711SampleRate,
+	})
+}
+
 func (h *handler) OnClose() {
 	h.logger.Info().Msg("closing client connection")
 }
@@ -7,12 +7,28 @@ import (
 
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
 )
 
-// rtpListener starts a UDP listener and consumes data stream.
-func rtpListener(ctx context.Context, address string, videoTrack webrtc.TrackLocal, logger *zerolog.Logger) error {
-	videoTrackSample := videoTrack.(*webrtc.TrackLocalStaticRTP)
+// rtpListener starts UDP listeners and consumes data stream.
+// Audio RTP stream is consumed from audioAddress, it's ignored if audioAddress is empty.
+func rtpListener(audioAddress string) liveStreamFunc {
+	return func(ctx context.Context, address string, tracks *localTracks, logger *zerolog.Logger) error {
+		g, ctx := errgroup.WithContext(ctx)
+		g.Go(func() error {
+			return consumeRTP(ctx, address, tracks.video.(*webrtc.TrackLocalStaticRTP), logger)
+		})
+		if tracks.audio != nil && audioAddress != "" {
+			g.Go(func() error {
+				return consumeRTP(ctx, audioAddress, tracks.audio.(*webrtc.TrackLocalStaticRTP), logger)
+			})
+		}
+		return g.Wait()
+	}
+}
 
+// consumeRTP starts a UDP listener and writes RTP packets to track.
+func consumeRTP(ctx context.Context, address string, track *webrtc.TrackLocalStaticRTP, logger *zerolog.Logger) error {
 	udpAddr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		return fmt.Errorf("could not resolve address of %s into udp address: %w", address, err)
@@ -23,24 +39,27 @@ func rtpListener(ctx context.Context, address string, videoTrack webrtc.TrackLoc
 		return fmt.Errorf("listen UDP: %w", err)
 	}
 	defer listener.Close()
-	logger.Info().Str("address", udpAddr.String()).Msg("UDP server started")
+	logger.Info().Str("address", udpAddr.String()).Str("kind", track.Kind().String()).Msg("UDP server started")
+
+	// Closing listener unblocks ReadFrom.
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
 
 	inboundRTPPacket := make([]byte, 1600) // UDP MTU
 	for {
 		n, _, err := listener.ReadFrom(inboundRTPPacket)
 		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info().Str("err", ctx.Err().Error()).Msg("context is done, exiting live streaming")
+				return nil
+			}
 			return fmt.Errorf("error during read: %w", err)
 		}
 
-		if _, err = videoTrackSample.Write(inboundRTPPacket[:n]); err != nil {
-			return fmt.Errorf("could not write videoTrackSample: %w", err)
-		}
-
-		select {
-		case <-ctx.Done():
-			logger.Info().Str("err", ctx.Err().Error()).Msg("context is done, exiting live streaming")
-			return nil
-		default:
+		if _, err = track.Write(inboundRTPPacket[:n]); err != nil {
+			return fmt.Errorf("could not write %s track: %w", track.Kind(), err)
 		}
 	}
 }
@@ -2,12 +2,15 @@ package livestream
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
 	"github.com/deepch/vdk/format/rtspv2"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
@@ -15,9 +18,10 @@ import (
 )
 
 // consumeRTSP connects to an RTSP URL and pulls media.
-// Convert H264 to Annex-B, then write to videoTrack which sends to all PeerConnections.
-func consumeRTSP(ctx context.Context, address string, videoTrack webrtc.TrackLocal, logger *zerolog.Logger) error {
-	videoTrackSample := videoTrack.(*webrtc.TrackLocalStaticSample)
+// Convert H264 or H265 to Annex-B, then write to videoTrack which sends to all PeerConnections.
+// Audio is written to audio track as it is if its codec matches, AAC audio is not supported because it needs transcoding.
+func consumeRTSP(ctx context.Context, address string, tracks *localTracks, logger *zerolog.Logger) error {
+	videoTrackSample := tracks.video.(sampleTrack)
 
 	annexbNALUStartCode := func() []byte { return []byte{0x00, 0x00, 0x00, 0x01} }
 
@@ -28,7 +32,7 @@ func consumeRTSP(ctx context.Context, address string, videoTrack webrtc.TrackLoc
 			URL:              address,
 			DialTimeout:      3 * time.Second,
 			ReadWriteTimeout: 3 * time.Second,
-			DisableAudio:     true,
+			DisableAudio:     tracks.audio == nil,
 		})
 		if err != nil {
 			return fmt.Errorf("rtsp dial error: %w", err)
@@ -36,45 +40,114 @@ func consumeRTSP(ctx context.Context, address string, videoTrack webrtc.TrackLoc
 		defer session.Close()
 
 		codecs := session.CodecData
+		videoIdx, audioIdx := -1, -1
 		for i, t := range codecs {
 			logger.Info().Int("i", i).Str("type", t.Type().String()).Msg("stream codec")
+
+			switch {
+			case t.Type().IsVideo() && videoIdx < 0:
+				videoIdx = i
+			case t.Type().IsAudio() && audioIdx < 0:
+				audioIdx = i
+			}
 		}
-		if codecs[0].Type() != av.H264 {
-			return fmt.Errorf("wrong codec type: %s. RTSP feed must begin with a H264 codec", codecs[0].Type())
+		if videoIdx < 0 {
+			return errors.New("no video stream found in RTSP feed")
 		}
-		if len(codecs) != 1 {
-			logger.Info().Msg("ignoring all but the first stream")
+		if mimeType := videoMimeType(codecs[videoIdx].Type()); !strings.EqualFold(mimeType, videoTrackSample.Codec().MimeType) {
+			return fmt.Errorf("video codec %s of RTSP feed doesn't match video track %s", codecs[videoIdx].Type(), videoTrackSample.Codec().MimeType)
+		}
+		var audioTrackSample sampleTrack
+		if audioIdx >= 0 && tracks.audio != nil {
+			audioTrackSample = tracks.audio.(sampleTrack)
+			if mimeType := audioMimeType(codecs[audioIdx].Type()); !strings.EqualFold(mimeType, audioTrackSample.Codec().MimeType) {
+				logger.Warn().
+					Str("type", codecs[audioIdx].Type().String()).
+					Str("mime_type", audioTrackSample.Codec().MimeType).
+					Msg("audio codec of RTSP feed doesn't match audio track, ignoring audio stream")
+				audioIdx = -1
+			}
+		}
+		if len(codecs) > 2 || (len(codecs) == 2 && audioIdx < 0) {
+			logger.Info().Msg("ignoring all but the first video and audio stream")
 		}
 
 		for {
-			pkt := <-session.OutgoingPacketQueue
+			var pkt *av.Packet
+			select {
+			case pkt = <-session.OutgoingPacketQueue:
+			case <-ctx.Done():
+				logger.Info().Str("err", ctx.Err().Error()).Msg("context is done, exiting live streaming")
+				return nil
+			}
 
-			if pkt.Idx != 0 {
-				// audio or other stream, skip it
+			if audioIdx >= 0 && int(pkt.Idx) == audioIdx {
+				if err = audioTrackSample.WriteSample(media.Sample{Data: pkt.Data, Duration: pkt.Duration}); err != nil && err != io.ErrClosedPipe {
+					return fmt.Errorf("could not write audioTrackSample: %w", err)
+				}
+				continue
+			}
+			if int(pkt.Idx) != videoIdx {
+				// Other stream, skip it.
 				continue
 			}
 
 			pkt.Data = pkt.Data[4:]
 
-			// For every key-frame pre-pend the SPS and PPS
+			// For every key-frame pre-pend the parameter sets, that is, SPS and PPS, and VPS for H265.
 			if pkt.IsKeyFrame {
-				pkt.Data = append(annexbNALUStartCode(), pkt.Data...)
-				pkt.Data = append(codecs[0].(h264parser.CodecData).PPS(), pkt.Data...)
-				pkt.Data = append(annexbNALUStartCode(), pkt.Data...)
-				pkt.Data = append(codecs[0].(h264parser.CodecData).SPS(), pkt.Data...)
-				pkt.Data = append(annexbNALUStartCode(), pkt.Data...)
+				var data []byte
+				for _, ps := range parameterSets(codecs[videoIdx]) {
+					data = append(data, annexbNALUStartCode()...)
+					data = append(data, ps...)
+				}
+				data = append(data, annexbNALUStartCode()...)
+				pkt.Data = append(data, pkt.Data...)
 			}
 
 			if err = videoTrackSample.WriteSample(media.Sample{Data: pkt.Data, Duration: pkt.Duration}); err != nil && err != io.ErrClosedPipe {
 				return fmt.Errorf("could not write videoTrackSample: %w", err)
 			}
-
-			select {
-			case <-ctx.Done():
-				logger.Info().Str("err", ctx.Err().Error()).Msg("context is done, exiting live streaming")
-				return nil
-			default:
-			}
 		}
 	}
 }
+
+// videoMimeType maps a video codec type of RTSP feed to webRTC MIME type.
+// It returns empty string if the codec is not supported.
+func videoMimeType(codecType av.CodecType) string {
+	switch codecType {
+	case av.H264:
+		return webrtc.MimeTypeH264
+	case av.H265:
+		return webrtc.MimeTypeH265
+	default:
+		return ""
+	}
+}
+
+// parameterSets returns parameter sets of video codec in decoding order.
+func parameterSets(codec av.CodecData) [][]byte {
+	switch c := codec.(type) {
+	case h264parser.CodecData:
+		return [][]byte{c.SPS(), c.PPS()}
+	case h265parser.CodecData:
+		return [][]byte{c.VPS(), c.SPS(), c.PPS()}
+	default:
+		return nil
+	}
+}
+
+// audioMimeType maps an audio codec type of RTSP feed to webRTC MIME type.
+// It returns empty string if the codec can't be sent over webRTC without transcoding.
+func audioMimeType(codecType av.CodecType) string {
+	switch codecType {
+	case av.OPUS:
+		return webrtc.MimeTypeOpus
+	case av.PCM_MULAW:
+		return webrtc.MimeTypePCMU
+	case av.PCM_ALAW:
+		return webrtc.MimeTypePCMA
+	default:
+		return ""
+	}
+}
@@ -2,7 +2,6 @@ package livestream
 
 import (
 	"fmt"
-	"strconv"
 
 	pb "github.com/SB-IM/charoite/internal/pb/signal"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -10,7 +9,7 @@ import (
 )
 
 func (p *publisher) sendOffer(sdp *webrtc.SessionDescription) error {
-	payload, err := pb.EncodeSDP(sdp, p.meta)
+	payload, err := p.signer.EncodeSDP(sdp, p.meta)
 	if err != nil {
 		return fmt.Errorf("could not encode sdp: %w", err)
 	}
@@ -19,7 +18,7 @@ func (p *publisher) sendOffer(sdp *webrtc.SessionDescription) error {
 	// the other message is replace by the new.
 	// NOTE: Currently don't enable retained message, because it always sends retained message to newly
 	// restarted cloud service which is too bad.
-	topic := p.config.OfferTopicPrefix + "/" + p.meta.Id + "/" + strconv.Itoa(int(p.meta.TrackSource))
+	topic := p.config.OfferTopicPrefix + pb.TopicSuffix(p.meta)
 	t := p.client.Publish(topic, byte(p.config.Qos), p.config.Retained, payload)
 	// Handle the token in a go routine so this loop keeps sending messages regardless of delivery status
 	go func() {
@@ -37,7 +36,7 @@ func (p *publisher) sendOffer(sdp *webrtc.SessionDescription) error {
 // The caller must check if result in channel is nil.
 func (p *publisher) recvAnswer() <-chan *webrtc.SessionDescription {
 	ch := make(chan *webrtc.SessionDescription, 1)
-	topic := p.config.AnswerTopicPrefix + "/" + p.meta.Id + "/" + strconv.Itoa(int(p.meta.TrackSource))
+	topic := p.config.AnswerTopicPrefix + pb.TopicSuffix(p.meta)
 	// Receive remote description with MQTT.
 	t := p.client.Subscribe(topic, byte(p.config.Qos), func(c mqtt.Client, m mqtt.Message) {
 		defer func() {
@@ -70,11 +69,11 @@ func (p *publisher) recvAnswer() <-chan *webrtc.SessionDescription {
 // sendCandidate sends candidate to remote webRTC peer via MQTT.
 // The publish topic is unique to this edge device.
 func (p *publisher) sendCandidate(candidate *webrtc.ICECandidate) error {
-	payload, err := pb.EncodeCandidate(candidate)
+	payload, err := p.signer.EncodeCandidate(candidate, p.meta)
 	if err != nil {
 		return fmt.Errorf("could not encode candidate: %w", err)
 	}
-	topic := p.config.CandidateSendTopicPrefix + "/" + p.meta.Id + "/" + strconv.Itoa(int(p.meta.TrackSource))
+	topic := p.config.CandidateSendTopicPrefix + pb.TopicSuffix(p.meta)
 	t := p.client.Publish(topic, byte(p.config.Qos), p.config.Retained, payload)
 	// Handle the token in a go routine so this loop keeps sending messages regardless of delivery status
 	go func() {
@@ -88,6 +87,20 @@ func (p *publisher) sendCandidate(candidate *webrtc.ICECandidate) error {
 	return nil
 }
 
+// unsubscribe unsubscribes from all topics this publisher subscribed, it's called on shutdown.
+func (p *publisher) unsubscribe() {
+	suffix := pb.TopicSuffix(p.meta)
+	topics := []string{
+		p.config.AnswerTopicPrefix + suffix,
+		p.config.CandidateRecvTopicPrefix + suffix,
+		p.config.NotifyStreamTopicPrefix + suffix,
+	}
+	t := p.client.Unsubscribe(topics...)
+	if t.WaitTimeout(signalTimeout) && t.Error() != nil {
+		p.logger.Err(t.Error()).Strs("topics", topics).Msg("could not unsubscribe")
+	}
+}
+
 // recvCandidate is not a one time subscriber.
 // The caller must check if result in channel is nil.
 // sendCandidate receive candidate from remote webRTC peer via MQTT.
@@ -95,7 +108,7 @@ func (p *publisher) sendCandidate(candidate *webrtc.ICECandidate) error {
 func (p *publisher) recvCandidate() <-chan string {
 	// TODO: Figure how to properly close channel.
 	ch := make(chan string, 2) // Make buffer 2 because we have at least 2 sendings.
-	topic := p.config.CandidateRecvTopicPrefix + "/" + p.meta.Id + "/" + strconv.Itoa(int(p.meta.TrackSource))
+	topic := p.config.CandidateRecvTopicPrefix + pb.TopicSuffix(p.meta)
 	// Receive remote ICE candidate with MQTT.
 	t := p.client.Subscribe(topic, byte(p.config.Qos), func(c mqtt.Client, m mqtt.Message) {
 		candidate, err := pb.DecodeCandidate(m.Payload())
@@ -0,0 +1,236 @@
+package signal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// Kinds of signed messages, a signature of one kind is never valid for another.
+const (
+	kindOffer     = "offer"
+	kindCandidate = "candidate"
+)
+
+const (
+	signingContext = "charoite/signal/v1"
+	nonceSize      = 16
+)
+
+var (
+	// ErrUnsigned is returned if a message is not signed while signatures are verified.
+	ErrUnsigned = errors.New("message is not signed")
+	// ErrReplayed is returned if a message is signed too long ago, or its nonce has been seen.
+	ErrReplayed = errors.New("message is replayed")
+)
+
+// Signer signs offers and candidates of an edge device with its Ed25519 private key.
+// A nil Signer encodes messages unsigned.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer with a PEM encoded PKCS #8 Ed25519 private key file,
+// e.g. generated by "openssl genpkey -algorithm ed25519".
+func NewSigner(keyFile string) (*Signer, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key file: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found in signing key file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key: %w", err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not Ed25519", key)
+	}
+	return &Signer{key: privateKey}, nil
+}
+
+// EncodeSDP encodes and signs webrtc.SessionDescription with metadata of edge device.
+func (s *Signer) EncodeSDP(sdp *webrtc.SessionDescription, meta *Meta) ([]byte, error) {
+	if s == nil {
+		return EncodeSDP(sdp, meta)
+	}
+	b, err := json.Marshal(sdp)
+	if err != nil {
+		return nil, err
+	}
+	msg := SessionDescription{Meta: meta, Sdp: string(b)}
+	if msg.Timestamp, msg.Nonce, msg.Signature, err = s.sign(kindOffer, meta, msg.Sdp); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msg)
+}
+
+// EncodeCandidate encodes and signs webrtc.ICECandidate, signature is bound to metadata of edge device,
+// so that it's not valid on candidate topic of another stream.
+func (s *Signer) EncodeCandidate(candidate *webrtc.ICECandidate, meta *Meta) ([]byte, error) {
+	if s == nil {
+		return EncodeCandidate(candidate)
+	}
+	msg := ICECandidate{Meta: meta, Candidate: candidate.ToJSON().Candidate}
+	var err error
+	if msg.Timestamp, msg.Nonce, msg.Signature, err = s.sign(kindCandidate, meta, msg.Candidate); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&msg)
+}
+
+func (s *Signer) sign(kind string, meta *Meta, body string) (timestamp int64, nonce, signature []byte, err error) {
+	timestamp = time.Now().UnixMilli()
+	nonce = make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, nil, nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	return timestamp, nonce, ed25519.Sign(s.key, signedBytes(kind, meta, timestamp, nonce, body)), nil
+}
+
+// Verifier verifies signatures of offers and candidates by public keys of edge devices in a directory,
+// and rejects messages signed longer than maxAge ago or replayed with a nonce seen.
+// A nil Verifier accepts every message.
+type Verifier struct {
+	keyDir string
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // Nonces seen by their expiry.
+}
+
+// NewVerifier returns a Verifier with public keys in keyDir, public key of a device is "<machine id>.pem",
+// a PEM encoded PKIX Ed25519 public key, e.g. generated by "openssl pkey -pubout".
+// Keys are read on every verification, so that devices are added without restarting.
+func NewVerifier(keyDir string, maxAge time.Duration) (*Verifier, error) {
+	info, err := os.Stat(keyDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat key directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", keyDir)
+	}
+	if maxAge <= 0 {
+		return nil, errors.New("max age of signed messages must be positive")
+	}
+	return &Verifier{keyDir: keyDir, maxAge: maxAge, nonces: make(map[string]time.Time)}, nil
+}
+
+// VerifySDP verifies signature of an offer with its metadata.
+func (v *Verifier) VerifySDP(msg *SessionDescription) error {
+	if v == nil {
+		return nil
+	}
+	if msg.Meta == nil {
+		return errors.New("no metadata in offer")
+	}
+	return v.verify(kindOffer, msg.Meta, msg.Timestamp, msg.Nonce, msg.Signature, msg.Sdp, time.Now())
+}
+
+// DecodeCandidate decodes protobuf payload ICECandidate received on candidate topic of meta, and verifies its signature.
+func (v *Verifier) DecodeCandidate(payload []byte, meta *Meta) (string, error) {
+	if v == nil {
+		return DecodeCandidate(payload)
+	}
+	var msg ICECandidate
+	if err := proto.Unmarshal(payload, &msg); err != nil {
+		return "", err
+	}
+	if err := v.verify(kindCandidate, meta, msg.Timestamp, msg.Nonce, msg.Signature, msg.Candidate, time.Now()); err != nil {
+		return "", err
+	}
+	return msg.Candidate, nil
+}
+
+func (v *Verifier) verify(kind string, meta *Meta, timestamp int64, nonce, signature []byte, body string, now time.Time) error {
+	if len(signature) == 0 {
+		return ErrUnsigned
+	}
+	key, err := v.publicKey(meta.Id)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, signedBytes(kind, meta, timestamp, nonce, body), signature) {
+		return errors.New("invalid signature")
+	}
+
+	signedAt := time.UnixMilli(timestamp)
+	if now.Sub(signedAt) > v.maxAge || signedAt.Sub(now) > v.maxAge {
+		return fmt.Errorf("%w: signed at %s", ErrReplayed, signedAt.Format(time.RFC3339Nano))
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, expiry := range v.nonces {
+		if now.After(expiry) {
+			delete(v.nonces, n)
+		}
+	}
+	// A nonce is kept until the message can't be accepted by its timestamp.
+	n := hex.EncodeToString(nonce)
+	if _, ok := v.nonces[n]; ok {
+		return fmt.Errorf("%w: nonce %s is seen", ErrReplayed, n)
+	}
+	v.nonces[n] = signedAt.Add(v.maxAge)
+	return nil
+}
+
+// publicKey reads public key of a machine.
+func (v *Verifier) publicKey(id string) (ed25519.PublicKey, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return nil, fmt.Errorf("invalid machine id: %q", id)
+	}
+	b, err := os.ReadFile(filepath.Join(v.keyDir, id+".pem"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key of %s: %w", id, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in public key of %s", id)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key of %s: %w", id, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key of %s is %T, not Ed25519", id, key)
+	}
+	return publicKey, nil
+}
+
+// signedBytes returns what's signed for a message, fields are length prefixed so that they can't be shifted into each other.
+func signedBytes(kind string, meta *Meta, timestamp int64, nonce []byte, body string) []byte {
+	var b []byte
+	for _, field := range []string{
+		signingContext,
+		kind,
+		meta.GetId(),
+		strconv.Itoa(int(meta.GetTrackSource())),
+		meta.GetStream(),
+		strconv.FormatInt(timestamp, 10),
+		string(nonce),
+		body,
+	} {
+		b = binary.AppendUvarint(b, uint64(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
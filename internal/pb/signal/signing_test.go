@@ -0,0 +1,184 @@
+package signal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// newSigning returns a Signer of machine id and a Verifier with its public key.
+func newSigning(t *testing.T, id string) (*Signer, *Verifier) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "private.key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDir := filepath.Join(dir, "keys")
+	if err := os.Mkdir(keyDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if der, err = x509.MarshalPKIXPublicKey(publicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(keyDir, id+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(keyDir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, verifier
+}
+
+func decodeSDP(t *testing.T, b []byte) *SessionDescription {
+	t.Helper()
+	var msg SessionDescription
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func TestSignSDP(t *testing.T) {
+	signer, verifier := newSigning(t, "abc")
+	meta := &Meta{Id: "abc", TrackSource: TrackSource_DRONE}
+	sdp := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "abc"}
+
+	b, err := signer.EncodeSDP(sdp, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := decodeSDP(t, b)
+	if err := verifier.VerifySDP(msg); err != nil {
+		t.Fatalf("could not verify signed offer: %v", err)
+	}
+	if err := verifier.VerifySDP(msg); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("got error %v of replayed offer, want %v", err, ErrReplayed)
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		b, err := EncodeSDP(sdp, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifier.VerifySDP(decodeSDP(t, b)); !errors.Is(err, ErrUnsigned) {
+			t.Fatalf("got error %v, want %v", err, ErrUnsigned)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		b, err := signer.EncodeSDP(sdp, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := decodeSDP(t, b)
+		msg.Meta = &Meta{Id: "abc", TrackSource: TrackSource_MONITOR}
+		if err := verifier.VerifySDP(msg); err == nil {
+			t.Fatal("offer with metadata changed is verified")
+		}
+	})
+
+	t.Run("other machine", func(t *testing.T) {
+		other, _ := newSigning(t, "def")
+		b, err := other.EncodeSDP(sdp, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifier.VerifySDP(decodeSDP(t, b)); err == nil {
+			t.Fatal("offer signed by other machine is verified")
+		}
+	})
+
+	t.Run("unknown machine", func(t *testing.T) {
+		for _, id := range []string{"ghi", "../keys/abc", ".."} {
+			b, err := signer.EncodeSDP(sdp, &Meta{Id: id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifier.VerifySDP(decodeSDP(t, b)); err == nil {
+				t.Fatalf("offer of machine %q is verified", id)
+			}
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		b, err := signer.EncodeSDP(sdp, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := decodeSDP(t, b)
+		err = verifier.verify(kindOffer, msg.Meta, msg.Timestamp, msg.Nonce, msg.Signature, msg.Sdp, time.Now().Add(2*time.Minute))
+		if !errors.Is(err, ErrReplayed) {
+			t.Fatalf("got error %v of stale offer, want %v", err, ErrReplayed)
+		}
+	})
+}
+
+func TestSignCandidate(t *testing.T) {
+	signer, verifier := newSigning(t, "abc")
+	meta := &Meta{Id: "abc", TrackSource: TrackSource_DRONE}
+	candidate := &webrtc.ICECandidate{
+		Foundation: "1",
+		Address:    "192.168.1.2",
+		Port:       5000,
+		Protocol:   webrtc.ICEProtocolUDP,
+		Typ:        webrtc.ICECandidateTypeHost,
+		Component:  1,
+	}
+
+	b, err := signer.EncodeCandidate(candidate, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := verifier.DecodeCandidate(b, meta)
+	if err != nil {
+		t.Fatalf("could not verify signed candidate: %v", err)
+	}
+	if want := candidate.ToJSON().Candidate; got != want {
+		t.Fatalf("got candidate %q, want %q", got, want)
+	}
+
+	b, err = signer.EncodeCandidate(candidate, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.DecodeCandidate(b, &Meta{Id: "abc", TrackSource: TrackSource_DRONE, Stream: "other"}); err == nil {
+		t.Fatal("candidate is verified on topic of other stream")
+	}
+
+	if b, err = EncodeCandidate(candidate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.DecodeCandidate(b, meta); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("got error %v, want %v", err, ErrUnsigned)
+	}
+
+	var nilVerifier *Verifier
+	if _, err := nilVerifier.DecodeCandidate(b, meta); err != nil {
+		t.Fatalf("unsigned candidate is rejected without verifier: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package signal
+
+import "strconv"
+
+// TopicSuffix returns MQTT topic suffix unique to a stream in "/id/track_source" pattern,
+// a stream label is appended as "/id/track_source/stream" if it's not empty.
+func TopicSuffix(meta *Meta) string {
+	suffix := "/" + meta.Id + "/" + strconv.Itoa(int(meta.TrackSource))
+	if meta.Stream != "" {
+		suffix += "/" + meta.Stream
+	}
+	return suffix
+}
@@ -0,0 +1,154 @@
+package turn
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// admin serves admin HTTP API of TURN server: active allocations, forced deallocation of users and health of listeners.
+type admin struct {
+	allocations *allocations
+	listeners   *listeners
+	token       string // Bearer token of allocation endpoints, empty allows all requests of loopback listeners.
+	logger      *zerolog.Logger
+}
+
+// allocationInfo is an active allocation replied by admin API.
+type allocationInfo struct {
+	Username      string    `json:"username"`
+	ClientAddr    string    `json:"client_addr"`
+	RelayAddr     string    `json:"relay_addr"`
+	CreatedAt     time.Time `json:"created_at"`
+	Age           string    `json:"age"`
+	BytesSent     uint64    `json:"bytes_sent"`     // Relayed from client to peers.
+	BytesReceived uint64    `json:"bytes_received"` // Relayed from peers to client.
+}
+
+// listenerInfo is status of a listener replied by health endpoint.
+type listenerInfo struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Serving bool   `json:"serving"`
+	Error   string `json:"error,omitempty"`
+}
+
+type health struct {
+	Healthy     bool           `json:"healthy"`
+	Listeners   []listenerInfo `json:"listeners"`
+	Allocations int            `json:"allocations"`
+}
+
+// checkAdminAddr returns an error if admin HTTP API listens on a non loopback address without token,
+// as anyone reaching it could list and delete allocations.
+func checkAdminAddr(addr, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("admin token is required unless admin address is loopback")
+}
+
+func (a *admin) router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/turn/health", a.handleHealth()).Methods(http.MethodGet)
+	router.HandleFunc("/v1/turn/allocations", a.authorize(a.handleAllocations())).Methods(http.MethodGet)
+	router.HandleFunc("/v1/turn/users/{username}/allocations", a.authorize(a.handleDeleteUser())).Methods(http.MethodDelete)
+	return router
+}
+
+// authorize requires bearer token in Authorization header if token is configured.
+func (a *admin) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="turn"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleAllocations lists active allocations, optionally of username query parameter.
+func (a *admin) handleAllocations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get("username")
+		now := time.Now()
+		infos := []allocationInfo{}
+		for _, c := range a.allocations.list() {
+			client := c.getClient()
+			if username != "" && client.username != username {
+				continue
+			}
+			infos = append(infos, allocationInfo{
+				Username:      client.username,
+				ClientAddr:    client.clientAddr,
+				RelayAddr:     client.relayAddr,
+				CreatedAt:     c.createdAt,
+				Age:           now.Sub(c.createdAt).Round(time.Second).String(),
+				BytesSent:     c.bytesSent.Load(),
+				BytesReceived: c.bytesReceived.Load(),
+			})
+		}
+		a.writeJSON(w, http.StatusOK, infos)
+	}
+}
+
+// handleDeleteUser deletes all active allocations of a user, clients have to allocate again to relay.
+func (a *admin) handleDeleteUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["username"]
+		n := a.allocations.deleteUser(username)
+		a.logger.Info().Str("username", username).Int("allocations", n).Msg("deleted allocations of user by admin")
+		a.writeJSON(w, http.StatusOK, map[string]int{"deleted": n})
+	}
+}
+
+// handleHealth reports whether every listener is serving, it replies 503 if any isn't.
+func (a *admin) handleHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := health{Healthy: true, Listeners: make([]listenerInfo, 0, len(a.listeners.statuses))}
+		for _, s := range a.listeners.statuses {
+			info := listenerInfo{Network: s.network, Address: s.addr, Serving: true}
+			if err := s.error(); err != nil {
+				info.Serving, info.Error = false, err.Error()
+				h.Healthy = false
+			}
+			h.Listeners = append(h.Listeners, info)
+		}
+		h.Allocations = len(a.allocations.list())
+		status := http.StatusOK
+		if !h.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		a.writeJSON(w, status, h)
+	}
+}
+
+func (a *admin) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Err(err).Msg("could not write admin response")
+	}
+}
@@ -0,0 +1,105 @@
+package turn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAdmin(t *testing.T) {
+	cfg := &ConfigOptions{
+		PublicIP:     "127.0.0.1",
+		Port:         freePort(t),
+		Realm:        "example.com",
+		Username:     "user",
+		Password:     "password",
+		RelayMinPort: 40400,
+		RelayMaxPort: 40500,
+	}
+	l := startServer(t, cfg, nil)
+	logger := zerolog.Nop()
+	router := (&admin{allocations: l.allocations, listeners: l, token: "token", logger: &logger}).router()
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	relayConn, err := allocate(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(http.MethodGet, "/v1/turn/allocations", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d with wrong token", w.Code)
+	}
+	w := serve(http.MethodGet, "/v1/turn/allocations?username=user", "token")
+	var infos []allocationInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Username != "user" || infos[0].RelayAddr != relayConn.LocalAddr().String() {
+		t.Fatalf("got allocations %+v", infos)
+	}
+
+	w = serve(http.MethodDelete, "/v1/turn/users/user/allocations", "token")
+	var deleted map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted["deleted"] != 1 || len(l.allocations.list()) != 0 {
+		t.Fatalf("got %v deleted, %d allocations left", deleted, len(l.allocations.list()))
+	}
+
+	if w := serve(http.MethodGet, "/v1/turn/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("got health status %d: %s", w.Code, w.Body)
+	}
+	// TURN server stops serving a listener closed.
+	_ = l.closers[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		w := serve(http.MethodGet, "/v1/turn/health", "")
+		if w.Code == http.StatusServiceUnavailable {
+			var h health
+			if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+				t.Fatal(err)
+			}
+			if h.Healthy || len(h.Listeners) != 1 || h.Listeners[0].Serving {
+				t.Fatalf("got health %+v", h)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("closed listener is reported serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckAdminAddr(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		ok          bool
+	}{
+		{"127.0.0.1:8081", "", true},
+		{"[::1]:8081", "", true},
+		{"localhost:8081", "", true},
+		{"0.0.0.0:8081", "", false},
+		{":8081", "", false},
+		{"192.0.2.1:8081", "", false},
+		{"0.0.0.0:8081", "token", true},
+		{"8081", "", false},
+	}
+	for _, tt := range tests {
+		if err := checkAdminAddr(tt.addr, tt.token); (err == nil) != tt.ok {
+			t.Errorf("%q with token %q: got error %v", tt.addr, tt.token, err)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package turn
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+// pendingTimeout is how long an allocate request in flight is counted against quota of its user without a response,
+// e.g. if client closes connection before it.
+const pendingTimeout = 30 * time.Second
+
+var (
+	allocateRequest         = stun.NewType(stun.MethodAllocate, stun.ClassRequest)
+	allocateSuccessResponse = stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse)
+	allocateErrorResponse   = stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse)
+)
+
+// allocations are relay conns of active allocations by their relay ports.
+// TURN server doesn't tell who an allocation belongs to, so allocate requests and responses on listeners are inspected:
+// username of an allocate request is kept by client address until its response carries the relayed address.
+// Allocate requests in flight are counted against quota as well, so that concurrent requests don't exceed it.
+type allocations struct {
+	maxPerUser int // 0 is unlimited.
+	bandwidth  int // Bits per second of each direction, 0 is unlimited.
+	usage      *usageLog
+	logger     *zerolog.Logger
+
+	mu      sync.Mutex
+	conns   map[int]*relayConn
+	pending map[string]pendingAllocate // Allocate requests in flight by client address.
+}
+
+// pendingAllocate is an allocate request waiting for its response.
+type pendingAllocate struct {
+	username   string
+	receivedAt time.Time
+}
+
+func newAllocations(cfg *ConfigOptions, usage *usageLog, logger *zerolog.Logger) *allocations {
+	return &allocations{
+		maxPerUser: cfg.MaxAllocationsPerUser,
+		bandwidth:  cfg.AllocationBandwidth,
+		usage:      usage,
+		logger:     logger,
+		conns:      make(map[int]*relayConn),
+		pending:    make(map[string]pendingAllocate),
+	}
+}
+
+// add registers relay conn of a new allocation.
+func (a *allocations) add(conn net.PacketConn, relayAddr net.Addr) *relayConn {
+	c := &relayConn{
+		PacketConn: conn,
+		onClose:    a.remove,
+		createdAt:  time.Now(),
+	}
+	if addr, ok := relayAddr.(*net.UDPAddr); ok {
+		c.port = addr.Port
+	}
+	c.lastActive.Store(c.createdAt.UnixNano())
+	if a.bandwidth > 0 {
+		c.sendLimit = newTokenBucket(a.bandwidth)
+		c.recvLimit = newTokenBucket(a.bandwidth)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conns[c.port] = c
+	return c
+}
+
+func (a *allocations) remove(c *relayConn) {
+	a.mu.Lock()
+	if a.conns[c.port] == c {
+		delete(a.conns, c.port)
+	}
+	a.mu.Unlock()
+
+	client := c.getClient()
+	a.logger.Info().
+		Str("username", client.username).
+		Str("client_addr", client.clientAddr).
+		Str("relay_addr", client.relayAddr).
+		Uint64("bytes_sent", c.bytesSent.Load()).
+		Uint64("bytes_received", c.bytesReceived.Load()).
+		Msg("deleted allocation")
+	if a.usage != nil {
+		if err := a.usage.write(c, time.Now()); err != nil {
+			a.logger.Err(err).Msg("could not write usage log")
+		}
+	}
+}
+
+// list returns relay conns of active allocations ordered by creation.
+func (a *allocations) list() []*relayConn {
+	a.mu.Lock()
+	conns := make([]*relayConn, 0, len(a.conns))
+	for _, c := range a.conns {
+		conns = append(conns, c)
+	}
+	a.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].createdAt.Before(conns[j].createdAt) })
+	return conns
+}
+
+// deleteUser deletes all active allocations of username, and returns number of them.
+func (a *allocations) deleteUser(username string) int {
+	a.mu.Lock()
+	var conns []*relayConn
+	for _, c := range a.conns {
+		if c.getClient().username == username {
+			conns = append(conns, c)
+		}
+	}
+	a.mu.Unlock()
+	// Closing relay conn deletes its allocation.
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	return len(conns)
+}
+
+// countUser returns number of active allocations of username, the caller must hold a.mu.
+func (a *allocations) countUser(username string) int {
+	var n int
+	for _, c := range a.conns {
+		if c.getClient().username == username {
+			n++
+		}
+	}
+	return n
+}
+
+// countPending returns number of allocate requests of username in flight from other clients than src,
+// and deletes those timed out, the caller must hold a.mu.
+// A request retransmitted by src replaces its own pending request, so it isn't counted.
+func (a *allocations) countPending(username, src string, now time.Time) int {
+	var n int
+	for addr, p := range a.pending {
+		if now.Sub(p.receivedAt) > pendingTimeout {
+			delete(a.pending, addr)
+			continue
+		}
+		if p.username == username && addr != src {
+			n++
+		}
+	}
+	return n
+}
+
+// inbound inspects a STUN message or ChannelData received from client, and returns an error response
+// to reply instead of handling the message if it's an allocate request over quota of its user.
+func (a *allocations) inbound(frame []byte, src net.Addr) []byte {
+	m, ok := decodeAllocate(frame)
+	if !ok || m.Type != allocateRequest {
+		return nil
+	}
+	var username stun.Username
+	if err := username.GetFrom(m); err != nil {
+		return nil // Unauthenticated request, it's challenged by server.
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxPerUser > 0 && a.countUser(username.String())+a.countPending(username.String(), src.String(), now) >= a.maxPerUser {
+		allocationsRejected.Inc()
+		a.logger.Warn().Str("username", username.String()).Str("client_addr", src.String()).Msg("allocation quota reached")
+		reply, err := stun.Build(
+			stun.NewTransactionIDSetter(m.TransactionID),
+			allocateErrorResponse,
+			stun.CodeAllocQuotaReached,
+			stun.Fingerprint,
+		)
+		if err != nil {
+			return nil
+		}
+		return reply.Raw
+	}
+	a.pending[src.String()] = pendingAllocate{username: username.String(), receivedAt: now}
+	return nil
+}
+
+// outbound inspects a STUN message or ChannelData sent to client, allocate responses tell relay conns who they belong to.
+// Relay conn is labeled under the same lock as its pending request is deleted, so that it's always counted against quota.
+func (a *allocations) outbound(frame []byte, dst net.Addr) {
+	m, ok := decodeAllocate(frame)
+	if !ok || (m.Type != allocateSuccessResponse && m.Type != allocateErrorResponse) {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending[dst.String()]
+	delete(a.pending, dst.String())
+	var relayed stun.XORMappedAddress
+	if m.Type != allocateSuccessResponse || relayed.GetFromAs(m, stun.AttrXORRelayedAddress) != nil {
+		a.mu.Unlock()
+		return
+	}
+	c, ok := a.conns[relayed.Port]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	client := allocationClient{username: pending.username, clientAddr: dst.String(), relayAddr: relayed.String()}
+	c.setClient(client)
+	a.mu.Unlock()
+	a.logger.Info().Str("username", client.username).Str("client_addr", client.clientAddr).Str("relay_addr", client.relayAddr).Msg("created allocation")
+}
+
+// decodeAllocate decodes frame if it's a STUN message of allocate method.
+func decodeAllocate(frame []byte) (*stun.Message, bool) {
+	if !stun.IsMessage(frame) {
+		return nil, false
+	}
+	var t stun.MessageType
+	t.ReadValue(binary.BigEndian.Uint16(frame[0:2]))
+	if t.Method != stun.MethodAllocate {
+		return nil, false
+	}
+	m := &stun.Message{Raw: append([]byte(nil), frame...)}
+	if err := m.Decode(); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// reap deletes allocations without relayed packets for idleTimeout until ctx is done.
+func (a *allocations) reap(ctx context.Context, idleTimeout time.Duration) {
+	interval := idleTimeout / 2
+	if interval <= 0 {
+		interval = idleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.mu.Lock()
+			var idle []*relayConn
+			for _, c := range a.conns {
+				if now.Sub(time.Unix(0, c.lastActive.Load())) > idleTimeout {
+					idle = append(idle, c)
+				}
+			}
+			a.mu.Unlock()
+			// Closing relay conn deletes its allocation.
+			for _, c := range idle {
+				allocationsReaped.Inc()
+				a.logger.Info().Str("username", c.getClient().username).Str("relay_addr", c.getClient().relayAddr).Msg("reaping idle allocation")
+				_ = c.Close()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// inspectedPacketConn is a UDP listener whose allocate requests and responses are inspected.
+type inspectedPacketConn struct {
+	net.PacketConn
+	allocations *allocations
+	status      *listenerStatus
+}
+
+func (c *inspectedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			c.status.fail(err)
+			return n, addr, err
+		}
+		if reply := c.allocations.inbound(p[:n], addr); reply != nil {
+			_, _ = c.PacketConn.WriteTo(reply, addr)
+			continue
+		}
+		return n, addr, nil
+	}
+}
+
+func (c *inspectedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.allocations.outbound(p, addr)
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// inspectedListener is a TCP or TLS listener whose allocate requests and responses are inspected.
+type inspectedListener struct {
+	net.Listener
+	allocations *allocations
+	status      *listenerStatus
+}
+
+func (l *inspectedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		l.status.fail(err)
+		return nil, err
+	}
+	return &inspectedConn{Conn: conn, frames: turn.NewSTUNConn(conn), allocations: l.allocations}, nil
+}
+
+// inspectedConn reads a STUN message or ChannelData at a time, so that they're inspected as UDP datagrams.
+type inspectedConn struct {
+	net.Conn
+	frames      *turn.STUNConn
+	allocations *allocations
+}
+
+func (c *inspectedConn) Read(p []byte) (int, error) {
+	for {
+		n, addr, err := c.frames.ReadFrom(p)
+		if err != nil {
+			return 0, err
+		}
+		if n > len(p) {
+			return 0, io.ErrShortBuffer
+		}
+		if reply := c.allocations.inbound(p[:n], addr); reply != nil {
+			if _, err := c.Conn.Write(reply); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		return n, nil
+	}
+}
+
+func (c *inspectedConn) Write(p []byte) (int, error) {
+	c.allocations.outbound(p, c.Conn.RemoteAddr())
+	return c.Conn.Write(p)
+}
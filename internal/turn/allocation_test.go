@@ -0,0 +1,351 @@
+package turn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+// startServer starts a TURN server of cfg on localhost, it's closed after test.
+func startServer(t *testing.T, cfg *ConfigOptions, usage *usageLog) *listeners {
+	t.Helper()
+	logger := zerolog.Nop()
+	auth, err := newAuthenticator(cfg, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := newAllocations(cfg, usage, &logger)
+	l, err := listen(context.Background(), cfg, allocs, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := turn.NewServer(turn.ServerConfig{
+		LoggerFactory:     adapter(&pionLogger{&logger}),
+		Realm:             cfg.Realm,
+		AuthHandler:       auth.authHandler,
+		PacketConnConfigs: l.packetConnConfigs,
+		ListenerConfigs:   l.listenerConfigs,
+	})
+	if err != nil {
+		l.close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return l
+}
+
+// allocate allocates a relay conn over UDP.
+func allocate(t *testing.T, cfg *ConfigOptions) (net.PacketConn, error) {
+	t.Helper()
+	return newClient(t, cfg).Allocate()
+}
+
+// newClient returns a TURN client listening over UDP, it's closed after test.
+func newClient(t *testing.T, cfg *ConfigOptions) *turn.Client {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Port))
+	logger := zerolog.Nop()
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		Realm:          cfg.Realm,
+		Conn:           conn,
+		LoggerFactory:  adapter(&pionLogger{&logger}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newTCPClient returns a TURN client connected over TCP, it's closed after test.
+func newTCPClient(t *testing.T, cfg *ConfigOptions) *turn.Client {
+	t.Helper()
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Port))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zerolog.Nop()
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		Realm:          cfg.Realm,
+		Conn:           turn.NewSTUNConn(conn),
+		LoggerFactory:  adapter(&pionLogger{&logger}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAllocationQuota(t *testing.T) {
+	usageFile := filepath.Join(t.TempDir(), "usage.log")
+	usage, err := openUsageLog(usageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer usage.close()
+	cfg := &ConfigOptions{
+		PublicIP:              "127.0.0.1",
+		Port:                  freePort(t),
+		Realm:                 "example.com",
+		Username:              "user",
+		Password:              "password",
+		RelayMinPort:          40200,
+		RelayMaxPort:          40300,
+		MaxAllocationsPerUser: 1,
+	}
+	allocs := startServer(t, cfg, usage).allocations
+
+	relayConn, err := allocate(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayAddr := relayConn.LocalAddr().(*net.UDPAddr)
+	allocs.mu.Lock()
+	c, ok := allocs.conns[relayAddr.Port]
+	allocs.mu.Unlock()
+	if !ok {
+		t.Fatalf("allocation of relay port %d is not registered", relayAddr.Port)
+	}
+	if client := c.getClient(); client.username != "user" || client.relayAddr != relayAddr.String() {
+		t.Fatalf("got client %+v of allocation", client)
+	}
+
+	if _, err := allocate(t, cfg); err == nil {
+		t.Fatal("allocation over quota is created")
+	}
+
+	// Deleting allocation frees quota and writes usage.
+	if err := relayConn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		allocs.mu.Lock()
+		n := len(allocs.conns)
+		allocs.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("allocation is not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := allocate(t, cfg); err != nil {
+		t.Fatalf("could not allocate after allocation is deleted: %v", err)
+	}
+
+	f, err := os.Open(usageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("no usage is written")
+	}
+	var record usageRecord
+	if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Username != "user" || record.RelayAddr != relayAddr.String() {
+		t.Fatalf("got usage %+v", record)
+	}
+}
+
+func TestAllocationQuotaConcurrent(t *testing.T) {
+	cfg := &ConfigOptions{
+		PublicIP:              "127.0.0.1",
+		Port:                  freePort(t),
+		Realm:                 "example.com",
+		Username:              "user",
+		Password:              "password",
+		RelayMinPort:          40600,
+		RelayMaxPort:          40700,
+		MaxAllocationsPerUser: 2,
+		TCP:                   true,
+	}
+	allocs := startServer(t, cfg, nil).allocations
+
+	// Allocate requests from several TCP connections are in flight at the same time,
+	// TURN server handles each connection in its own goroutine.
+	const clients = 8
+	errChan := make(chan error, clients)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < clients; i++ {
+		client := newTCPClient(t, cfg)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := client.Allocate()
+			errChan <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errChan)
+
+	var allocated int
+	for err := range errChan {
+		if err == nil {
+			allocated++
+		}
+	}
+	if allocated != cfg.MaxAllocationsPerUser {
+		t.Fatalf("got %d allocations, want %d", allocated, cfg.MaxAllocationsPerUser)
+	}
+	allocs.mu.Lock()
+	n := allocs.countUser("user")
+	allocs.mu.Unlock()
+	if n != cfg.MaxAllocationsPerUser {
+		t.Fatalf("got %d allocations counted, want %d", n, cfg.MaxAllocationsPerUser)
+	}
+}
+
+func TestAllocationQuotaInFlight(t *testing.T) {
+	logger := zerolog.Nop()
+	allocs := newAllocations(&ConfigOptions{MaxAllocationsPerUser: 2}, nil, &logger)
+	request := func(t *testing.T) []byte {
+		t.Helper()
+		m, err := stun.Build(stun.TransactionID, allocateRequest, stun.NewUsername("user"), stun.Fingerprint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Raw
+	}
+
+	// Requests from different clients are in flight before any response, only quota of them pass.
+	const clients = 8
+	rejected := make(chan bool, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		frame := request(t)
+		src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 10000 + i}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rejected <- allocs.inbound(frame, src) != nil
+		}()
+	}
+	wg.Wait()
+	close(rejected)
+	var passed int
+	for r := range rejected {
+		if !r {
+			passed++
+		}
+	}
+	if passed != 2 {
+		t.Fatalf("got %d requests passed, want 2", passed)
+	}
+
+	// A retransmitted request isn't counted twice.
+	allocs.mu.Lock()
+	var src string
+	for addr := range allocs.pending {
+		src = addr
+		break
+	}
+	allocs.mu.Unlock()
+	addr, err := net.ResolveUDPAddr("udp", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocs.inbound(request(t), addr) != nil {
+		t.Fatal("retransmitted request is rejected")
+	}
+
+	// Timed out requests aren't counted.
+	allocs.mu.Lock()
+	for addr, p := range allocs.pending {
+		p.receivedAt = p.receivedAt.Add(-2 * pendingTimeout)
+		allocs.pending[addr] = p
+	}
+	allocs.mu.Unlock()
+	if allocs.inbound(request(t), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 10000}) != nil {
+		t.Fatal("request is rejected by timed out requests")
+	}
+}
+
+func TestAllocationReap(t *testing.T) {
+	logger := zerolog.Nop()
+	allocs := newAllocations(&ConfigOptions{}, nil, &logger)
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := allocs.add(conn, conn.LocalAddr())
+	c.lastActive.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go allocs.reap(ctx, 20*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		allocs.mu.Lock()
+		n := len(allocs.conns)
+		allocs.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle allocation is not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := conn.WriteTo([]byte{0}, conn.LocalAddr()); err == nil {
+		t.Fatal("relay conn of idle allocation is not closed")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(8000) // 1000 bytes per second.
+	b.last = now
+	if !b.allow(1000, now) {
+		t.Fatal("burst of a second is not allowed")
+	}
+	if b.allow(1, now) {
+		t.Fatal("bytes over limit are allowed")
+	}
+	if !b.allow(500, now.Add(500*time.Millisecond)) {
+		t.Fatal("bytes refilled are not allowed")
+	}
+	if b.allow(1000, now.Add(time.Hour)) && b.allow(1, now.Add(time.Hour)) {
+		t.Fatal("bucket is refilled over burst")
+	}
+}
@@ -0,0 +1,194 @@
+package turn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 is required by TURN REST API.
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+// usersReloadInterval is how often users file is checked for changes.
+const usersReloadInterval = 10 * time.Second
+
+// RESTCredentials returns time-limited credentials of TURN REST API valid for ttl, user identifies who they're issued to.
+// Username is "<expiry unix time>:<user>", password is base64 encoded HMAC-SHA1 of username with secret.
+// See: https://datatracker.ietf.org/doc/html/draft-uberti-behave-turn-rest-00
+func RESTCredentials(secret, user string, ttl time.Duration) (username, password string) {
+	username = strconv.FormatInt(time.Now().Add(ttl).Unix(), 10) + ":" + user
+	return username, restPassword(secret, username)
+}
+
+func restPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// authenticator looks up keys of static user, users file and TURN REST API credentials, in this order.
+type authenticator struct {
+	realm        string
+	staticUser   string
+	staticKey    []byte
+	users        *users // Nil if users file is not configured.
+	sharedSecret string // Empty if TURN REST API credentials are not accepted.
+	logger       *zerolog.Logger
+}
+
+func newAuthenticator(cfg *ConfigOptions, logger *zerolog.Logger) (*authenticator, error) {
+	a := &authenticator{
+		realm:        cfg.Realm,
+		sharedSecret: cfg.SharedSecret,
+		logger:       logger,
+	}
+	if cfg.Username != "" {
+		a.staticUser = cfg.Username
+		a.staticKey = turn.GenerateAuthKey(cfg.Username, cfg.Realm, cfg.Password)
+	}
+	if cfg.UsersFile != "" {
+		var err error
+		if a.users, err = newUsers(cfg.UsersFile); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// authHandler is turn.AuthHandler.
+func (a *authenticator) authHandler(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+	if a.staticKey != nil && username == a.staticUser {
+		return a.staticKey, true
+	}
+	if a.users != nil {
+		if key, ok := a.users.key(username); ok {
+			return key, true
+		}
+	}
+	if a.sharedSecret != "" {
+		if key, ok := a.restKey(username, time.Now()); ok {
+			return key, true
+		}
+	}
+	a.logger.Warn().Str("username", username).Str("src_addr", srcAddr.String()).Msg("unknown TURN user")
+	return nil, false
+}
+
+// restKey returns key of TURN REST API username if it's not expired.
+func (a *authenticator) restKey(username string, now time.Time) ([]byte, bool) {
+	expiry, _, _ := strings.Cut(username, ":")
+	t, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() > t {
+		return nil, false
+	}
+	return turn.GenerateAuthKey(username, a.realm, restPassword(a.sharedSecret, username)), true
+}
+
+// users are keys of users loaded from file, it's reloaded after the file changes,
+// so that users are added or removed without restarting server.
+// Every line of users file is "<username>:<hex key>", key is MD5 of "<username>:<realm>:<password>" as turn.GenerateAuthKey,
+// blank lines and lines starting with # are ignored.
+type users struct {
+	file string
+
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	modTime time.Time // Modification time of file loaded.
+}
+
+func newUsers(file string) (*users, error) {
+	u := &users{file: file}
+	if _, err := u.reload(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *users) key(username string) ([]byte, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	key, ok := u.keys[username]
+	return key, ok
+}
+
+// reload loads users file again if it's modified since it's loaded, and reports whether it's reloaded.
+// Users loaded are kept if the file can't be loaded.
+func (u *users) reload() (bool, error) {
+	info, err := os.Stat(u.file)
+	if err != nil {
+		return false, err
+	}
+	u.mu.RLock()
+	unchanged := u.keys != nil && info.ModTime().Equal(u.modTime)
+	u.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(u.file)
+	if err != nil {
+		return false, fmt.Errorf("could not read users file: %w", err)
+	}
+	keys, err := parseUsers(b)
+	if err != nil {
+		return false, fmt.Errorf("could not parse users file: %w", err)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.keys = keys
+	u.modTime = info.ModTime()
+	return true, nil
+}
+
+// watch reloads users file every interval until ctx is done.
+func (u *users) watch(ctx context.Context, interval time.Duration, logger *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := u.reload()
+			if err != nil {
+				logger.Err(err).Str("file", u.file).Msg("could not reload users file")
+			} else if reloaded {
+				u.mu.RLock()
+				logger.Info().Str("file", u.file).Int("users", len(u.keys)).Msg("reloaded users file")
+				u.mu.RUnlock()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func parseUsers(b []byte) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: want <username>:<hex key>", n)
+		}
+		key, err := hex.DecodeString(line[i+1:])
+		if err != nil || len(key) != 16 {
+			return nil, fmt.Errorf("line %d: key must be 32 hex digits", n)
+		}
+		keys[line[:i]] = key
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,104 @@
+package turn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+var srcAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+
+func TestAuthenticatorREST(t *testing.T) {
+	logger := zerolog.Nop()
+	a, err := newAuthenticator(&ConfigOptions{Realm: "example.com", SharedSecret: "secret"}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username, password := RESTCredentials("secret", "viewer", time.Minute)
+	key, ok := a.authHandler(username, "example.com", srcAddr)
+	if !ok {
+		t.Fatalf("credentials of %s are rejected", username)
+	}
+	if want := turn.GenerateAuthKey(username, "example.com", password); !bytes.Equal(key, want) {
+		t.Fatalf("got key %x, want %x", key, want)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		username string
+		now      time.Time
+	}{
+		{"expired", username, time.Now().Add(2 * time.Minute)},
+		{"no expiry", "viewer", time.Now()},
+		{"invalid expiry", "abc:viewer", time.Now()},
+	} {
+		if _, ok := a.restKey(tc.username, tc.now); ok {
+			t.Errorf("%s: credentials are accepted", tc.name)
+		}
+	}
+
+	otherUsername, otherPassword := RESTCredentials("other", "viewer", time.Minute)
+	key, ok = a.authHandler(otherUsername, "example.com", srcAddr)
+	if ok && bytes.Equal(key, turn.GenerateAuthKey(otherUsername, "example.com", otherPassword)) {
+		t.Fatal("credentials of other secret are accepted")
+	}
+}
+
+func TestAuthenticatorUsersFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	line := func(username, password string) string {
+		return username + ":" + hex.EncodeToString(turn.GenerateAuthKey(username, "example.com", password)) + "\n"
+	}
+	modTime := time.Now().Add(-time.Hour)
+	write("# Edge devices\n\n"+line("abc", "p1"), modTime)
+
+	logger := zerolog.Nop()
+	a, err := newAuthenticator(&ConfigOptions{Realm: "example.com", Username: "user", Password: "password", UsersFile: file}, &logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.authHandler("user", "example.com", srcAddr); !ok {
+		t.Fatal("static user is rejected")
+	}
+	if key, ok := a.authHandler("abc", "example.com", srcAddr); !ok || !bytes.Equal(key, turn.GenerateAuthKey("abc", "example.com", "p1")) {
+		t.Fatal("user of users file is rejected")
+	}
+	if _, ok := a.authHandler("def", "example.com", srcAddr); ok {
+		t.Fatal("unknown user is accepted")
+	}
+
+	write(line("def", "p2"), modTime.Add(time.Minute))
+	if reloaded, err := a.users.reload(); err != nil || !reloaded {
+		t.Fatalf("users file is not reloaded: %v", err)
+	}
+	if _, ok := a.authHandler("abc", "example.com", srcAddr); ok {
+		t.Fatal("removed user is accepted")
+	}
+	if _, ok := a.authHandler("def", "example.com", srcAddr); !ok {
+		t.Fatal("added user is rejected")
+	}
+
+	write("def:xyz\n", modTime.Add(2*time.Minute))
+	if _, err := a.users.reload(); err == nil {
+		t.Fatal("invalid users file is loaded")
+	}
+	if _, ok := a.authHandler("def", "example.com", srcAddr); !ok {
+		t.Fatal("users loaded are not kept after invalid users file")
+	}
+}
@@ -1,11 +1,29 @@
 package turn
 
+import "time"
+
 type ConfigOptions struct {
-	PublicIP     string
-	Port         int
-	Username     string
-	Password     string
-	Realm        string
-	RelayMinPort uint
-	RelayMaxPort uint
+	PublicIP        string
+	Port            int
+	Username        string // Static user, empty disables it
+	Password        string
+	UsersFile       string // File of users and their keys, it's reloaded after it changes, empty disables it
+	SharedSecret    string // Secret of TURN REST API credentials, empty disables them
+	Realm           string
+	RelayMinPort    uint
+	RelayMaxPort    uint
+	ShutdownTimeout time.Duration // Deadline of closing server and all allocations
+	IPv6            bool          // Listen on udp6 as well, TCP and TLS listeners are dual stack
+	TCP             bool          // Listen on TCP of Port as well
+	TLSPort         int           // Port of TURN over TLS, e.g. 5349 or 443, 0 disables it
+	TLSCertFile     string        // PEM certificate of TLS listener, it's reloaded after file changes
+	TLSKeyFile      string        // PEM private key of TLS certificate
+
+	MaxAllocationsPerUser int           // Active allocations of a user, 0 is unlimited
+	AllocationBandwidth   int           // Bits per second relayed of each direction of an allocation, 0 is unlimited
+	IdleTimeout           time.Duration // Allocations without relayed packets for this duration are deleted, 0 keeps them
+	UsageLogFile          string        // JSON lines of relayed bytes of every allocation deleted, empty disables it
+
+	AdminAddr  string // Listening address of admin HTTP API, e.g. 127.0.0.1:8081, empty disables it
+	AdminToken string // Bearer token of admin allocation endpoints, empty allows all requests and requires a loopback AdminAddr
 }
@@ -0,0 +1,150 @@
+package turn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SB-IM/charoite/pkg/tlsx"
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+// certReloadInterval is how often TLS certificate files are checked for changes.
+const certReloadInterval = 10 * time.Second
+
+// listeners are UDP, TCP and TLS listeners of TURN server, they share the same relay address generator.
+type listeners struct {
+	packetConnConfigs []turn.PacketConnConfig
+	listenerConfigs   []turn.ListenerConfig
+	closers           []io.Closer
+	statuses          []*listenerStatus
+	allocations       *allocations
+}
+
+// listenerStatus tells whether a listener is serving, TURN server stops serving a listener after it returns an error.
+type listenerStatus struct {
+	network string
+	addr    string
+
+	mu  sync.Mutex
+	err error
+}
+
+func (s *listenerStatus) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// error returns error the listener failed with, it's nil if listener is serving.
+func (s *listenerStatus) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// listen creates listeners configured in cfg, the TLS certificate is reloaded until ctx is done.
+// Listeners are closed by TURN server, or by close if TURN server can't be created.
+// Allocations of all listeners are registered in allocs.
+func listen(ctx context.Context, cfg *ConfigOptions, allocs *allocations, logger *zerolog.Logger) (*listeners, error) {
+	generator := &relayAddressGenerator{
+		RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: net.ParseIP(cfg.PublicIP), // Claim that we are listening on IP passed by user (This should be your Public IP)
+			Address:      "0.0.0.0",                 // But actually be listening on every interface
+			MinPort:      uint16(cfg.RelayMinPort),
+			MaxPort:      uint16(cfg.RelayMaxPort),
+		},
+		allocations: allocs,
+	}
+	l := &listeners{allocations: allocs}
+
+	// Relayed addresses are IPv4 only, clients connecting over IPv6 are relayed to IPv4 peers.
+	udpNetworks := []string{"udp4"}
+	tcpNetwork, tcpHost := "tcp4", "0.0.0.0"
+	if cfg.IPv6 {
+		udpNetworks = append(udpNetworks, "udp6")
+		tcpNetwork, tcpHost = "tcp", "" // Dual stack.
+	}
+
+	for _, network := range udpNetworks {
+		host := "0.0.0.0"
+		if network == "udp6" {
+			host = "::"
+		}
+		conn, err := net.ListenPacket(network, net.JoinHostPort(host, strconv.Itoa(cfg.Port)))
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("could not create %s listener: %w", network, err)
+		}
+		status := &listenerStatus{network: network, addr: conn.LocalAddr().String()}
+		l.closers = append(l.closers, conn)
+		l.statuses = append(l.statuses, status)
+		l.packetConnConfigs = append(l.packetConnConfigs, turn.PacketConnConfig{
+			PacketConn:            &inspectedPacketConn{PacketConn: conn, allocations: allocs, status: status},
+			RelayAddressGenerator: generator,
+		})
+		logger.Info().Str("host", host).Int("port", cfg.Port).Msgf("created %s listener", network)
+	}
+
+	if cfg.TCP {
+		listener, err := net.Listen(tcpNetwork, net.JoinHostPort(tcpHost, strconv.Itoa(cfg.Port)))
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("could not create tcp listener: %w", err)
+		}
+		l.addListener(tcpNetwork, listener, generator)
+		logger.Info().Str("host", tcpHost).Int("port", cfg.Port).Msgf("created %s listener", tcpNetwork)
+	}
+
+	if cfg.TLSPort != 0 {
+		reloader, err := tlsx.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		config, err := tlsx.ServerConfig(reloader, "")
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		listener, err := tls.Listen(tcpNetwork, net.JoinHostPort(tcpHost, strconv.Itoa(cfg.TLSPort)), config)
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("could not create tls listener: %w", err)
+		}
+		l.addListener("tls", listener, generator)
+		go reloader.Watch(ctx, certReloadInterval, func(err error) {
+			if err != nil {
+				logger.Err(err).Msg("could not reload TLS certificate")
+				return
+			}
+			logger.Info().Msg("reloaded TLS certificate")
+		})
+		logger.Info().Str("host", tcpHost).Int("port", cfg.TLSPort).Msg("created tls listener")
+	}
+	return l, nil
+}
+
+func (l *listeners) addListener(network string, listener net.Listener, generator turn.RelayAddressGenerator) {
+	status := &listenerStatus{network: network, addr: listener.Addr().String()}
+	l.closers = append(l.closers, listener)
+	l.statuses = append(l.statuses, status)
+	l.listenerConfigs = append(l.listenerConfigs, turn.ListenerConfig{
+		Listener:              &inspectedListener{Listener: listener, allocations: l.allocations, status: status},
+		RelayAddressGenerator: generator,
+	})
+}
+
+func (l *listeners) close() {
+	for _, c := range l.closers {
+		_ = c.Close()
+	}
+}
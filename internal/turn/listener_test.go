@@ -0,0 +1,90 @@
+package turn
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/pion/turn/v2"
+	"github.com/rs/zerolog"
+)
+
+// freePort returns a port free on both udp4 and tcp4 by the time it's checked.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestListenTCP(t *testing.T) {
+	cfg := &ConfigOptions{
+		PublicIP:     "127.0.0.1",
+		Port:         freePort(t),
+		Realm:        "example.com",
+		Username:     "user",
+		Password:     "password",
+		RelayMinPort: 40000,
+		RelayMaxPort: 40100,
+		TCP:          true,
+	}
+	startServer(t, cfg, nil)
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Port))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       "user",
+		Password:       "password",
+		Realm:          cfg.Realm,
+		Conn:           turn.NewSTUNConn(conn),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	relayConn, err := client.Allocate()
+	if err != nil {
+		t.Fatalf("could not allocate over TCP: %v", err)
+	}
+	defer relayConn.Close()
+	if port := relayConn.LocalAddr().(*net.UDPAddr).Port; port < 40000 || port > 40100 {
+		t.Fatalf("got relay port %d out of range", port)
+	}
+}
+
+func TestListenClosesOnError(t *testing.T) {
+	logger := zerolog.Nop()
+	cfg := &ConfigOptions{
+		PublicIP:     "127.0.0.1",
+		Port:         freePort(t),
+		RelayMinPort: 40000,
+		RelayMaxPort: 40100,
+		TLSPort:      freePort(t),
+		TLSCertFile:  "testdata/missing.pem",
+		TLSKeyFile:   "testdata/missing.key",
+	}
+	if _, err := listen(context.Background(), cfg, newAllocations(cfg, nil, &logger), &logger); err == nil {
+		t.Fatal("listening without TLS certificate")
+	}
+	// UDP listener created before TLS listener fails is closed.
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(cfg.Port)))
+	if err != nil {
+		t.Fatalf("udp4 listener is not closed: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,50 @@
+package turn
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	allocationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "allocations_total",
+		Help:      "Number of relay allocations created.",
+	})
+
+	activeAllocations = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "active_allocations",
+		Help:      "Number of active relay allocations.",
+	})
+
+	allocationsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "allocations_rejected_total",
+		Help:      "Number of allocate requests rejected by allocation quota of their users.",
+	})
+
+	allocationsReaped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "allocations_reaped_total",
+		Help:      "Number of idle allocations deleted.",
+	})
+
+	relayedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "relayed_bytes_total",
+		Help:      "Bytes relayed, sent from clients to peers or received from peers to clients.",
+	}, []string{"direction"})
+
+	droppedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "charoite",
+		Subsystem: "turn",
+		Name:      "dropped_packets_total",
+		Help:      "Relayed packets dropped over bandwidth limit of their allocations.",
+	}, []string{"direction"})
+)
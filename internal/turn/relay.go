@@ -0,0 +1,144 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/turn/v2"
+)
+
+// relayAddressGenerator counts relay allocations of the underlining RelayAddressGenerator,
+// and registers their relay conns in allocations.
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	allocations *allocations
+}
+
+func (g *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	allocationsTotal.Inc()
+	activeAllocations.Inc()
+	return g.allocations.add(conn, addr), addr, nil
+}
+
+// relayConn is a relay PacketConn of an allocation, it's closed when allocation is deleted,
+// or to delete the allocation, e.g. after it's idle.
+// Sent bytes are relayed from client to peers, received bytes are relayed from peers to client.
+type relayConn struct {
+	net.PacketConn
+	closeOnce sync.Once
+	onClose   func(c *relayConn)
+
+	port      int
+	createdAt time.Time
+
+	// sendLimit and recvLimit cap bandwidth of each direction, they're nil if bandwidth is not limited.
+	sendLimit *tokenBucket
+	recvLimit *tokenBucket
+
+	lastActive    atomic.Int64 // Unix nanoseconds of the latest relayed packet.
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+
+	// client is who the allocation belongs to, it's known after allocate response is sent.
+	mu     sync.Mutex
+	client allocationClient
+}
+
+// allocationClient identifies client of an allocation.
+type allocationClient struct {
+	username   string
+	clientAddr string
+	relayAddr  string
+}
+
+// ReadFrom drops packets from peers over bandwidth limit.
+func (c *relayConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if c.recvLimit != nil && !c.recvLimit.allow(n, time.Now()) {
+			droppedPackets.WithLabelValues("receive").Inc()
+			continue
+		}
+		c.lastActive.Store(time.Now().UnixNano())
+		c.bytesReceived.Add(uint64(n))
+		relayedBytes.WithLabelValues("receive").Add(float64(n))
+		return n, addr, nil
+	}
+}
+
+// WriteTo drops packets to peers over bandwidth limit, as if they're lost in network.
+func (c *relayConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.sendLimit != nil && !c.sendLimit.allow(len(p), time.Now()) {
+		droppedPackets.WithLabelValues("send").Inc()
+		return len(p), nil
+	}
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if err != nil {
+		return n, err
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	c.bytesSent.Add(uint64(n))
+	relayedBytes.WithLabelValues("send").Add(float64(n))
+	return n, nil
+}
+
+func (c *relayConn) Close() error {
+	c.closeOnce.Do(func() {
+		activeAllocations.Dec()
+		c.onClose(c)
+	})
+	return c.PacketConn.Close()
+}
+
+func (c *relayConn) setClient(client allocationClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = client
+}
+
+func (c *relayConn) getClient() allocationClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// tokenBucket limits bytes per second with burst of a second.
+type tokenBucket struct {
+	rate float64 // Bytes per second.
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bitsPerSecond int) *tokenBucket {
+	rate := float64(bitsPerSecond) / 8
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// allow reports whether n bytes can be passed at now, and takes them from bucket if so.
+func (b *tokenBucket) allow(n int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
@@ -1,49 +1,73 @@
 package turn
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
-	"strconv"
+	"net/http"
+	"time"
 
 	"github.com/pion/turn/v2"
 	"github.com/rs/zerolog"
 )
 
-func Serve(logger *zerolog.Logger, cfg *ConfigOptions) (*turn.Server, error) {
-	udpListener, err := net.ListenPacket("udp4", "0.0.0.0:"+strconv.Itoa(cfg.Port))
+// Serve starts a TURN server and blocks until ctx is done, then the server is closed within cfg.ShutdownTimeout.
+func Serve(ctx context.Context, logger *zerolog.Logger, cfg *ConfigOptions) error {
+	if cfg.AdminAddr != "" {
+		if err := checkAdminAddr(cfg.AdminAddr, cfg.AdminToken); err != nil {
+			return err
+		}
+	}
+	auth, err := newAuthenticator(cfg, logger)
 	if err != nil {
-		return nil, fmt.Errorf("could not create udp4 listener: %w", err)
+		return err
+	}
+	if auth.users != nil {
+		go auth.users.watch(ctx, usersReloadInterval, logger)
 	}
-	logger.Info().Str("host", "0.0.0.0").Int("port", cfg.Port).Msg("created udp4 listener")
+	logger.Info().
+		Bool("static_user", auth.staticKey != nil).
+		Str("users_file", cfg.UsersFile).
+		Bool("rest_credentials", cfg.SharedSecret != "").
+		Msg("configured TURN authentication")
 
-	// Cache users for easy lookup later
-	// If passwords are stored they should be saved to your DB hashed using turn.GenerateAuthKey
-	usersMap := make(map[string][]byte)
-	usersMap[cfg.Username] = turn.GenerateAuthKey(cfg.Username, cfg.Realm, cfg.Password)
+	var usage *usageLog
+	if cfg.UsageLogFile != "" {
+		if usage, err = openUsageLog(cfg.UsageLogFile); err != nil {
+			return err
+		}
+		defer func() {
+			if err := usage.close(); err != nil {
+				logger.Err(err).Msg("could not close usage log")
+			}
+		}()
+	}
+	allocs := newAllocations(cfg, usage, logger)
+	if cfg.IdleTimeout > 0 {
+		go allocs.reap(ctx, cfg.IdleTimeout)
+	}
+	logger.Info().
+		Int("max_allocations_per_user", cfg.MaxAllocationsPerUser).
+		Int("allocation_bandwidth", cfg.AllocationBandwidth).
+		Dur("idle_timeout", cfg.IdleTimeout).
+		Str("usage_log_file", cfg.UsageLogFile).
+		Msg("configured allocation limits")
 
+	l, err := listen(ctx, cfg, allocs, logger)
+	if err != nil {
+		return err
+	}
 	s, err := turn.NewServer(turn.ServerConfig{
-		LoggerFactory: adapter(&pionLogger{logger}),
-		Realm:         cfg.Realm,
-		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
-			if key, ok := usersMap[username]; ok {
-				return key, true
-			}
-			return nil, false
-		},
-		PacketConnConfigs: []turn.PacketConnConfig{
-			{
-				PacketConn: udpListener,
-				RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
-					RelayAddress: net.ParseIP(cfg.PublicIP), // Claim that we are listening on IP passed by user (This should be your Public IP)
-					Address:      "0.0.0.0",                 // But actually be listening on every interface
-					MinPort:      uint16(cfg.RelayMinPort),
-					MaxPort:      uint16(cfg.RelayMaxPort),
-				},
-			},
-		},
+		LoggerFactory:     adapter(&pionLogger{logger}),
+		Realm:             cfg.Realm,
+		AuthHandler:       auth.authHandler,
+		PacketConnConfigs: l.packetConnConfigs,
+		ListenerConfigs:   l.listenerConfigs,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not create TURN server: %w", err)
+		l.close()
+		return fmt.Errorf("could not create TURN server: %w", err)
 	}
 	logger.Info().
 		Uint("min_port", cfg.RelayMinPort).
@@ -51,5 +75,53 @@ func Serve(logger *zerolog.Logger, cfg *ConfigOptions) (*turn.Server, error) {
 		Str("public_ip", cfg.PublicIP).
 		Msg("started turn server")
 
-	return s, nil
+	var adminServer *http.Server
+	if cfg.AdminAddr != "" {
+		if adminServer, err = serveAdmin(cfg, &admin{allocations: allocs, listeners: l, token: cfg.AdminToken, logger: logger}, logger); err != nil {
+			_ = s.Close()
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	logger.Info().Msg("shutting down turn server")
+
+	if adminServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Err(err).Msg("could not shut down admin HTTP server gracefully")
+		}
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Close()
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(cfg.ShutdownTimeout):
+		return errors.New("timed out closing turn server")
+	}
+}
+
+// serveAdmin starts admin HTTP server on cfg.AdminAddr, it's shut down by the caller.
+func serveAdmin(cfg *ConfigOptions, a *admin, logger *zerolog.Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", cfg.AdminAddr)
+	if err != nil {
+		return nil, fmt.Errorf("could not create admin HTTP listener: %w", err)
+	}
+	server := &http.Server{
+		Handler:      a.router(),
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logger.Err(err).Msg("admin HTTP server exited")
+		}
+	}()
+	logger.Info().Str("addr", listener.Addr().String()).Bool("token", cfg.AdminToken != "").Msg("started admin HTTP server")
+	return server, nil
 }
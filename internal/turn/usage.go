@@ -0,0 +1,56 @@
+package turn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// usageRecord is a line of usage log written after an allocation is deleted.
+type usageRecord struct {
+	Username      string    `json:"username"`
+	ClientAddr    string    `json:"client_addr"`
+	RelayAddr     string    `json:"relay_addr"`
+	CreatedAt     time.Time `json:"created_at"`
+	DeletedAt     time.Time `json:"deleted_at"`
+	BytesSent     uint64    `json:"bytes_sent"`     // Relayed from client to peers.
+	BytesReceived uint64    `json:"bytes_received"` // Relayed from peers to client.
+}
+
+// usageLog appends a JSON line of usage to file for each allocation deleted, so that relayed bytes are accounted to users.
+type usageLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func openUsageLog(name string) (*usageLog, error) {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open usage log: %w", err)
+	}
+	return &usageLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (l *usageLog) write(c *relayConn, deletedAt time.Time) error {
+	client := c.getClient()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.encoder.Encode(&usageRecord{
+		Username:      client.username,
+		ClientAddr:    client.clientAddr,
+		RelayAddr:     client.relayAddr,
+		CreatedAt:     c.createdAt,
+		DeletedAt:     deletedAt,
+		BytesSent:     c.bytesSent.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+	})
+}
+
+func (l *usageLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -5,11 +5,14 @@ package mqttclient
 
 import (
 	"context"
+	"fmt"
 	stdlog "log"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/SB-IM/charoite/pkg/tlsx"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
@@ -56,19 +59,30 @@ var (
 
 // ConfigOptions is config options for an MQTT client.
 type ConfigOptions struct {
-	Server   string
+	Server   string // Broker URL, ssl:// and wss:// brokers are connected over TLS.
 	ClientID string
 	Username string
 	Password string
+
+	// TLS options of ssl:// and wss:// brokers.
+	CAFile             string // PEM CAs verifying broker, empty uses system CAs
+	CertFile           string // PEM client certificate for mutual TLS, empty presents no certificate
+	KeyFile            string // PEM private key of client certificate
+	InsecureSkipVerify bool   // Don't verify broker certificate, for testing only
 }
 
-func NewClient(ctx context.Context, config ConfigOptions) mqtt.Client {
+// NewClient returns an MQTT client of config, it's not connected yet.
+// TLS is configured for ssl://, tls://, mqtts:// and wss:// brokers, it's an error to set TLS options for other brokers.
+func NewClient(ctx context.Context, config ConfigOptions) (mqtt.Client, error) {
 	// Set global logger.
 	setLogger(ctx)
 
 	opts := mqtt.NewClientOptions()
 
 	// The following optins are set in additions to package defaults.
+	if err := configureTLS(opts, &config); err != nil {
+		return nil, err
+	}
 	opts.AddBroker(config.Server)
 	opts.SetClientID(config.ClientID + "-" + uuid.NewString())
 
@@ -88,7 +102,33 @@ func NewClient(ctx context.Context, config ConfigOptions) mqtt.Client {
 	// Automate connection management (will keep trying to connect and will reconnect if network drops)
 	opts.ConnectRetry = true
 
-	return mqtt.NewClient(opts)
+	return mqtt.NewClient(opts), nil
+}
+
+// configureTLS sets TLS config of secure brokers.
+func configureTLS(opts *mqtt.ClientOptions, config *ConfigOptions) error {
+	u, err := url.Parse(config.Server)
+	if err != nil {
+		return fmt.Errorf("could not parse MQTT server: %w", err)
+	}
+	switch u.Scheme {
+	case "ssl", "tls", "mqtts", "wss":
+	case "tcp", "mqtt", "ws":
+		if config.CAFile != "" || config.CertFile != "" || config.KeyFile != "" || config.InsecureSkipVerify {
+			return fmt.Errorf("TLS options are set for %s:// MQTT server, use ssl:// or wss://", u.Scheme)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported MQTT server scheme: %q", u.Scheme)
+	}
+
+	tlsConfig, err := tlsx.ClientConfig(config.CAFile, config.CertFile, config.KeyFile, config.InsecureSkipVerify)
+	if err != nil {
+		return fmt.Errorf("could not create MQTT TLS config: %w", err)
+	}
+	opts.SetTLSConfig(tlsConfig)
+	log.Info().Str("server", config.Server).Bool("client_certificate", config.CertFile != "").Msg("connecting to MQTT broker over TLS")
+	return nil
 }
 
 // setLogger sets a customized input logger for MQTT client from context.
@@ -22,7 +22,10 @@ func ExampleMQTTClient() {
 	}()
 
 	ctx := log.Logger.WithContext(context.Background())
-	client := mc.NewClient(ctx, mc.ConfigOptions{})
+	client, err := mc.NewClient(ctx, mc.ConfigOptions{Server: "tcp://localhost:1883"})
+	if err != nil {
+		panic(err)
+	}
 
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		panic(token.Error())
@@ -10,10 +10,34 @@ import (
 
 func TestMQTTClientCtx(t *testing.T) {
 	ctx := log.Logger.WithContext(context.Background())
-	client := mc.NewClient(ctx, mc.ConfigOptions{})
+	client, err := mc.NewClient(ctx, mc.ConfigOptions{Server: "tcp://localhost:1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
 	newCtx := mc.WithContext(ctx, client)
 	oldClient := mc.FromContext(newCtx)
 	if oldClient == nil {
 		t.Fatalf("old client should not be nil")
 	}
 }
+
+func TestNewClientTLS(t *testing.T) {
+	ctx := log.Logger.WithContext(context.Background())
+	for _, tc := range []struct {
+		name   string
+		config mc.ConfigOptions
+		ok     bool
+	}{
+		{"plain broker", mc.ConfigOptions{Server: "tcp://localhost:1883"}, true},
+		{"TLS broker with system CAs", mc.ConfigOptions{Server: "ssl://localhost:8883"}, true},
+		{"websocket TLS broker", mc.ConfigOptions{Server: "wss://localhost:443/mqtt", InsecureSkipVerify: true}, true},
+		{"TLS options for plain broker", mc.ConfigOptions{Server: "tcp://localhost:1883", CAFile: "ca.pem"}, false},
+		{"missing CA file", mc.ConfigOptions{Server: "ssl://localhost:8883", CAFile: "no-such-ca.pem"}, false},
+		{"certificate without key", mc.ConfigOptions{Server: "ssl://localhost:8883", CertFile: "client.pem"}, false},
+		{"unknown scheme", mc.ConfigOptions{Server: "http://localhost:1883"}, false},
+	} {
+		if _, err := mc.NewClient(ctx, tc.config); (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// rtpcodec inspects RTP payloads of video codecs, it's shared by edge livestream and cloud broadcast.
+package rtpcodec
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// KeyFrameStart returns a function reporting whether a payload of video MIME type starts a key frame.
+// It returns nil for audio or unknown video codec.
+func KeyFrameStart(mimeType string) func(payload []byte) bool {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return H264KeyFrameStart
+	case strings.ToLower(webrtc.MimeTypeH265):
+		return H265KeyFrameStart
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return VP8KeyFrameStart
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return VP9KeyFrameStart
+	default:
+		return nil
+	}
+}
+
+// h264NALUTypes appends types of NAL units in an H.264 RTP payload to types.
+// Only the first fragment of a fragmentation unit reports its type.
+func h264NALUTypes(payload, types []byte) []byte {
+	if len(payload) == 0 {
+		return types
+	}
+	switch typ := payload[0] & 0x1f; typ {
+	case 24: // STAP-A.
+		for b := payload[1:]; len(b) > 2; {
+			size := int(binary.BigEndian.Uint16(b))
+			if size == 0 || len(b) < 2+size {
+				break
+			}
+			types = append(types, b[2]&0x1f)
+			b = b[2+size:]
+		}
+		return types
+	case 28: // FU-A.
+		if len(payload) > 1 && payload[1]&0x80 != 0 {
+			return append(types, payload[1]&0x1f)
+		}
+		return types
+	default:
+		return append(types, typ)
+	}
+}
+
+// H264KeyFrameStart reports whether an H.264 payload starts a key frame with SPS or IDR.
+func H264KeyFrameStart(payload []byte) bool {
+	for _, typ := range h264NALUTypes(payload, make([]byte, 0, 8)) {
+		if typ == 5 || typ == 7 {
+			return true
+		}
+	}
+	return false
+}
+
+// H264ParamSets reports whether an H.264 payload carries SPS or PPS only.
+func H264ParamSets(payload []byte) bool {
+	types := h264NALUTypes(payload, make([]byte, 0, 8))
+	for _, typ := range types {
+		if typ != 7 && typ != 8 {
+			return false
+		}
+	}
+	return len(types) > 0
+}
+
+// h265NALUTypes appends types of NAL units in an H.265 RTP payload to types.
+// Only the first fragment of a fragmentation unit reports its type.
+func h265NALUTypes(payload, types []byte) []byte {
+	if len(payload) < 2 {
+		return types
+	}
+	switch typ := payload[0] >> 1 & 0x3f; typ {
+	case 48: // Aggregation packet.
+		for b := payload[2:]; len(b) > 2; {
+			size := int(binary.BigEndian.Uint16(b))
+			if size == 0 || len(b) < 2+size {
+				break
+			}
+			types = append(types, b[2]>>1&0x3f)
+			b = b[2+size:]
+		}
+		return types
+	case 49: // Fragmentation unit.
+		if len(payload) > 2 && payload[2]&0x80 != 0 {
+			return append(types, payload[2]&0x3f)
+		}
+		return types
+	default:
+		return append(types, typ)
+	}
+}
+
+// H265KeyFrameStart reports whether an H.265 payload starts a key frame with VPS or IRAP picture.
+func H265KeyFrameStart(payload []byte) bool {
+	for _, typ := range h265NALUTypes(payload, make([]byte, 0, 8)) {
+		if typ == 32 || (typ >= 16 && typ <= 21) {
+			return true
+		}
+	}
+	return false
+}
+
+// H265ParamSets reports whether an H.265 payload carries VPS, SPS or PPS only.
+func H265ParamSets(payload []byte) bool {
+	types := h265NALUTypes(payload, make([]byte, 0, 8))
+	for _, typ := range types {
+		if typ < 32 || typ > 34 {
+			return false
+		}
+	}
+	return len(types) > 0
+}
+
+// VP8KeyFrameStart reports whether a VP8 payload starts a key frame.
+// See: https://datatracker.ietf.org/doc/html/rfc7741#section-4.2
+func VP8KeyFrameStart(payload []byte) bool {
+	if len(payload) < 1 || payload[0]&0x10 == 0 || payload[0]&0x07 != 0 {
+		// Not start of partition 0.
+		return false
+	}
+	i := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < 2 {
+			return false
+		}
+		ext := payload[1]
+		i++
+		if ext&0x80 != 0 { // Picture ID.
+			if len(payload) < i+1 {
+				return false
+			}
+			if payload[i]&0x80 != 0 {
+				i++
+			}
+			i++
+		}
+		if ext&0x40 != 0 { // TL0PICIDX.
+			i++
+		}
+		if ext&0x30 != 0 { // TID or KEYIDX.
+			i++
+		}
+	}
+	return len(payload) > i && payload[i]&0x01 == 0
+}
+
+// VP9KeyFrameStart reports whether a VP9 payload starts a picture not predicted from previous pictures.
+// See: https://datatracker.ietf.org/doc/html/draft-ietf-payload-vp9-16#section-4.2
+func VP9KeyFrameStart(payload []byte) bool {
+	return len(payload) > 0 && payload[0]&0x40 == 0 && payload[0]&0x08 != 0
+}
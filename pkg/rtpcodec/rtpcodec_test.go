@@ -0,0 +1,21 @@
+package rtpcodec
+
+import "testing"
+
+func TestVP8KeyFrameStart(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		want    bool
+	}{
+		{"key frame", []byte{0x10, 0x00}, true},
+		{"inter frame", []byte{0x10, 0x01}, false},
+		{"continuation", []byte{0x00, 0x00}, false},
+		{"key frame with 15 bits picture id", []byte{0x90, 0x80, 0x81, 0x02, 0x00}, true},
+		{"inter frame with picture id and tl0picidx", []byte{0x90, 0xc0, 0x01, 0x02, 0x01}, false},
+	} {
+		if got := VP8KeyFrameStart(tc.payload); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
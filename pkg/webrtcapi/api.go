@@ -0,0 +1,159 @@
+// webrtcapi builds webRTC API shared by edge livestream and cloud broadcast,
+// so that both peers register the same codecs and interceptors.
+package webrtcapi
+
+import (
+	"fmt"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/webrtc/v3"
+)
+
+// H265PayloadType is payload type of H.265 codec, it's not used by any default codec of pion.
+const H265PayloadType = 116
+
+// DefaultNACKBufferSize is number of packets kept for every stream sent to answer NACKs, the same as pion's.
+const DefaultNACKBufferSize = 1024
+
+// options are interceptors registered in addition to or in place of the default ones.
+type options struct {
+	nackBufferSize int
+	nackObserver   interceptor.Factory
+
+	interceptors []interceptor.Factory
+
+	initialBitrate int
+	onNewEstimator func(estimator cc.BandwidthEstimator)
+}
+
+// Option configures interceptors of a webRTC API.
+type Option func(o *options)
+
+// WithNACK keeps size packets of every stream sent to answer NACKs of remote peer,
+// and NACKs packets lost in a window of the same size for every stream received.
+// Size must be a power of two up to 32768.
+// Observer is registered right before NACK interceptors, so that it reads NACKs received,
+// and writes NACKs sent and packets retransmitted. It may be nil.
+func WithNACK(size int, observer interceptor.Factory) Option {
+	return func(o *options) {
+		o.nackBufferSize = size
+		o.nackObserver = observer
+	}
+}
+
+// WithInterceptor registers an interceptor after all the others, so that it's the first to write packets sent,
+// and packets it drops are neither kept for retransmission nor counted by congestion controller.
+func WithInterceptor(factory interceptor.Factory) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, factory)
+	}
+}
+
+// WithBandwidthEstimator estimates bandwidth of a peer sending media by transport-wide congestion control feedback.
+// Estimator of every peer connection created by the API is passed to onNew before the peer connection is returned.
+// Estimation starts at initialBitrate in bits per second.
+func WithBandwidthEstimator(initialBitrate int, onNew func(estimator cc.BandwidthEstimator)) Option {
+	return func(o *options) {
+		o.initialBitrate = initialBitrate
+		o.onNewEstimator = onNew
+	}
+}
+
+// New returns a webRTC API with default codecs and interceptors, the same as what webrtc.NewPeerConnection uses.
+// H.265 and header extensions of simulcast are registered additionally as they are not defaults of pion.
+func New(opts ...Option) (*webrtc.API, error) {
+	o := options{nackBufferSize: DefaultNACKBufferSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.nackBufferSize <= 0 || o.nackBufferSize&(o.nackBufferSize-1) != 0 || o.nackBufferSize > 1<<15 {
+		return nil, fmt.Errorf("NACK buffer size %d is not a power of two up to 32768", o.nackBufferSize)
+	}
+
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("could not register default codecs: %w", err)
+	}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeH265,
+			ClockRate: 90000,
+			RTCPFeedback: []webrtc.RTCPFeedback{
+				{Type: "goog-remb"},
+				{Type: "ccm", Parameter: "fir"},
+				{Type: "nack"},
+				{Type: "nack", Parameter: "pli"},
+				{Type: "transport-cc"},
+			},
+		},
+		PayloadType: H265PayloadType,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, fmt.Errorf("could not register H.265 codec: %w", err)
+	}
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(m); err != nil {
+		return nil, fmt.Errorf("could not register simulcast header extensions: %w", err)
+	}
+
+	i := &interceptor.Registry{}
+	if err := registerInterceptors(m, i, &o); err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), nil
+}
+
+// registerInterceptors registers default interceptors of pion, with NACK interceptors configured by options,
+// a congestion controller if bandwidth estimator is wanted, and interceptors of options at last.
+func registerInterceptors(m *webrtc.MediaEngine, i *interceptor.Registry, o *options) error {
+	if o.nackObserver != nil {
+		i.Add(o.nackObserver)
+	}
+	generator, err := nack.NewGeneratorInterceptor(nack.GeneratorSize(uint16(o.nackBufferSize)))
+	if err != nil {
+		return fmt.Errorf("could not create NACK generator: %w", err)
+	}
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(uint16(o.nackBufferSize)))
+	if err != nil {
+		return fmt.Errorf("could not create NACK responder: %w", err)
+	}
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	i.Add(responder)
+	i.Add(generator)
+
+	if err := webrtc.ConfigureRTCPReports(i); err != nil {
+		return fmt.Errorf("could not register RTCP reports: %w", err)
+	}
+	if err := webrtc.ConfigureTWCCSender(m, i); err != nil {
+		return fmt.Errorf("could not register TWCC sender: %w", err)
+	}
+
+	if o.onNewEstimator != nil {
+		if err := registerCongestionController(m, i, o); err != nil {
+			return err
+		}
+	}
+	for _, factory := range o.interceptors {
+		i.Add(factory)
+	}
+	return nil
+}
+
+// registerCongestionController registers a congestion controller estimating bandwidth by transport-wide feedback.
+func registerCongestionController(m *webrtc.MediaEngine, i *interceptor.Registry, o *options) error {
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		// Packets are sent as soon as they are forwarded, estimation only decides what to forward.
+		return gcc.NewSendSideBWE(gcc.SendSideBWEInitialBitrate(o.initialBitrate), gcc.SendSideBWEPacer(gcc.NewNoOpPacer()))
+	})
+	if err != nil {
+		return fmt.Errorf("could not create congestion controller: %w", err)
+	}
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		o.onNewEstimator(estimator)
+	})
+	i.Add(congestionController)
+	return webrtc.ConfigureTWCCHeaderExtensionSender(m, i)
+}